@@ -51,8 +51,10 @@ SMS_PORT=${SMS_SEND_PORT:-1285}
 echo "短信/来电转发服务端口设置为: ${SMS_PORT}"
 NEW_FORWARD_URL="http://127.0.0.1:${SMS_PORT}/api/v1/sms/receive"
 NEW_CALL_FORWARD_URL="http://127.0.0.1:${SMS_PORT}/api/v1/call/receive"
+NEW_USSD_FORWARD_URL="http://127.0.0.1:${SMS_PORT}/api/v1/ussd/receive"
 sed -i "/^FORWARD_URL=/c\FORWARD_URL=${NEW_FORWARD_URL}" /etc/asterisk/extensions_custom.conf
 sed -i "/^CALL_FORWARD_URL=/c\CALL_FORWARD_URL=${NEW_CALL_FORWARD_URL}" /etc/asterisk/extensions_custom.conf
+sed -i "/^USSD_FORWARD_URL=/c\USSD_FORWARD_URL=${NEW_USSD_FORWARD_URL}" /etc/asterisk/extensions_custom.conf
 
 
 if [ -n "$PHONE_ID" ]; then
@@ -0,0 +1,46 @@
+#!/usr/bin/env php
+<?php
+// forward_event.php
+// 把来电、USSD 推送转发给 Go 服务：从标准输入读取不含 secret 的 JSON 请求体，
+// 配置了 INGEST_HMAC_SECRET 时对请求签名，否则在请求体中加入 secret
+
+if ($argc < 3) {
+    fwrite(STDERR, date('c') . " - Missing arguments. Usage: forward_event.php <secret> <url>\n");
+    exit(1);
+}
+
+list(, $secret, $forward_url) = $argv;
+
+$data = json_decode(trim(stream_get_contents(STDIN)), true);
+if (!is_array($data)) {
+    fwrite(STDERR, date('c') . " - Invalid JSON on stdin\n");
+    exit(1);
+}
+
+$headers = "Content-Type: application/json\r\nUser-Agent: Asterisk-Chan-Quectel/1.0";
+
+$hmac_secrets = array_filter(array_map('trim', explode(',', (string)getenv('INGEST_HMAC_SECRET'))));
+if (empty($hmac_secrets)) {
+    $data['secret'] = $secret;
+}
+$body = json_encode($data, JSON_UNESCAPED_UNICODE);
+if (!empty($hmac_secrets)) {
+    $ts = (string)time();
+    $signature = 'sha256=' . hash_hmac('sha256', $ts . '.' . $body, reset($hmac_secrets));
+    $headers .= "\r\nX-Signature-Timestamp: " . $ts . "\r\nX-Signature: " . $signature;
+}
+
+$options = [
+    'http' => [
+        'header'  => $headers,
+        'method'  => 'POST',
+        'content' => $body,
+        'timeout' => 10
+    ]
+];
+
+$context  = stream_context_create($options);
+$result = @file_get_contents($forward_url, false, $context);
+
+echo date('c') . " - Forward result: " . ($result ?: 'failed') . "\n";
+?>
@@ -29,11 +29,25 @@ $data = [
     'timestamp' => $time,
 ];
 
+$headers = "Content-Type: application/json";
+
+// 配置了 INGEST_HMAC_SECRET 时对请求签名，不再在请求体中携带明文 secret
+$hmac_secrets = array_filter(array_map('trim', explode(',', (string)getenv('INGEST_HMAC_SECRET'))));
+if (!empty($hmac_secrets)) {
+    unset($data['secret']);
+}
+$body = json_encode($data, JSON_UNESCAPED_UNICODE);
+if (!empty($hmac_secrets)) {
+    $ts = (string)time();
+    $signature = 'sha256=' . hash_hmac('sha256', $ts . '.' . $body, reset($hmac_secrets));
+    $headers .= "\r\nX-Signature-Timestamp: " . $ts . "\r\nX-Signature: " . $signature;
+}
+
 $options = [
     'http' => [
-        'header'  => "Content-Type: application/json",
+        'header'  => $headers,
         'method'  => 'POST',
-        'content' => json_encode($data, JSON_UNESCAPED_UNICODE),
+        'content' => $body,
         'timeout' => 30
     ]
 ];
@@ -253,9 +253,26 @@ if [ -f "/usr/local/bin/sms-gateway" ]; then
     # 使用 & 将进程放到后台运行
     # 将日志输出到 stdout/stderr，以便 docker logs 可以捕获
     /usr/local/bin/sms-gateway > /proc/1/fd/1 2>/proc/1/fd/2 &
+    SMS_GATEWAY_PID=$!
     echo "SMS Gateway service started."
 else
     echo "Warning: /usr/local/bin/sms-gateway not found, skipping startup."
 fi
 
-exec /usr/sbin/apachectl -DFOREGROUND
+# apache 在后台运行，以便 docker stop 的 SIGTERM 同时转发给短信网关，让它完成进行中的发送和通知后退出
+/usr/sbin/apachectl -DFOREGROUND &
+HTTPD_PID=$!
+stop_services() {
+    echo ">>> [Daemon] Stopping services..."
+    if [ -n "$SMS_GATEWAY_PID" ]; then
+        kill -TERM "$SMS_GATEWAY_PID" 2>/dev/null
+    fi
+    kill -TERM "$HTTPD_PID" 2>/dev/null
+    if [ -n "$SMS_GATEWAY_PID" ]; then
+        wait "$SMS_GATEWAY_PID"
+    fi
+    wait "$HTTPD_PID"
+    exit 0
+}
+trap stop_services TERM INT
+wait "$HTTPD_PID"
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 设备告警的检查项
+const (
+	AlertDisconnected  = "disconnected"   // 设备未连接或从 AMI 的设备列表中消失
+	AlertNotRegistered = "not_registered" // 未注册到网络
+	AlertLowSignal     = "low_signal"     // RSSI 低于 min_rssi
+	AlertSIMNotReady   = "sim_not_ready"  // 读不到 IMSI，SIM 卡未插入或未就绪
+)
+
+var allAlertChecks = []string{AlertDisconnected, AlertNotRegistered, AlertLowSignal, AlertSIMNotReady}
+
+var alertCheckNames = map[string]string{
+	AlertDisconnected:  "设备断开",
+	AlertNotRegistered: "未注册到网络",
+	AlertLowSignal:     "信号弱",
+	AlertSIMNotReady:   "SIM 卡未就绪",
+	AlertLowBalance:    "余额不足",
+	AlertSIMExpiring:   "SIM 卡即将到期",
+}
+
+const (
+	defaultAlertTriggerAfter = 3
+	defaultAlertClearAfter   = 3
+	defaultAlertMinRSSI      = 8
+	// rssiUnknown 是模块无法检测信号时上报的 RSSI
+	rssiUnknown = 99
+)
+
+// AlertConfig 是 forward.yaml 中的 alerts 段
+//
+//	alerts:
+//	  checks: disconnected, not_registered, low_signal, sim_not_ready
+//	  min_rssi: 8          # RSSI（0-31）低于该值视为信号弱
+//	  trigger_after: 3     # 连续几次轮询异常才告警
+//	  clear_after: 3       # 连续几次轮询正常才发送恢复通知
+//	  repeat_hours: 0      # 持续异常时每隔几小时重复告警，0 表示不重复
+//	  notify_recovery: true
+type AlertConfig struct {
+	Enabled        bool
+	Checks         []string
+	MinRSSI        int
+	TriggerAfter   int
+	ClearAfter     int
+	RepeatHours    int
+	NotifyRecovery bool
+}
+
+var alertConfig = defaultAlertConfig()
+
+func defaultAlertConfig() *AlertConfig {
+	return &AlertConfig{
+		Enabled:        true,
+		Checks:         allAlertChecks,
+		MinRSSI:        defaultAlertMinRSSI,
+		TriggerAfter:   defaultAlertTriggerAfter,
+		ClearAfter:     defaultAlertClearAfter,
+		NotifyRecovery: true,
+	}
+}
+
+// loadAlertConfig 解析 forward.yaml 中的 alerts 段，未配置时使用默认值。
+// 告警只发送给 events 包含 device_alert 的规则（type: all 且未声明 events 的规则也会收到）。
+func loadAlertConfig(v interface{}) *AlertConfig {
+	cfg := defaultAlertConfig()
+	section, ok := v.(map[string]interface{})
+	if !ok {
+		return cfg
+	}
+	if _, ok := section["enabled"]; ok {
+		cfg.Enabled = configBool(section["enabled"])
+	}
+	if checks := configStringList(section["checks"]); len(checks) > 0 {
+		cfg.Checks = nil
+		for _, check := range checks {
+			if !containsString(allAlertChecks, check) {
+				log.Warnf("未知的设备告警检查项: %s", check)
+				continue
+			}
+			cfg.Checks = append(cfg.Checks, check)
+		}
+	}
+	if _, ok := section["min_rssi"]; ok {
+		cfg.MinRSSI = configInt(section["min_rssi"])
+	}
+	if n := configInt(section["trigger_after"]); n > 0 {
+		cfg.TriggerAfter = n
+	}
+	if n := configInt(section["clear_after"]); n > 0 {
+		cfg.ClearAfter = n
+	}
+	cfg.RepeatHours = configInt(section["repeat_hours"])
+	if _, ok := section["notify_recovery"]; ok {
+		cfg.NotifyRecovery = configBool(section["notify_recovery"])
+	}
+	return cfg
+}
+
+// DeviceAlert 是一次告警或恢复
+type DeviceAlert struct {
+	Device    string    `json:"device"`
+	Check     string    `json:"check"`
+	Resolved  bool      `json:"resolved"`
+	Detail    string    `json:"detail"`
+	Since     time.Time `json:"since"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Message 返回通知正文
+func (a DeviceAlert) Message() string {
+	name := alertCheckNames[a.Check]
+	if a.Resolved {
+		return fmt.Sprintf("设备 %s %s已恢复：%s\n持续时间: %s", a.Device, name, a.Detail, a.CreatedAt.Sub(a.Since).Round(time.Second))
+	}
+	return fmt.Sprintf("设备 %s %s：%s\n开始时间: %s", a.Device, name, a.Detail, a.Since.Format("2006-01-02 15:04:05"))
+}
+
+// alertState 是一个设备一个检查项的滞回状态
+type alertState struct {
+	bad, good   int
+	active      bool
+	since       time.Time // 首次检测到异常的时间
+	lastAlerted time.Time
+}
+
+// AlertMonitor 根据每次轮询到的设备状态判断是否需要告警。
+// 异常需连续出现 trigger_after 次才告警，告警后需连续正常 clear_after 次才恢复，避免状态抖动时反复通知。
+type AlertMonitor struct {
+	mu     sync.Mutex
+	states map[string]*alertState // key 为 device + "/" + check
+	seen   map[string]bool        // 出现过的设备，用于发现从列表中消失的设备
+}
+
+var alertMonitor = &AlertMonitor{states: map[string]*alertState{}, seen: map[string]bool{}}
+
+// Observe 评估一次轮询结果，返回需要发送的告警和恢复通知
+func (m *AlertMonitor) Observe(cfg *AlertConfig, infos []*DeviceInfo, now time.Time) []DeviceAlert {
+	return m.observe(cfg, infos, "", now)
+}
+
+// ObserveAMIDown 在 AMI 登录或查询失败时调用：之前出现过的设备都按断开计数，
+// Asterisk 整体不可用时同样按 trigger_after/clear_after 发出断开告警和恢复通知
+func (m *AlertMonitor) ObserveAMIDown(cfg *AlertConfig, amiErr error, now time.Time) []DeviceAlert {
+	return m.observe(cfg, nil, "AMI 不可用: "+amiErr.Error(), now)
+}
+
+// observe 评估设备状态，missingDetail 不为空时作为不在列表中的设备的告警说明
+func (m *AlertMonitor) observe(cfg *AlertConfig, infos []*DeviceInfo, missingDetail string, now time.Time) []DeviceAlert {
+	if !cfg.Enabled {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := map[string]*DeviceInfo{}
+	for _, info := range infos {
+		current[info.Device] = info
+		m.seen[info.Device] = true
+	}
+	names := make([]string, 0, len(m.seen))
+	for name := range m.seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var alerts []DeviceAlert
+	for _, device := range names {
+		info := current[device]
+		for _, check := range cfg.Checks {
+			failing, detail, known := evaluateAlert(cfg, check, info)
+			if !known {
+				continue
+			}
+			if info == nil && missingDetail != "" {
+				detail = missingDetail
+			}
+			if a, ok := m.step(cfg, device, check, failing, detail, now); ok {
+				alerts = append(alerts, a)
+			}
+		}
+	}
+	return alerts
+}
+
+func (m *AlertMonitor) step(cfg *AlertConfig, device, check string, failing bool, detail string, now time.Time) (DeviceAlert, bool) {
+	key := device + "/" + check
+	st := m.states[key]
+	if st == nil {
+		st = &alertState{}
+		m.states[key] = st
+	}
+	alert := DeviceAlert{Device: device, Check: check, Detail: detail, CreatedAt: now}
+	if failing {
+		if st.bad == 0 {
+			st.since = now
+		}
+		st.bad++
+		st.good = 0
+		alert.Since = st.since
+		if !st.active && st.bad >= cfg.TriggerAfter {
+			st.active, st.lastAlerted = true, now
+			return alert, true
+		}
+		if st.active && cfg.RepeatHours > 0 && now.Sub(st.lastAlerted) >= time.Duration(cfg.RepeatHours)*time.Hour {
+			st.lastAlerted = now
+			return alert, true
+		}
+		return alert, false
+	}
+	st.good++
+	if !st.active {
+		st.bad = 0
+		return alert, false
+	}
+	if st.good < cfg.ClearAfter {
+		return alert, false
+	}
+	alert.Resolved, alert.Since = true, st.since
+	st.active, st.bad = false, 0
+	return alert, cfg.NotifyRecovery
+}
+
+// evaluateAlert 判断设备是否处于异常状态；info 为 nil 表示设备已不在 AMI 的设备列表中。
+// 设备断开时其他检查项无法判断，known 为 false，保持原有状态不变，避免断开期间误报恢复。
+func evaluateAlert(cfg *AlertConfig, check string, info *DeviceInfo) (failing bool, detail string, known bool) {
+	if info == nil {
+		return true, "设备不在 AMI 设备列表中", check == AlertDisconnected
+	}
+	connected := info.State != "" && !strings.EqualFold(info.State, "Not connected")
+	if check == AlertDisconnected {
+		return !connected, "状态 " + info.State, true
+	}
+	if !connected {
+		return false, "", false
+	}
+	switch check {
+	case AlertNotRegistered:
+		return !strings.HasPrefix(info.Registration, "Registered"), "注册状态 " + info.Registration, true
+	case AlertLowSignal:
+		rssi, ok := parseRSSI(info.RSSI)
+		return rssi == rssiUnknown || rssi < cfg.MinRSSI, fmt.Sprintf("RSSI %s（告警阈值 %d）", info.RSSI, cfg.MinRSSI), ok
+	case AlertSIMNotReady:
+		imsi := strings.TrimSpace(info.IMSI)
+		return imsi == "" || strings.EqualFold(imsi, "unknown"), "IMSI " + info.IMSI, true
+	}
+	return false, "", false
+}
+
+// parseRSSI 解析 "21, -71 dBm" 形式的 RSSI，返回 0-31 的等级（99 表示未知）
+func parseRSSI(s string) (int, bool) {
+	level, _, _ := strings.Cut(s, ",")
+	n, err := strconv.Atoi(strings.TrimSpace(level))
+	return n, err == nil
+}
+
+// dispatchDeviceAlerts 把告警发送给 events 包含 device_alert 的规则，并推送到实时事件
+func dispatchDeviceAlerts(alerts []DeviceAlert) {
+	for _, a := range alerts {
+		log.WithFields(log.Fields{"device": a.Device, "check": a.Check, "resolved": a.Resolved}).Warn(a.Message())
+		eventBus.Publish(StreamDeviceAlert, a.Device, a)
+		ev := ForwardEvent{Kind: EventDeviceAlert, Text: a.Message(), PhoneID: a.Device}
+		for _, r := range matchingRules(ev) {
+			log.Infof("触发设备告警规则: %s", r.Name)
+			r, a := r, a
+			notifyRule(r, ev.Kind, func() error { return sendDeviceAlertNotification(r.Settings, a) })
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlertMonitorAMIDown(t *testing.T) {
+	m := &AlertMonitor{states: map[string]*alertState{}, seen: map[string]bool{}}
+	cfg := &AlertConfig{Enabled: true, Checks: []string{AlertDisconnected}, TriggerAfter: 2, ClearAfter: 1, NotifyRecovery: true}
+	now := time.Now()
+	up := []*DeviceInfo{{Device: "quectel0", State: "Free"}}
+
+	if alerts := m.Observe(cfg, up, now); len(alerts) != 0 {
+		t.Fatalf("alerts while connected = %+v", alerts)
+	}
+	amiErr := errors.New("dial tcp 127.0.0.1:5038: connection refused")
+	if alerts := m.ObserveAMIDown(cfg, amiErr, now.Add(time.Minute)); len(alerts) != 0 {
+		t.Fatalf("alerted before trigger_after: %+v", alerts)
+	}
+	alerts := m.ObserveAMIDown(cfg, amiErr, now.Add(2*time.Minute))
+	if len(alerts) != 1 || alerts[0].Device != "quectel0" || alerts[0].Check != AlertDisconnected || alerts[0].Resolved || !strings.Contains(alerts[0].Detail, "AMI 不可用") {
+		t.Fatalf("alerts after AMI was down twice = %+v, want one disconnected alert for quectel0", alerts)
+	}
+	alerts = m.Observe(cfg, up, now.Add(3*time.Minute))
+	if len(alerts) != 1 || !alerts[0].Resolved {
+		t.Errorf("alerts after AMI recovered = %+v, want one recovery", alerts)
+	}
+}
@@ -158,3 +158,59 @@ func SendSMSShell(amiConfig *AMIConfig, device, recipient, message string) (stri
 	log.Printf("Embedded shell command executed successfully. Output: %s", string(output))
 	return string(output), nil
 }
+
+// amiSession is a long-lived AMI connection used for periodic queries such as device state polling.
+type amiSession struct {
+	socket *ami.Socket
+	uuid   string
+}
+
+// dialAMI connects and logs in to AMI with events disabled.
+func dialAMI(ctx context.Context, amiConfig *AMIConfig) (*amiSession, error) {
+	amiHost := fmt.Sprintf("%s:%s", amiConfig.Host, amiConfig.Port)
+	socket, err := ami.NewSocket(ctx, amiHost)
+	if err != nil {
+		return nil, fmt.Errorf("AMI connection failed: %w", err)
+	}
+	uuid, err := ami.GetUUID()
+	if err != nil {
+		socket.Close(ctx)
+		return nil, fmt.Errorf("failed to generate UUID for AMI action: %w", err)
+	}
+	if err := ami.Login(ctx, socket, amiConfig.Username, amiConfig.Secret, "Off", uuid); err != nil {
+		socket.Close(ctx)
+		return nil, fmt.Errorf("AMI login failed: %w", err)
+	}
+	return &amiSession{socket: socket, uuid: uuid}, nil
+}
+
+// Command runs an Asterisk CLI command and returns its output lines.
+func (s *amiSession) Command(ctx context.Context, cliCommand string) ([]string, error) {
+	response, err := ami.Command(ctx, s.socket, s.uuid, cliCommand)
+	if err != nil {
+		return nil, fmt.Errorf("AMI command execution failed: %w", err)
+	}
+	if r := response.Get("Response"); r != "Success" && r != "Follows" {
+		return nil, fmt.Errorf("AMI command %q failed: %s", cliCommand, response.Get("Message"))
+	}
+	return response["Output"], nil
+}
+
+// Close logs off and closes the connection.
+func (s *amiSession) Close(ctx context.Context) {
+	ami.Logoff(ctx, s.socket, s.uuid)
+	s.socket.Close(ctx)
+}
+
+// SendUSSDShell 通过 asterisk CLI 在设备上发起 USSD 请求，回复由拨号计划推送到 /api/v1/ussd/receive
+func SendUSSDShell(device, code string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	script := "asterisk -rx \"quectel ussd \\\"$1\\\" \\\"$2\\\"\""
+	output, err := exec.CommandContext(ctx, "sh", "-c", script, "_", device, code).CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("embedded shell command failed: %w", err)
+	}
+	log.Printf("USSD %s sent on %s. Output: %s", code, device, string(output))
+	return string(output), nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// 审计动作
+const (
+	AuditSMSSend        = "sms.send"
+	AuditSMSDelete      = "sms.delete"
+	AuditSMSRestore     = "sms.restore"
+	AuditSMSPurge       = "sms.purge"
+	AuditRetentionPurge = "retention.purge"
+	AuditHistoryImport  = "history.import"
+	AuditExport         = "history.export"
+	AuditSMSBackfill    = "sms.backfill"
+	AuditBalanceCheck   = "balance.check"
+	AuditKeepAlive      = "sim.keepalive"
+	AuditTelegramReply  = "sms.telegram_reply"
+	AuditLogin          = "auth.login"
+	AuditLogout         = "auth.logout"
+	AuditValidateSecret = "auth.validate_secret"
+	AuditUserCreate     = "user.create"
+	AuditUserUpdate     = "user.update"
+	AuditUserDelete     = "user.delete"
+	AuditTokenCreate    = "token.create"
+	AuditTokenRevoke    = "token.revoke"
+)
+
+// AuditEntry 是一条审计记录
+type AuditEntry struct {
+	ID        int       `json:"id"`
+	Actor     string    `json:"actor"`
+	ActorType string    `json:"actor_type"` // user / token / secret / signed / telegram / anonymous
+	ClientIP  string    `json:"client_ip"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Result    string    `json:"result"` // success / failure
+	Detail    string    `json:"detail"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ActorType 返回审计日志中使用的主体类型
+func (p *Principal) ActorType() string {
+	switch {
+	case p.UserID > 0:
+		return "user"
+	case p.TokenID > 0:
+		return "token"
+	case p.Username == "secret":
+		return "secret"
+	case p.Username == "signed":
+		return "signed"
+	}
+	return "anonymous"
+}
+
+func insertAuditLog(e AuditEntry) error {
+	query := `INSERT INTO audit_log (actor, actor_type, client_ip, action, target, result, detail) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	if _, err := db.Exec(query, e.Actor, e.ActorType, e.ClientIP, e.Action, e.Target, e.Result, e.Detail); err != nil {
+		return fmt.Errorf("failed to insert audit log: %w", err)
+	}
+	return nil
+}
+
+// recordAudit 以当前请求的认证主体记录一条审计日志
+func recordAudit(c *gin.Context, action, target string, success bool, detail string) {
+	p := currentPrincipal(c)
+	recordAuditAs(c, p.Username, p.ActorType(), action, target, success, detail)
+}
+
+// recordAuditAs 以指定主体记录审计日志，用于登录等尚未建立认证主体的场景
+func recordAuditAs(c *gin.Context, actor, actorType, action, target string, success bool, detail string) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	e := AuditEntry{
+		Actor:     actor,
+		ActorType: actorType,
+		ClientIP:  c.ClientIP(),
+		Action:    action,
+		Target:    target,
+		Result:    result,
+		Detail:    detail,
+	}
+	if err := insertAuditLog(e); err != nil {
+		log.Errorf("Failed to record audit log %s: %v", action, err)
+	}
+}
+
+// getAuditLogHandler 按条件分页查询审计日志
+func getAuditLogHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	where := " WHERE 1=1"
+	var args []interface{}
+	for _, f := range []struct{ param, column string }{
+		{"actor", "actor"},
+		{"actor_type", "actor_type"},
+		{"action", "action"},
+		{"result", "result"},
+		{"target", "target"},
+		{"client_ip", "client_ip"},
+	} {
+		if v := c.Query(f.param); v != "" {
+			where += " AND " + f.column + " = ?"
+			args = append(args, v)
+		}
+	}
+	for _, f := range []struct{ param, op string }{{"since", ">="}, {"until", "<="}} {
+		if v := c.Query(f.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: f.param + " must be RFC3339"})
+				return
+			}
+			where += " AND created_at " + f.op + " ?"
+			args = append(args, store.Dialect.TimeArg(t))
+		}
+	}
+
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM audit_log"+where, args...).Scan(&total); err != nil {
+		log.Errorf("Error counting audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to query audit log"})
+		return
+	}
+
+	query := "SELECT id, actor, actor_type, client_ip, action, target, result, COALESCE(detail, ''), created_at FROM audit_log" +
+		where + " ORDER BY id DESC LIMIT ? OFFSET ?"
+	rows, err := db.Query(query, append(args, limit, (page-1)*limit)...)
+	if err != nil {
+		log.Errorf("Error querying audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to query audit log"})
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.Actor, &e.ActorType, &e.ClientIP, &e.Action, &e.Target, &e.Result, &e.Detail, &e.CreatedAt); err != nil {
+			log.Errorf("Error scanning audit row: %v", err)
+			continue
+		}
+		entries = append(entries, e)
+	}
+
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: entries, Total: total})
+}
+
+// auditRetentionDays 审计日志保留天数，AUDIT_RETENTION_DAYS=0 表示永久保留
+func auditRetentionDays() int {
+	if v := os.Getenv("AUDIT_RETENTION_DAYS"); v != "" {
+		if days, err := strconv.Atoi(v); err == nil && days >= 0 {
+			return days
+		}
+	}
+	return 180
+}
+
+// purgeAuditLog 删除超过保留期的审计日志
+func purgeAuditLog(days int) (int64, error) {
+	res, err := db.Exec("DELETE FROM audit_log WHERE created_at < ?", store.Dialect.TimeArg(time.Now().AddDate(0, 0, -days)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge audit log: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// startAuditRetention 每天清理一次过期审计日志
+func startAuditRetention() {
+	days := auditRetentionDays()
+	if days == 0 {
+		log.Info("Audit log retention disabled, keeping all entries")
+		return
+	}
+	runBackground(func() {
+		for {
+			if n, err := purgeAuditLog(days); err != nil {
+				log.Errorf("Audit log retention failed: %v", err)
+			} else if n > 0 {
+				log.Infof("Purged %d audit log entries older than %d days", n, days)
+			}
+			if !sleepContext(24 * time.Hour) {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 用户角色
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleReadOnly = "readonly"
+)
+
+const (
+	sessionCookieName = "sms_session"
+	principalKey      = "principal"
+)
+
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+// errLastAdmin 表示修改或删除会让系统中没有管理员。users 非空时 bootstrapAdmin 不会再创建管理员，只能手动改库恢复
+var errLastAdmin = errors.New("at least one admin must remain")
+
+// User 是一个可以登录前端的账号
+type User struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	Devices   []string  `json:"devices"` // 允许查看和发送的设备/phone_id，为空表示不限制
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Principal 是当前请求的认证主体
+type Principal struct {
+	UserID   int      `json:"user_id,omitempty"`
+	TokenID  int      `json:"token_id,omitempty"`
+	Username string   `json:"username"`
+	Role     string   `json:"role"`
+	Devices  []string `json:"devices"`
+	Scopes   []string `json:"scopes"`
+}
+
+// UserRequest 创建或更新用户的请求结构
+type UserRequest struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Role     string   `json:"role"`
+	Devices  []string `json:"devices"`
+}
+
+// CanAccessDevice 判断主体是否可以查看或使用指定设备
+func (p *Principal) CanAccessDevice(device string) bool {
+	return len(p.Devices) == 0 || containsString(p.Devices, device)
+}
+
+func isValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleOperator || role == RoleReadOnly
+}
+
+// currentPrincipal 取出 authMiddleware 写入的认证主体
+func currentPrincipal(c *gin.Context) *Principal {
+	if v, ok := c.Get(principalKey); ok {
+		if p, ok := v.(*Principal); ok {
+			return p
+		}
+	}
+	return &Principal{Role: RoleReadOnly}
+}
+
+// allowedPhoneIDs 返回受设备限制的主体可访问的 phone_id，设备的别名和本机号码同样允许；不受限制时返回 nil
+func allowedPhoneIDs(p *Principal) []string {
+	var ids []string
+	for _, d := range p.Devices {
+		ids = append(ids, devices.Aliases(d)...)
+	}
+	return ids
+}
+
+// bootstrapAdmin 在没有任何用户时创建初始管理员。
+// 用户名取 ADMIN_USERNAME（默认 admin），密码取 ADMIN_PASSWORD，未设置时沿用 FORWARD_SECRET。
+func bootstrapAdmin() error {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		return fmt.Errorf("failed to count users: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	username := os.Getenv("ADMIN_USERNAME")
+	if username == "" {
+		username = "admin"
+	}
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		password = os.Getenv("FORWARD_SECRET")
+	}
+	if password == "" {
+		log.Warn("No users exist and neither ADMIN_PASSWORD nor FORWARD_SECRET is set. Web login is unavailable.")
+		return nil
+	}
+
+	if _, err := createUser(UserRequest{Username: username, Password: password, Role: RoleAdmin}); err != nil {
+		return err
+	}
+	log.Infof("Created initial admin user: %s", username)
+	return nil
+}
+
+func createUser(req UserRequest) (int, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, fmt.Errorf("failed to hash password: %w", err)
+	}
+	res, err := db.Exec("INSERT INTO users (username, password_hash, role, devices) VALUES (?, ?, ?, ?)",
+		req.Username, string(hash), req.Role, strings.Join(req.Devices, ","))
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert user: %w", err)
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+func updateUser(id int, req UserRequest) error {
+	if err := checkRemainingAdmin(id, req.Role); err != nil {
+		return err
+	}
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("failed to hash password: %w", err)
+		}
+		if _, err := db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", string(hash), id); err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+		// 修改密码后让该用户已有的会话全部失效
+		if _, err := db.Exec("DELETE FROM sessions WHERE user_id = ?", id); err != nil {
+			return fmt.Errorf("failed to revoke sessions: %w", err)
+		}
+	}
+	_, err := db.Exec("UPDATE users SET role = ?, devices = ? WHERE id = ?", req.Role, strings.Join(req.Devices, ","), id)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	return nil
+}
+
+// checkRemainingAdmin 在把用户 id 的角色改为 newRole（删除时为空）之前，确认之后至少还有一个管理员
+func checkRemainingAdmin(id int, newRole string) error {
+	if newRole == RoleAdmin {
+		return nil
+	}
+	var admins, target int
+	query := "SELECT COUNT(*), COALESCE(SUM(CASE WHEN id = ? THEN 1 ELSE 0 END), 0) FROM users WHERE role = ?"
+	if err := db.QueryRow(query, id, RoleAdmin).Scan(&admins, &target); err != nil {
+		return fmt.Errorf("failed to count admins: %w", err)
+	}
+	if target > 0 && admins == 1 {
+		return errLastAdmin
+	}
+	return nil
+}
+
+func deleteUser(id int) error {
+	if err := checkRemainingAdmin(id, ""); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM sessions WHERE user_id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	if _, err := db.Exec("DELETE FROM users WHERE id = ?", id); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
+}
+
+func scanUser(scanner interface{ Scan(...interface{}) error }) (*User, string, error) {
+	var u User
+	var hash, devices string
+	if err := scanner.Scan(&u.ID, &u.Username, &hash, &u.Role, &devices, &u.CreatedAt); err != nil {
+		return nil, "", err
+	}
+	u.Devices = configStringList(devices)
+	return &u, hash, nil
+}
+
+const userColumns = "id, username, password_hash, role, devices, created_at"
+
+func getUserByUsername(username string) (*User, string, error) {
+	return scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
+func getUserByID(id int) (*User, error) {
+	u, _, err := scanUser(db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	return u, err
+}
+
+func listUsers() ([]User, error) {
+	rows, err := db.Query("SELECT " + userColumns + " FROM users ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, _, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, *u)
+	}
+	return users, rows.Err()
+}
+
+// authenticateUser 校验用户名和密码
+func authenticateUser(username, password string) (*User, error) {
+	u, hash, err := getUserByUsername(username)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// 用户不存在时也做一次比对，避免通过响应时间枚举用户名
+			bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+			return nil, fmt.Errorf("invalid username or password")
+		}
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return nil, fmt.Errorf("invalid username or password")
+	}
+	return u, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func sessionTTL() time.Duration {
+	if hours, err := strconv.Atoi(os.Getenv("SESSION_TTL_HOURS")); err == nil && hours > 0 {
+		return time.Duration(hours) * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func createSession(userID int) (string, time.Time, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate session token: %w", err)
+	}
+	expiresAt := time.Now().Add(sessionTTL())
+	if _, err := db.Exec("INSERT INTO sessions (token_hash, user_id, expires_at) VALUES (?, ?, ?)", hashToken(token), userID, store.Dialect.TimeArg(expiresAt)); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to insert session: %w", err)
+	}
+	// 顺便清理过期会话
+	if _, err := db.Exec("DELETE FROM sessions WHERE expires_at < ?", store.Dialect.TimeArg(time.Now())); err != nil {
+		log.Warnf("Failed to clean expired sessions: %v", err)
+	}
+	return token, expiresAt, nil
+}
+
+// principalFromSession 通过会话 cookie 查找登录用户
+func principalFromSession(token string) (*Principal, error) {
+	var userID int
+	var expiresAt time.Time
+	err := db.QueryRow("SELECT user_id, expires_at FROM sessions WHERE token_hash = ?", hashToken(token)).Scan(&userID, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(expiresAt) {
+		return nil, fmt.Errorf("session expired")
+	}
+	u, err := getUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{UserID: u.ID, Username: u.Username, Role: u.Role, Devices: u.Devices}, nil
+}
+
+// cookieSecure 判断是否应为会话 cookie 设置 Secure 标志
+func cookieSecure(c *gin.Context) bool {
+	if v := os.Getenv("SESSION_COOKIE_SECURE"); v != "" {
+		return v == "true"
+	}
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+func setSessionCookie(c *gin.Context, token string, maxAge int) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(sessionCookieName, token, maxAge, "/", "", cookieSecure(c), true)
+}
+
+// loginHandler 校验用户名密码并下发会话 cookie
+func loginHandler(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Username == "" || req.Password == "" {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Username and password are required"})
+		return
+	}
+
+	u, err := authenticateUser(req.Username, req.Password)
+	if err != nil {
+		log.Warnf("Login failed for user %s from %s: %v", req.Username, c.ClientIP(), err)
+		recordAuditAs(c, req.Username, "user", AuditLogin, req.Username, false, err.Error())
+		c.JSON(http.StatusUnauthorized, APIResponse{Success: false, Message: "Invalid username or password"})
+		return
+	}
+
+	token, expiresAt, err := createSession(u.ID)
+	if err != nil {
+		log.Errorf("Failed to create session: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to create session"})
+		return
+	}
+	setSessionCookie(c, token, int(time.Until(expiresAt).Seconds()))
+	log.Infof("User %s logged in from %s", u.Username, c.ClientIP())
+	recordAuditAs(c, u.Username, "user", AuditLogin, u.Username, true, "")
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "Login successful", Data: u})
+}
+
+// logoutHandler 删除当前会话
+func logoutHandler(c *gin.Context) {
+	if token, err := c.Cookie(sessionCookieName); err == nil && token != "" {
+		if p, err := principalFromSession(token); err == nil {
+			recordAuditAs(c, p.Username, "user", AuditLogout, p.Username, true, "")
+		}
+		if _, err := db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(token)); err != nil {
+			log.Errorf("Failed to delete session: %v", err)
+		}
+	}
+	setSessionCookie(c, "", -1)
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "Logged out"})
+}
+
+// meHandler 返回当前登录主体
+func meHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: currentPrincipal(c)})
+}
+
+func listUsersHandler(c *gin.Context) {
+	users, err := listUsers()
+	if err != nil {
+		log.Errorf("Error listing users: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to list users"})
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: users, Total: len(users)})
+}
+
+func createUserHandler(c *gin.Context) {
+	var req UserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid request: " + err.Error()})
+		return
+	}
+	if req.Username == "" || len(req.Password) < 8 || !isValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "username, password (min 8 chars) and a valid role are required"})
+		return
+	}
+
+	id, err := createUser(req)
+	if err != nil {
+		log.Errorf("Error creating user %s: %v", req.Username, err)
+		recordAudit(c, AuditUserCreate, req.Username, false, err.Error())
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to create user"})
+		return
+	}
+	u, _ := getUserByID(id)
+	recordAudit(c, AuditUserCreate, req.Username, true, fmt.Sprintf("role=%s devices=%s", req.Role, strings.Join(req.Devices, ",")))
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "User created", Data: u})
+}
+
+func updateUserHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid user id"})
+		return
+	}
+	var req UserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid request: " + err.Error()})
+		return
+	}
+	if !isValidRole(req.Role) || (req.Password != "" && len(req.Password) < 8) {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "A valid role is required and password must be at least 8 chars"})
+		return
+	}
+	if _, err := getUserByID(id); err != nil {
+		c.JSON(http.StatusNotFound, APIResponse{Success: false, Message: "User not found"})
+		return
+	}
+
+	if err := updateUser(id, req); err != nil {
+		if errors.Is(err, errLastAdmin) {
+			c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "At least one admin must remain"})
+			return
+		}
+		log.Errorf("Error updating user %d: %v", id, err)
+		recordAudit(c, AuditUserUpdate, strconv.Itoa(id), false, err.Error())
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to update user"})
+		return
+	}
+	u, _ := getUserByID(id)
+	recordAudit(c, AuditUserUpdate, strconv.Itoa(id), true, fmt.Sprintf("role=%s devices=%s password_changed=%t", req.Role, strings.Join(req.Devices, ","), req.Password != ""))
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "User updated", Data: u})
+}
+
+func deleteUserHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid user id"})
+		return
+	}
+	if id == currentPrincipal(c).UserID {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "You cannot delete yourself"})
+		return
+	}
+	if err := deleteUser(id); err != nil {
+		if errors.Is(err, errLastAdmin) {
+			c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "At least one admin must remain"})
+			return
+		}
+		log.Errorf("Error deleting user %d: %v", id, err)
+		recordAudit(c, AuditUserDelete, strconv.Itoa(id), false, err.Error())
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to delete user"})
+		return
+	}
+	recordAudit(c, AuditUserDelete, strconv.Itoa(id), true, "")
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "User deleted"})
+}
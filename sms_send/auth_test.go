@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLastAdminCannotBeRemoved(t *testing.T) {
+	newTestStore(t)
+	admin, err := createUser(UserRequest{Username: "admin", Password: "password1", Role: RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	operator, err := createUser(UserRequest{Username: "operator", Password: "password1", Role: RoleOperator})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := updateUser(admin, UserRequest{Role: RoleOperator}); !errors.Is(err, errLastAdmin) {
+		t.Errorf("demoting the only admin: err = %v, want errLastAdmin", err)
+	}
+	if err := deleteUser(admin); !errors.Is(err, errLastAdmin) {
+		t.Errorf("deleting the only admin: err = %v, want errLastAdmin", err)
+	}
+	if err := updateUser(operator, UserRequest{Role: RoleReadOnly}); err != nil {
+		t.Errorf("updating a non-admin: %v", err)
+	}
+
+	// 有另一个管理员后可以降级或删除其中一个
+	if err := updateUser(operator, UserRequest{Role: RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateUser(admin, UserRequest{Role: RoleOperator}); err != nil {
+		t.Errorf("demoting one of two admins: %v", err)
+	}
+	if err := deleteUser(operator); !errors.Is(err, errLastAdmin) {
+		t.Errorf("deleting the remaining admin: err = %v, want errLastAdmin", err)
+	}
+	if u, err := getUserByID(operator); err != nil || u.Role != RoleAdmin {
+		t.Errorf("remaining admin = %+v, %v", u, err)
+	}
+}
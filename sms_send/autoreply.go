@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAutoReplyCooldown     = 24
+	defaultAutoReplyMinSenderLen = 7
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// timeWindow 是一天中的时间段，单位为分钟；end 小于 start 时跨过午夜，如 18:00-09:00
+type timeWindow struct {
+	start, end int
+}
+
+func (w timeWindow) contains(minute int) bool {
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// AutoReplyRule 是 autoreply.rules 中的一条自动回复规则
+type AutoReplyRule struct {
+	Name     string
+	RuleType string // all / keyword / regex，匹配短信内容
+	Rule     string
+	Devices  []string // 只对这些设备收到的短信回复，为空表示所有设备
+	Senders  []string // 只回复这些号码，以 * 结尾表示前缀匹配
+	Hours    []timeWindow
+	Days     map[time.Weekday]bool
+	Reply    string
+	Cooldown time.Duration
+}
+
+// AutoReplyConfig 是 forward.yaml 中的 autoreply 段
+//
+//	autoreply:
+//	  cooldown_hours: 24       # 同一设备对同一号码在该时间内发送过短信时不再回复
+//	  min_sender_length: 7     # 短于该长度或含字母的发送号码（运营商、银行等短号码）不回复
+//	  rules:
+//	    下班自动回复:
+//	      type: all
+//	      rule: all
+//	      devices: quectel0
+//	      hours: "18:00-09:00"
+//	      days: "mon-fri"
+//	      reply: "您好，现在是非工作时间，短信已收到，我们会在工作时间回复。"
+type AutoReplyConfig struct {
+	CooldownHours   int
+	MinSenderLength int
+	Rules           []*AutoReplyRule
+}
+
+var autoReplyConfig = &AutoReplyConfig{CooldownHours: defaultAutoReplyCooldown, MinSenderLength: defaultAutoReplyMinSenderLen}
+
+// autoReplyLocks 让同一设备对同一号码的自动回复串行执行。每条短信在各自的 goroutine 中回复，
+// 长短信的多个分段或对方的自动回复几乎同时到达时，后一条要等前一条的回复写入 sms_log 后再检查冷却时间。
+var autoReplyLocks = &keyedMutex{locks: map[string]*keyedLock{}}
+
+type keyedLock struct {
+	sync.Mutex
+	refs int
+}
+
+// keyedMutex 是按 key 区分的互斥锁，没有 goroutine 持有或等待的 key 会被删除
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+// Lock 锁定 key，返回解锁函数
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	l := k.locks[key]
+	if l == nil {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// loadAutoReplyConfig 解析 forward.yaml 中的 autoreply 段，按规则名排序，收到短信时使用第一条命中的规则
+func loadAutoReplyConfig(v interface{}) *AutoReplyConfig {
+	cfg := &AutoReplyConfig{CooldownHours: defaultAutoReplyCooldown, MinSenderLength: defaultAutoReplyMinSenderLen}
+	section, ok := v.(map[string]interface{})
+	if !ok {
+		return cfg
+	}
+	if _, ok := section["cooldown_hours"]; ok {
+		cfg.CooldownHours = configInt(section["cooldown_hours"])
+	}
+	if _, ok := section["min_sender_length"]; ok {
+		cfg.MinSenderLength = configInt(section["min_sender_length"])
+	}
+	rules, _ := section["rules"].(map[string]interface{})
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		settings, ok := rules[name].(map[string]interface{})
+		if !ok {
+			log.Warnf("自动回复配置格式错误: %s", name)
+			continue
+		}
+		rule, err := parseAutoReplyRule(name, settings, cfg.CooldownHours)
+		if err != nil {
+			log.Warnf("自动回复规则配置错误: %s: %v", name, err)
+			continue
+		}
+		cfg.Rules = append(cfg.Rules, rule)
+	}
+	return cfg
+}
+
+func parseAutoReplyRule(name string, settings map[string]interface{}, cooldownHours int) (*AutoReplyRule, error) {
+	r := &AutoReplyRule{Name: name, Cooldown: time.Duration(cooldownHours) * time.Hour}
+	var ok bool
+	if r.RuleType, ok = settings["type"].(string); !ok {
+		return nil, fmt.Errorf("missing type")
+	}
+	if r.Rule, ok = settings["rule"].(string); !ok {
+		return nil, fmt.Errorf("missing rule")
+	}
+	switch r.RuleType {
+	case "all", "keyword":
+	case "regex":
+		if _, err := regexp.Compile(r.Rule); err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", r.Rule, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown type %q", r.RuleType)
+	}
+	if r.Reply, _ = settings["reply"].(string); strings.TrimSpace(r.Reply) == "" {
+		return nil, fmt.Errorf("missing reply")
+	}
+	r.Devices = configStringList(settings["devices"])
+	r.Senders = configStringList(settings["senders"])
+	for _, spec := range configStringList(settings["hours"]) {
+		w, err := parseTimeWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		r.Hours = append(r.Hours, w)
+	}
+	if days := configStringList(settings["days"]); len(days) > 0 {
+		r.Days = map[time.Weekday]bool{}
+		for _, spec := range days {
+			if err := addWeekdays(r.Days, spec); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if _, ok := settings["cooldown_hours"]; ok {
+		r.Cooldown = time.Duration(configInt(settings["cooldown_hours"])) * time.Hour
+	}
+	return r, nil
+}
+
+// parseTimeWindow 解析 "18:00-09:00" 形式的时间段
+func parseTimeWindow(spec string) (timeWindow, error) {
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return timeWindow{}, fmt.Errorf("invalid hours %q, expected HH:MM-HH:MM", spec)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return timeWindow{}, fmt.Errorf("invalid hours %q: %w", spec, err)
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return timeWindow{}, fmt.Errorf("invalid hours %q: %w", spec, err)
+	}
+	return timeWindow{start: start, end: end}, nil
+}
+
+func parseClock(s string) (int, error) {
+	h, m, _ := strings.Cut(strings.TrimSpace(s), ":")
+	hour, err := strconv.Atoi(h)
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	minute := 0
+	if m != "" {
+		if minute, err = strconv.Atoi(m); err != nil || minute < 0 || minute > 59 {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+	}
+	return hour*60 + minute, nil
+}
+
+// addWeekdays 解析 "sat"、"mon-fri" 形式的星期
+func addWeekdays(days map[time.Weekday]bool, spec string) error {
+	from, to, isRange := strings.Cut(strings.ToLower(spec), "-")
+	first, ok := weekdayNames[strings.TrimSpace(from)]
+	if !ok {
+		return fmt.Errorf("invalid day %q", spec)
+	}
+	last := first
+	if isRange {
+		if last, ok = weekdayNames[strings.TrimSpace(to)]; !ok {
+			return fmt.Errorf("invalid day %q", spec)
+		}
+	}
+	for d := first; ; d = (d + 1) % 7 {
+		days[d] = true
+		if d == last {
+			return nil
+		}
+	}
+}
+
+// Matches 判断短信是否命中规则，now 为 Asia/Shanghai 时间
+func (r *AutoReplyRule) Matches(device, sender, text string, now time.Time) bool {
+	if len(r.Devices) > 0 && !containsString(r.Devices, device) {
+		return false
+	}
+	if len(r.Senders) > 0 && !matchNumber(r.Senders, sender) {
+		return false
+	}
+	if r.Days != nil && !r.Days[now.Weekday()] {
+		return false
+	}
+	if len(r.Hours) > 0 {
+		minute, inWindow := now.Hour()*60+now.Minute(), false
+		for _, w := range r.Hours {
+			if w.contains(minute) {
+				inWindow = true
+				break
+			}
+		}
+		if !inWindow {
+			return false
+		}
+	}
+	return shouldSendNotification(r.RuleType, r.Rule, text)
+}
+
+// Render 用短信内容填充回复模板，支持 {sender}、{text}、{device}、{number}（本机号码）和 {time}
+func (r *AutoReplyRule) Render(device, sender, text string, now time.Time) string {
+	return strings.NewReplacer(
+		"{sender}", sender,
+		"{text}", text,
+		"{device}", device,
+		"{number}", localNumber(device),
+		"{time}", now.Format("2006-01-02 15:04"),
+	).Replace(r.Reply)
+}
+
+// repliableSender 排除运营商、银行等短号码和字母发件人，以及本服务管理的其他 SIM 卡，避免两端互相自动回复
+func repliableSender(cfg *AutoReplyConfig, sender string) bool {
+	digits := strings.TrimPrefix(sender, "+")
+	if digits == "" || len(digits) < cfg.MinSenderLength || strings.Trim(digits, "0123456789") != "" {
+		return false
+	}
+	return !ownSIMNumber(sender)
+}
+
+// autoReply 对收到的短信按第一条命中的规则回复，回复经发送流程发出并记为发出的短信
+func autoReply(cfg *AutoReplyConfig, phoneID, sender, text string) {
+	if len(cfg.Rules) == 0 || !repliableSender(cfg, sender) {
+		return
+	}
+	device := deviceName(phoneID)
+	defer autoReplyLocks.Lock(device + "\x00" + sender)()
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		loc = time.Local
+	}
+	now := time.Now().In(loc)
+	for _, r := range cfg.Rules {
+		if !r.Matches(device, sender, text, now) {
+			continue
+		}
+		if r.Cooldown > 0 {
+			last, ok, err := store.SMS.LastSent(devices.Aliases(device), sender)
+			if err != nil {
+				log.Errorf("Auto-reply cooldown check failed: %v", err)
+				return
+			}
+			if ok && time.Since(last) < r.Cooldown {
+				log.Infof("自动回复规则 %s 命中，但 %s 前已向 %s 发送过短信，跳过", r.Name, time.Since(last).Round(time.Minute), sender)
+				return
+			}
+		}
+		reply := r.Render(device, sender, text, now)
+		if err := deliverSMS(device, sender, reply); err != nil {
+			log.Errorf("Auto-reply to %s via %s failed: %v", sender, device, err)
+			return
+		}
+		log.Infof("自动回复规则 %s: 已回复 %s", r.Name, sender)
+		return
+	}
+}
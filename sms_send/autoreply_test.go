@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAutoReplyConcurrentMessagesReplyOnce(t *testing.T) {
+	newTestStore(t)
+	calls := fakeAsterisk(t, 0)
+	cfg := &AutoReplyConfig{MinSenderLength: defaultAutoReplyMinSenderLen, Rules: []*AutoReplyRule{
+		{Name: "r", RuleType: "all", Rule: "all", Reply: "已收到", Cooldown: time.Hour},
+	}}
+
+	// 长短信的多个分段几乎同时到达，冷却时间内只应回复一次
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			autoReply(cfg, "quectel0", "13800000009", "分段")
+		}()
+	}
+	wg.Wait()
+
+	out, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("asterisk was not called: %v", err)
+	}
+	if n := strings.Count(string(out), "\n"); n != 1 {
+		t.Errorf("sent %d auto-replies, want 1:\n%s", n, out)
+	}
+	if len(autoReplyLocks.locks) != 0 {
+		t.Errorf("%d auto-reply locks left after replies finished", len(autoReplyLocks.locks))
+	}
+}
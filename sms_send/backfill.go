@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// 补录的数据来源
+const (
+	BackfillSMSLog = "sms_log" // 拨号计划追加写入的 /data/log/sms.txt
+	BackfillSMSDB  = "smsdb"   // chan_quectel 的 smsdb 中未拼接完成的长短信分段
+)
+
+const (
+	defaultBackfillSMSLog = "/data/log/sms.txt"
+	defaultBackfillSMSDB  = "/data/db/smsdb"
+	defaultBackfillDays   = 7
+	defaultBackfillWindow = 5 // 分钟
+	// 启动后等待设备监控读到 IMSI 和本机号码再补录
+	backfillStartupDelay = time.Minute
+)
+
+// BackfillConfig 来自 forward.yaml 的 backfill 段，例如：
+//
+//	backfill:
+//	  on_startup: true               # 启动时补录一次
+//	  interval_hours: 0              # 大于 0 时定期补录
+//	  days: 7                        # 只核对最近 N 天的短信
+//	  match_window_minutes: 5        # 同一号码、相同正文且时间相差不超过该值视为已记录
+//	  sms_log: /data/log/sms.txt
+//	  smsdb: /data/db/smsdb
+type BackfillConfig struct {
+	OnStartup     bool   `json:"on_startup"`
+	IntervalHours int    `json:"interval_hours"`
+	Days          int    `json:"days"`
+	WindowMinutes int    `json:"match_window_minutes"`
+	SMSLog        string `json:"sms_log"`
+	SMSDB         string `json:"smsdb"`
+}
+
+// BackfillResult 是一个来源一次补录的结果
+type BackfillResult struct {
+	Source   string `json:"source"`
+	Path     string `json:"path"`
+	Parsed   int    `json:"parsed"`   // 时间范围内解析到的短信
+	Inserted int    `json:"inserted"` // 补录的短信，dry_run 时为缺失的数量
+	Error    string `json:"error,omitempty"`
+}
+
+// BackfillReport 是一次补录的报告
+type BackfillReport struct {
+	DryRun     bool             `json:"dry_run"`
+	Since      time.Time        `json:"since"`
+	StartedAt  time.Time        `json:"started_at"`
+	FinishedAt time.Time        `json:"finished_at"`
+	Results    []BackfillResult `json:"results"`
+}
+
+var (
+	backfillConfig = defaultBackfillConfig()
+	// backfillMu 保证同一时间只有一次补录在执行
+	backfillMu         sync.Mutex
+	lastBackfillReport *BackfillReport
+	lastBackfillMu     sync.RWMutex
+)
+
+func defaultBackfillConfig() *BackfillConfig {
+	return &BackfillConfig{Days: defaultBackfillDays, WindowMinutes: defaultBackfillWindow, SMSLog: defaultBackfillSMSLog, SMSDB: defaultBackfillSMSDB}
+}
+
+// loadBackfillConfig 解析 forward.yaml 中的 backfill 段；sms_log 或 smsdb 设为空字符串时不读取该来源
+func loadBackfillConfig(v interface{}) *BackfillConfig {
+	cfg := defaultBackfillConfig()
+	section, ok := v.(map[string]interface{})
+	if !ok {
+		return cfg
+	}
+	cfg.OnStartup = configBool(section["on_startup"])
+	cfg.IntervalHours = configInt(section["interval_hours"])
+	if days := configInt(section["days"]); days > 0 {
+		cfg.Days = days
+	}
+	if minutes := configInt(section["match_window_minutes"]); minutes > 0 {
+		cfg.WindowMinutes = minutes
+	}
+	if path, ok := section["sms_log"].(string); ok {
+		cfg.SMSLog = strings.TrimSpace(path)
+	}
+	if path, ok := section["smsdb"].(string); ok {
+		cfg.SMSDB = strings.TrimSpace(path)
+	}
+	return cfg
+}
+
+// smsLogLinePattern 匹配 sms.txt 中一条短信的首行：
+// "2024-01-02 15:04:05 - quectel0 - +8613800000000: 正文"，正文中的换行会产生不以时间开头的续行
+var smsLogLinePattern = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) - (\S*) - ([^:]*): ?(.*)$`)
+
+// parseSMSLog 读取 sms.txt 中 since 之后收到的短信。拨号计划以 Asia/Shanghai 时间写入。
+func parseSMSLog(path string, since time.Time) ([]SMSMessage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		loc = time.FixedZone("CST", 8*3600)
+	}
+
+	var messages []SMSMessage
+	var current *SMSMessage
+	flush := func() {
+		if current != nil && !current.CreatedAt.Before(since) {
+			current.Body = strings.TrimSpace(current.Body)
+			messages = append(messages, *current)
+		}
+		current = nil
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		m := smsLogLinePattern.FindStringSubmatch(line)
+		if m == nil {
+			if current != nil {
+				current.Body += "\n" + line
+			}
+			continue
+		}
+		flush()
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", m[1], loc)
+		if err != nil {
+			continue
+		}
+		current = &SMSMessage{Direction: "incoming", Status: "received", PhoneID: m[2], FromNumber: strings.TrimSpace(m[3]), Body: m[4], CreatedAt: t}
+	}
+	flush()
+	return messages, scanner.Err()
+}
+
+// smsdbFragment 是 smsdb incoming 表中的一段长短信
+type smsdbFragment struct {
+	seq     int
+	ts      time.Time
+	message string
+}
+
+// parseSMSDB 读取 chan_quectel smsdb 中 since 之后收到、但没有收齐全部分段的长短信。
+// 收齐的长短信会被 chan_quectel 删除并交给拨号计划，只有缺少分段的短信才会留在 smsdb 中，
+// 这类短信按已有分段拼接，缺少的分段以 "…" 代替，状态为 partial。
+// incoming 表的 token 为 "<IMSI>/<发送方>/<引用号>/<分段数>"。
+func parseSMSDB(path string, since time.Time) ([]SMSMessage, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var n int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'incoming'").Scan(&n); err != nil {
+		return nil, fmt.Errorf("failed to read smsdb: %w", err)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	rows, err := conn.Query("SELECT token, seqorttl, CAST(ts AS TEXT), message FROM incoming ORDER BY token, seqorttl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read smsdb: %w", err)
+	}
+	defer rows.Close()
+
+	groups := map[string][]smsdbFragment{}
+	var tokens []string
+	for rows.Next() {
+		var token, ts, message string
+		var seq int
+		if err := rows.Scan(&token, &seq, &ts, &message); err != nil {
+			return nil, fmt.Errorf("failed to read smsdb: %w", err)
+		}
+		t, err := time.Parse("2006-01-02 15:04:05", ts)
+		if err != nil {
+			log.Warnf("Skipping smsdb fragment %s/%d with invalid time %q", token, seq, ts)
+			continue
+		}
+		if _, ok := groups[token]; !ok {
+			tokens = append(tokens, token)
+		}
+		groups[token] = append(groups[token], smsdbFragment{seq: seq, ts: t, message: message})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var messages []SMSMessage
+	for _, token := range tokens {
+		fields := strings.Split(token, "/")
+		parts, err := strconv.Atoi(fields[len(fields)-1])
+		if len(fields) < 4 || err != nil || parts <= 0 {
+			log.Warnf("Skipping smsdb token %q in unknown format", token)
+			continue
+		}
+		sender := fields[len(fields)-3]
+		msg := SMSMessage{Direction: "incoming", Status: "received", FromNumber: sender}
+		imsi := strings.Join(fields[:len(fields)-3], "/")
+		if device, ok := smsdbDevice(imsi); ok {
+			msg.PhoneID = device
+		} else {
+			// 无法确定设备时保留 IMSI，本机号码记为 unknown
+			msg.PhoneID, msg.ToNumber = imsi, "unknown"
+		}
+
+		fragments := groups[token]
+		sort.Slice(fragments, func(i, j int) bool { return fragments[i].seq < fragments[j].seq })
+		received := map[int]string{}
+		first := fragments[0].ts
+		for _, f := range fragments {
+			received[f.seq] = f.message
+			if f.ts.Before(first) {
+				first = f.ts
+			}
+		}
+		if first.Before(since) {
+			continue
+		}
+		var body strings.Builder
+		for seq := 1; seq <= parts; seq++ {
+			if text, ok := received[seq]; ok {
+				body.WriteString(text)
+			} else {
+				body.WriteString("…")
+			}
+		}
+		if len(received) < parts {
+			msg.Status = "partial"
+		}
+		msg.Body, msg.CreatedAt = body.String(), first
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// smsdbDevice 把 smsdb token 中的 IMSI 解析为设备名；AMI 尚未读到 IMSI 时，只有一个设备则归属该设备
+func smsdbDevice(imsi string) (string, bool) {
+	for _, info := range devices.Infos() {
+		if info.IMSI != "" && info.IMSI == imsi {
+			return info.Device, true
+		}
+	}
+	if all := devices.Summaries(); len(all) == 1 {
+		return all[0].Device, true
+	}
+	return "", false
+}
+
+// backfillSeen 按（号码, 正文）记录本次已处理短信的时间，避免两个来源中的同一条短信重复补录
+type backfillSeen map[[2]string][]time.Time
+
+// add 记录短信，已有同一号码、相同正文且时间相差不超过 window 的短信时返回 false
+func (s backfillSeen) add(msg SMSMessage, window time.Duration) bool {
+	key := [2]string{msg.FromNumber, msg.Body}
+	for _, t := range s[key] {
+		if d := t.Sub(msg.CreatedAt); d <= window && d >= -window {
+			return false
+		}
+	}
+	s[key] = append(s[key], msg.CreatedAt)
+	return true
+}
+
+// backfillSource 补录一个来源
+func backfillSource(source, path string, cfg *BackfillConfig, since time.Time, dryRun bool, seen backfillSeen) BackfillResult {
+	res := BackfillResult{Source: source, Path: path}
+	var messages []SMSMessage
+	var err error
+	if source == BackfillSMSDB {
+		messages, err = parseSMSDB(path, since)
+	} else {
+		messages, err = parseSMSLog(path, since)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Infof("Backfill source %s not found at %s, skipping", source, path)
+			return res
+		}
+		res.Error = err.Error()
+		return res
+	}
+	res.Parsed = len(messages)
+
+	window := time.Duration(cfg.WindowMinutes) * time.Minute
+	var pending []SMSMessage
+	for _, msg := range messages {
+		if !seen.add(msg, window) {
+			continue
+		}
+		if msg.ToNumber == "" {
+			msg.ToNumber = localNumber(msg.PhoneID)
+		}
+		pending = append(pending, msg)
+	}
+	for start := 0; start < len(pending); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		n, err := store.SMS.Backfill(pending[start:end], window, dryRun)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		res.Inserted += n
+	}
+	return res
+}
+
+// runBackfill 依次核对 sms.txt 和 smsdb，补录 sms_log 中缺少的短信。补录直接写入数据库，不会触发转发通知。
+func runBackfill(cfg *BackfillConfig, dryRun bool) (*BackfillReport, error) {
+	if !backfillMu.TryLock() {
+		return nil, fmt.Errorf("a backfill run is already in progress")
+	}
+	defer backfillMu.Unlock()
+
+	report := &BackfillReport{DryRun: dryRun, StartedAt: time.Now(), Results: []BackfillResult{}}
+	report.Since = report.StartedAt.AddDate(0, 0, -cfg.Days)
+	seen := backfillSeen{}
+	for _, src := range []struct{ source, path string }{{BackfillSMSLog, cfg.SMSLog}, {BackfillSMSDB, cfg.SMSDB}} {
+		if src.path == "" {
+			continue
+		}
+		res := backfillSource(src.source, src.path, cfg, report.Since, dryRun, seen)
+		if res.Error != "" {
+			log.Errorf("Backfill from %s failed: %s", src.path, res.Error)
+		} else if dryRun {
+			log.Infof("Backfill from %s (dry run): %d of %d messages missing", src.path, res.Inserted, res.Parsed)
+		} else if res.Inserted > 0 {
+			log.Infof("Backfill from %s: inserted %d of %d messages", src.path, res.Inserted, res.Parsed)
+		}
+		report.Results = append(report.Results, res)
+	}
+	report.FinishedAt = time.Now()
+
+	lastBackfillMu.Lock()
+	lastBackfillReport = report
+	lastBackfillMu.Unlock()
+	return report, nil
+}
+
+// backfillSummary 汇总报告中补录的短信数，用于审计日志
+func backfillSummary(report *BackfillReport) string {
+	var parts []string
+	for _, r := range report.Results {
+		part := fmt.Sprintf("%s=%d", r.Source, r.Inserted)
+		if r.Error != "" {
+			part += "(error)"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// recordBackfillAudit 以系统身份记录补录结果，没有补录任何短信时不记录
+func recordBackfillAudit(report *BackfillReport) {
+	total := 0
+	for _, r := range report.Results {
+		total += r.Inserted
+	}
+	if report.DryRun || total == 0 {
+		return
+	}
+	e := AuditEntry{Actor: "system", ActorType: "system", Action: AuditSMSBackfill, Target: "backfill", Result: "success", Detail: backfillSummary(report)}
+	if err := insertAuditLog(e); err != nil {
+		log.Errorf("Failed to record audit log %s: %v", AuditSMSBackfill, err)
+	}
+}
+
+// startBackfill 按配置在启动时和定期在后台补录
+func startBackfill() {
+	cfg := backfillConfig
+	if !cfg.OnStartup && cfg.IntervalHours <= 0 {
+		return
+	}
+	log.Infof("Backfill enabled: on_startup=%v, every %d hours, last %d days", cfg.OnStartup, cfg.IntervalHours, cfg.Days)
+	runBackground(func() {
+		delay := backfillStartupDelay
+		if !cfg.OnStartup {
+			delay = time.Duration(cfg.IntervalHours) * time.Hour
+		}
+		if !sleepContext(delay) {
+			return
+		}
+		for {
+			report, err := runBackfill(cfg, false)
+			if err != nil {
+				log.Warnf("Backfill skipped: %v", err)
+			} else {
+				recordBackfillAudit(report)
+			}
+			if cfg.IntervalHours <= 0 || !sleepContext(time.Duration(cfg.IntervalHours)*time.Hour) {
+				return
+			}
+		}
+	})
+}
+
+// getBackfillHandler 返回补录配置和最近一次执行的报告
+func getBackfillHandler(c *gin.Context) {
+	lastBackfillMu.RLock()
+	report := lastBackfillReport
+	lastBackfillMu.RUnlock()
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: gin.H{"config": backfillConfig, "last_run": report}})
+}
+
+// runBackfillHandler 立即补录一次，dry_run=true 时只返回缺失的数量
+func runBackfillHandler(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+	report, err := runBackfill(backfillConfig, dryRun)
+	if err != nil {
+		c.JSON(http.StatusConflict, APIResponse{Success: false, Message: err.Error()})
+		return
+	}
+	if !dryRun {
+		recordAudit(c, AuditSMSBackfill, "backfill", true, backfillSummary(report))
+	}
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: report})
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// 设备告警中余额相关的检查项，由余额查询触发，不参与设备状态轮询
+const (
+	AlertLowBalance  = "low_balance"
+	AlertSIMExpiring = "sim_expiring"
+)
+
+const (
+	BalanceMethodUSSD = "ussd"
+	BalanceMethodSMS  = "sms"
+
+	defaultBalanceInterval     = 24
+	defaultBalanceReplyTimeout = 10
+	balanceStartupDelay        = 2 * time.Minute
+	balanceHistoryLimit        = 100
+)
+
+var (
+	defaultBalanceRegex = regexp.MustCompile(`(?i)(?:余额|balance)[^0-9-]*(-?[0-9][0-9,]*(?:\.[0-9]+)?)`)
+	defaultExpiryRegex  = regexp.MustCompile(`(?i)(?:有效期|expir[a-z]*)[^0-9]*([0-9]{4}[-年/.][0-9]{1,2}[-月/.][0-9]{1,2})`)
+	digitsPattern       = regexp.MustCompile(`[0-9]+`)
+)
+
+// SIMBalanceConfig 是 balance.sims 中单张 SIM 卡的查询方式和告警阈值
+type SIMBalanceConfig struct {
+	Device         string
+	USSD           string // USSD 代码，如 *100#
+	SMSTo          string // 发送查询短信的运营商号码，设置 ussd 时忽略
+	SMSText        string
+	ReplyFrom      string // 回复短信的发送号码，默认与 sms_to 相同
+	BalanceRegex   *regexp.Regexp
+	ExpiryRegex    *regexp.Regexp
+	MinBalance     float64
+	ExpiryWarnDays int
+}
+
+// Method 返回查询方式
+func (s *SIMBalanceConfig) Method() string {
+	if s.USSD != "" {
+		return BalanceMethodUSSD
+	}
+	return BalanceMethodSMS
+}
+
+// BalanceConfig 是 forward.yaml 中的 balance 段
+//
+//	balance:
+//	  interval_hours: 24
+//	  reply_timeout_minutes: 10
+//	  sims:
+//	    quectel0:
+//	      ussd: "*100#"                # 或使用短信查询：sms_to: "10086"、sms_text: "YE"
+//	      balance_regex: '余额[^0-9]*([0-9.]+)'
+//	      expiry_regex: '有效期至([0-9]{4}年[0-9]{1,2}月[0-9]{1,2}日)'
+//	      min_balance: 10
+//	      expiry_warn_days: 7
+type BalanceConfig struct {
+	IntervalHours       int
+	ReplyTimeoutMinutes int
+	SIMs                map[string]*SIMBalanceConfig
+}
+
+var balanceConfig = &BalanceConfig{IntervalHours: defaultBalanceInterval, ReplyTimeoutMinutes: defaultBalanceReplyTimeout, SIMs: map[string]*SIMBalanceConfig{}}
+
+// loadBalanceConfig 解析 forward.yaml 中的 balance 段，配置错误的 SIM 卡会被跳过
+func loadBalanceConfig(v interface{}) *BalanceConfig {
+	cfg := &BalanceConfig{IntervalHours: defaultBalanceInterval, ReplyTimeoutMinutes: defaultBalanceReplyTimeout, SIMs: map[string]*SIMBalanceConfig{}}
+	section, ok := v.(map[string]interface{})
+	if !ok {
+		return cfg
+	}
+	if _, ok := section["interval_hours"]; ok {
+		cfg.IntervalHours = configInt(section["interval_hours"])
+	}
+	if n := configInt(section["reply_timeout_minutes"]); n > 0 {
+		cfg.ReplyTimeoutMinutes = n
+	}
+	sims, _ := section["sims"].(map[string]interface{})
+	for device, raw := range sims {
+		settings, ok := raw.(map[string]interface{})
+		if !ok {
+			log.Warnf("余额查询配置格式错误: %s", device)
+			continue
+		}
+		sim, err := parseSIMBalanceConfig(device, settings)
+		if err != nil {
+			log.Warnf("余额查询配置错误: %s: %v", device, err)
+			continue
+		}
+		cfg.SIMs[device] = sim
+	}
+	return cfg
+}
+
+func parseSIMBalanceConfig(device string, settings map[string]interface{}) (*SIMBalanceConfig, error) {
+	str := func(key string) string {
+		return strings.TrimSpace(fmt.Sprint(settings[key]))
+	}
+	sim := &SIMBalanceConfig{Device: device, BalanceRegex: defaultBalanceRegex, ExpiryRegex: defaultExpiryRegex}
+	if settings["ussd"] != nil {
+		sim.USSD = str("ussd")
+	}
+	if settings["sms_to"] != nil {
+		sim.SMSTo, sim.SMSText = str("sms_to"), str("sms_text")
+		sim.ReplyFrom = sim.SMSTo
+	}
+	if settings["reply_from"] != nil {
+		sim.ReplyFrom = str("reply_from")
+	}
+	if sim.USSD == "" && (sim.SMSTo == "" || sim.SMSText == "") {
+		return nil, fmt.Errorf("either ussd or sms_to and sms_text is required")
+	}
+	for key, re := range map[string]**regexp.Regexp{"balance_regex": &sim.BalanceRegex, "expiry_regex": &sim.ExpiryRegex} {
+		if settings[key] == nil {
+			continue
+		}
+		compiled, err := regexp.Compile(str(key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		if compiled.NumSubexp() == 0 {
+			return nil, fmt.Errorf("%s must have a capture group", key)
+		}
+		*re = compiled
+	}
+	if settings["min_balance"] != nil {
+		min, err := strconv.ParseFloat(str("min_balance"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid min_balance: %w", err)
+		}
+		sim.MinBalance = min
+	}
+	sim.ExpiryWarnDays = configInt(settings["expiry_warn_days"])
+	return sim, nil
+}
+
+// parseBalanceReply 从运营商回复中解析余额和有效期，两者都没有匹配时 ok 为 false
+func parseBalanceReply(sim *SIMBalanceConfig, text string) (balance *float64, expiresOn string, ok bool) {
+	if m := sim.BalanceRegex.FindStringSubmatch(text); m != nil {
+		if v, err := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", ""), 64); err == nil {
+			balance = &v
+		}
+	}
+	if m := sim.ExpiryRegex.FindStringSubmatch(text); m != nil {
+		expiresOn = parseExpiryDate(strings.Join(m[1:], " "))
+	}
+	return balance, expiresOn, balance != nil || expiresOn != ""
+}
+
+// parseExpiryDate 依次取出年、月、日三组数字，兼容 2024-01-31、2024年1月31日、20240131 等写法
+func parseExpiryDate(s string) string {
+	parts := digitsPattern.FindAllString(s, -1)
+	if len(parts) == 1 && len(parts[0]) == 8 {
+		parts = []string{parts[0][:4], parts[0][4:6], parts[0][6:]}
+	}
+	if len(parts) < 3 {
+		return ""
+	}
+	y, _ := strconv.Atoi(parts[0])
+	m, _ := strconv.Atoi(parts[1])
+	d, _ := strconv.Atoi(parts[2])
+	if y < 100 {
+		y += 2000
+	}
+	t := time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.Local)
+	if t.Year() != y || int(t.Month()) != m || t.Day() != d {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// pendingBalanceQuery 是已发出、等待运营商回复的查询
+type pendingBalanceQuery struct {
+	sim    *SIMBalanceConfig
+	sentAt time.Time
+}
+
+// BalanceChecker 发出余额查询，并把之后收到的 USSD 或短信回复与查询对应起来
+type BalanceChecker struct {
+	mu      sync.Mutex
+	pending map[string]*pendingBalanceQuery
+}
+
+var balanceChecker = &BalanceChecker{pending: map[string]*pendingBalanceQuery{}}
+
+// Check 向运营商发出一次余额查询，回复在 reply_timeout_minutes 内到达时记录结果
+func (b *BalanceChecker) Check(cfg *BalanceConfig, device string) error {
+	sim := cfg.SIMs[device]
+	if sim == nil {
+		return fmt.Errorf("no balance check configured for device %s", device)
+	}
+	b.mu.Lock()
+	if q := b.pending[device]; q != nil {
+		log.Warnf("Balance query on %s sent at %s got no reply", device, q.sentAt.Format(time.RFC3339))
+	}
+	b.pending[device] = &pendingBalanceQuery{sim: sim, sentAt: time.Now()}
+	b.mu.Unlock()
+
+	var err error
+	if sim.Method() == BalanceMethodUSSD {
+		_, err = SendUSSDShell(device, sim.USSD)
+	} else {
+		err = deliverSMS(device, sim.SMSTo, sim.SMSText)
+	}
+	if err != nil {
+		b.mu.Lock()
+		delete(b.pending, device)
+		b.mu.Unlock()
+		return err
+	}
+	log.Infof("Balance query sent on %s via %s", device, sim.Method())
+	return nil
+}
+
+// HandleReply 检查收到的 USSD（from 为空）或短信是否是等待中的余额查询的回复
+func (b *BalanceChecker) HandleReply(device, method, from, text string) {
+	b.mu.Lock()
+	q := b.pending[device]
+	if q == nil || q.sim.Method() != method {
+		b.mu.Unlock()
+		return
+	}
+	if time.Since(q.sentAt) > time.Duration(balanceConfig.ReplyTimeoutMinutes)*time.Minute {
+		log.Warnf("Balance query on %s sent at %s got no reply", device, q.sentAt.Format(time.RFC3339))
+		delete(b.pending, device)
+		b.mu.Unlock()
+		return
+	}
+	if method == BalanceMethodSMS && strings.TrimPrefix(from, "+") != strings.TrimPrefix(q.sim.ReplyFrom, "+") {
+		b.mu.Unlock()
+		return
+	}
+	balance, expiresOn, ok := parseBalanceReply(q.sim, text)
+	if !ok {
+		// 运营商可能先回复其他内容，继续等待直到超时
+		b.mu.Unlock()
+		log.Infof("Reply on %s does not contain balance or expiry, still waiting", device)
+		return
+	}
+	delete(b.pending, device)
+	b.mu.Unlock()
+
+	rec := BalanceRecord{Device: device, Method: method, Balance: balance, ExpiresOn: expiresOn, Reply: text, CreatedAt: time.Now()}
+	if err := recordBalance(q.sim, rec); err != nil {
+		log.Errorf("Failed to record balance of %s: %v", device, err)
+	}
+}
+
+// recordBalance 保存查询结果，并在余额或有效期越过阈值时发送告警或恢复通知
+func recordBalance(sim *SIMBalanceConfig, rec BalanceRecord) error {
+	history, err := store.Balances.History(rec.Device, 1)
+	if err != nil {
+		return err
+	}
+	id, err := store.Balances.Insert(rec)
+	if err != nil {
+		return err
+	}
+	rec.ID = int(id)
+	log.WithFields(log.Fields{"device": rec.Device, "balance": rec.Balance, "expires_on": rec.ExpiresOn}).Info("SIM balance updated")
+	if rec.Balance != nil {
+		simBalance.WithLabelValues(rec.Device).Set(*rec.Balance)
+	}
+	if rec.ExpiresOn != "" {
+		if t, err := time.ParseInLocation("2006-01-02", rec.ExpiresOn, time.Local); err == nil {
+			simExpiry.WithLabelValues(rec.Device).Set(float64(t.Unix()))
+		}
+	}
+
+	var prev *BalanceRecord
+	if len(history) > 0 {
+		prev = &history[0]
+	}
+	var alerts []DeviceAlert
+	if a, ok := balanceTransition(AlertLowBalance, sim, prev, rec, lowBalance); ok {
+		alerts = append(alerts, a)
+	}
+	if a, ok := balanceTransition(AlertSIMExpiring, sim, prev, rec, expiringSoon); ok {
+		alerts = append(alerts, a)
+	}
+	dispatchDeviceAlerts(alerts)
+	return nil
+}
+
+// lowBalance 判断余额是否低于阈值；known 为 false 表示该次结果没有余额或未设置阈值
+func lowBalance(sim *SIMBalanceConfig, rec BalanceRecord) (low bool, detail string, known bool) {
+	if rec.Balance == nil || sim.MinBalance <= 0 {
+		return false, "", false
+	}
+	return *rec.Balance < sim.MinBalance, fmt.Sprintf("余额 %.2f（告警阈值 %.2f）", *rec.Balance, sim.MinBalance), true
+}
+
+// expiringSoon 判断有效期是否在 expiry_warn_days 天内到期
+func expiringSoon(sim *SIMBalanceConfig, rec BalanceRecord) (expiring bool, detail string, known bool) {
+	if rec.ExpiresOn == "" || sim.ExpiryWarnDays <= 0 {
+		return false, "", false
+	}
+	t, err := time.ParseInLocation("2006-01-02", rec.ExpiresOn, time.Local)
+	if err != nil {
+		return false, "", false
+	}
+	days := int(time.Until(t).Hours() / 24)
+	return days <= sim.ExpiryWarnDays, fmt.Sprintf("有效期至 %s，剩余 %d 天", rec.ExpiresOn, days), true
+}
+
+// balanceTransition 只在状态变化时产生告警：上次正常本次异常时告警，上次异常本次正常（已充值）时恢复
+func balanceTransition(check string, sim *SIMBalanceConfig, prev *BalanceRecord, rec BalanceRecord,
+	eval func(*SIMBalanceConfig, BalanceRecord) (bool, string, bool)) (DeviceAlert, bool) {
+	failing, detail, known := eval(sim, rec)
+	if !known {
+		return DeviceAlert{}, false
+	}
+	wasFailing := false
+	if prev != nil {
+		wasFailing, _, _ = eval(sim, *prev)
+	}
+	alert := DeviceAlert{Device: rec.Device, Check: check, Detail: detail, Since: rec.CreatedAt, CreatedAt: rec.CreatedAt}
+	switch {
+	case failing && !wasFailing:
+		return alert, true
+	case !failing && wasFailing:
+		alert.Resolved, alert.Since = true, prev.CreatedAt
+		return alert, true
+	}
+	return alert, false
+}
+
+// startBalanceChecks 按 interval_hours 定期查询所有配置的 SIM 卡，interval_hours 为 0 时只能手动查询
+func startBalanceChecks() {
+	cfg := balanceConfig
+	if len(cfg.SIMs) == 0 || cfg.IntervalHours <= 0 {
+		return
+	}
+	log.Infof("Balance checks enabled: %d SIMs, every %d hours", len(cfg.SIMs), cfg.IntervalHours)
+	runBackground(func() {
+		if !sleepContext(balanceStartupDelay) {
+			return
+		}
+		for {
+			names := make([]string, 0, len(cfg.SIMs))
+			for device := range cfg.SIMs {
+				names = append(names, device)
+			}
+			sort.Strings(names)
+			for _, device := range names {
+				if err := balanceChecker.Check(cfg, device); err != nil {
+					log.Warnf("Balance query on %s failed: %v", device, err)
+				}
+			}
+			if !sleepContext(time.Duration(cfg.IntervalHours) * time.Hour) {
+				return
+			}
+		}
+	})
+}
+
+// getBalanceHandler 返回当前用户可访问设备最近一次的余额查询结果
+func getBalanceHandler(c *gin.Context) {
+	p := currentPrincipal(c)
+	records, err := store.Balances.Latest()
+	if err != nil {
+		log.Errorf("Error querying balance: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to query balance"})
+		return
+	}
+	list := []BalanceRecord{}
+	for _, rec := range records {
+		if p.CanAccessDevice(rec.Device) {
+			list = append(list, rec)
+		}
+	}
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: list, Total: len(list)})
+}
+
+// getBalanceHistoryHandler 返回设备最近的余额查询记录
+func getBalanceHistoryHandler(c *gin.Context) {
+	device := c.Param("device")
+	if !currentPrincipal(c).CanAccessDevice(device) {
+		c.JSON(http.StatusForbidden, APIResponse{Success: false, Message: "No access to device " + device})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "30"))
+	if err != nil || limit <= 0 || limit > balanceHistoryLimit {
+		limit = balanceHistoryLimit
+	}
+	records, err := store.Balances.History(device, limit)
+	if err != nil {
+		log.Errorf("Error querying balance history: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to query balance history"})
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: records, Total: len(records)})
+}
+
+// checkBalanceHandler 立即发出一次余额查询，结果在运营商回复后写入历史
+func checkBalanceHandler(c *gin.Context) {
+	device := c.Param("device")
+	if err := balanceChecker.Check(balanceConfig, device); err != nil {
+		recordAudit(c, AuditBalanceCheck, device, false, err.Error())
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Balance query failed: " + err.Error()})
+		return
+	}
+	recordAudit(c, AuditBalanceCheck, device, true, "method="+balanceConfig.SIMs[device].Method())
+	c.JSON(http.StatusAccepted, APIResponse{Success: true, Message: "Balance query sent, waiting for the carrier's reply"})
+}
@@ -23,6 +23,9 @@ type DBConfig struct {
 	Username string
 	Password string
 	DBName   string
+	// Backend 为 mysql（默认，使用 FreePBX 的数据库）或 sqlite
+	Backend    string
+	SQLitePath string
 }
 
 // GetAMIConfigFromDB queries the FreePBX database to get AMI manager credentials.
@@ -71,6 +74,42 @@ func GetAMIConfigFromDB(db *sql.DB) (*AMIConfig, error) {
 	return amiConfig, nil
 }
 
+// getAMIConfig 返回 AMI 连接信息：设置了 AMI_USERNAME 时使用 AMI_* 环境变量，
+// 否则从 FreePBX 的 freepbx_settings 表读取，后者只在 MySQL 后端可用
+func getAMIConfig() (*AMIConfig, error) {
+	if user := os.Getenv("AMI_USERNAME"); user != "" {
+		amiConfig := &AMIConfig{
+			Host:     os.Getenv("AMI_HOST"),
+			Port:     os.Getenv("AMI_PORT"),
+			Username: user,
+			Secret:   os.Getenv("AMI_SECRET"),
+		}
+		if amiConfig.Host == "" {
+			amiConfig.Host = "127.0.0.1"
+		}
+		if amiConfig.Port == "" {
+			amiConfig.Port = "5038"
+		}
+		return amiConfig, nil
+	}
+	if store.Dialect.Name() != StorageMySQL {
+		return nil, fmt.Errorf("AMI_USERNAME/AMI_SECRET must be set when using the %s storage backend", store.Dialect.Name())
+	}
+	return GetAMIConfigFromDB(store.DB)
+}
+
+// configSection 取出 forward.yaml 中不属于转发规则的顶级配置段，并从规则集中移除。
+// 旧配置中可能恰好有同名的转发规则：包含 notify 或 type 时按规则保留并记录警告，该配置段使用默认值。
+func configSection(cfg map[string]interface{}, name string) interface{} {
+	v := cfg[name]
+	if m, ok := v.(map[string]interface{}); ok && (m["notify"] != nil || m["type"] != nil) {
+		log.Warnf("forward.yaml 中的 %s 包含 notify/type，按转发规则加载，%s 配置段未生效；请重命名该规则", name, name)
+		return nil
+	}
+	delete(cfg, name)
+	return v
+}
+
 func initConfig() (*DBConfig, error) {
 	// Read forwarding configuration
 	viperconfig = viper.New()
@@ -83,15 +122,26 @@ func initConfig() (*DBConfig, error) {
 	if err := viperconfig.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("failed to parse push configuration: %v", err)
 	}
-	log.Info("Push configuration loaded successfully")
+	// 非转发规则的配置段，解析后从规则集中移除
+	devices.SetConfigs(loadDeviceConfigs(configSection(config, "devices")))
+	retentionConfig = loadRetentionConfig(configSection(config, "retention"))
+	backfillConfig = loadBackfillConfig(configSection(config, "backfill"))
+	alertConfig = loadAlertConfig(configSection(config, "alerts"))
+	balanceConfig = loadBalanceConfig(configSection(config, "balance"))
+	keepAliveConfig = loadKeepAliveConfig(configSection(config, "keepalive"))
+	autoReplyConfig = loadAutoReplyConfig(configSection(config, "autoreply"))
+	forwardRules, forwardRuleErrors = loadForwardRules(config)
+	log.Infof("Push configuration loaded successfully, %d rules", len(forwardRules))
 
 	// Read database configuration from environment variables
 	dbConfig := &DBConfig{
-		Host:     os.Getenv("DBHOST"),
-		Port:     os.Getenv("DBPORT"),
-		Username: os.Getenv("DBUSER"),
-		Password: os.Getenv("DBPASS"),
-		DBName:   os.Getenv("DBNAME"),
+		Host:       os.Getenv("DBHOST"),
+		Port:       os.Getenv("DBPORT"),
+		Username:   os.Getenv("DBUSER"),
+		Password:   os.Getenv("DBPASS"),
+		DBName:     os.Getenv("DBNAME"),
+		Backend:    storageBackend(),
+		SQLitePath: os.Getenv("SQLITE_PATH"),
 	}
 
 	return dbConfig, nil
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestConfigSectionKeepsRuleWithReservedName(t *testing.T) {
+	cfg := map[string]interface{}{
+		"balance": map[string]interface{}{"type": "keyword", "rule": "余额", "notify": "wechat", "url": "https://example.com"},
+		"alerts":  map[string]interface{}{"enabled": true},
+	}
+	if v := configSection(cfg, "balance"); v != nil {
+		t.Errorf("configSection returned rule %v as the balance section", v)
+	}
+	if v := configSection(cfg, "alerts"); v == nil {
+		t.Error("alerts section was not returned")
+	}
+	rules, errs := loadForwardRules(cfg)
+	if len(rules) != 1 || rules[0].Name != "balance" || len(errs) != 0 {
+		t.Errorf("rules = %v (errors %v), want only the balance rule", rules, errs)
+	}
+}
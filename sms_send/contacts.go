@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// ContactRequest 保存联系人的请求
+type ContactRequest struct {
+	Name string `json:"name"`
+}
+
+// listContactsHandler 返回所有联系人
+func listContactsHandler(c *gin.Context) {
+	contacts, err := store.Contacts.List()
+	if err != nil {
+		log.Errorf("Error listing contacts: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to list contacts"})
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: contacts, Total: len(contacts)})
+}
+
+// saveContactHandler 新建或修改号码对应的联系人名称
+func saveContactHandler(c *gin.Context) {
+	number := c.Param("number")
+	var req ContactRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid request: " + err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "name must be 1-100 characters"})
+		return
+	}
+	if err := store.Contacts.Upsert(Contact{Number: number, Name: req.Name}); err != nil {
+		log.Errorf("Error saving contact: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to save contact"})
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "Contact saved"})
+}
+
+// deleteContactHandler 删除联系人
+func deleteContactHandler(c *gin.Context) {
+	number := c.Param("number")
+	found, err := store.Contacts.Delete(number)
+	if err != nil {
+		log.Errorf("Error deleting contact: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to delete contact"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, APIResponse{Success: false, Message: "Contact not found"})
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "Contact deleted"})
+}
+
+// contactName 返回号码对应的联系人名称，查询失败或没有联系人时返回空
+func contactName(number string) string {
+	names, err := store.Contacts.Names([]string{number})
+	if err != nil {
+		log.Warnf("Failed to look up contact for %s: %v", number, err)
+	}
+	return names[number]
+}
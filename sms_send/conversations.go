@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// conversationCond 返回匹配某个会话全部消息的条件，拆成两个方向以便使用 (from_number, to_number) 索引
+func conversationCond(key ConversationKey) (string, []interface{}) {
+	return "((direction = 'incoming' AND from_number = ? AND to_number = ?) OR (direction = 'outgoing' AND from_number = ? AND to_number = ?))",
+		[]interface{}{key.OtherParty, key.LocalNumber, key.LocalNumber, key.OtherParty}
+}
+
+// otherPartyCond 返回与某个对方号码往来的全部消息的条件，不区分本机 SIM
+func otherPartyCond(number string) (string, []interface{}) {
+	return "((direction = 'incoming' AND from_number = ?) OR (direction = 'outgoing' AND to_number = ?))",
+		[]interface{}{number, number}
+}
+
+// smsConversationKey 返回一条短信所属的会话
+func smsConversationKey(direction, fromNumber, toNumber string) ConversationKey {
+	if direction == "incoming" {
+		return ConversationKey{LocalNumber: toNumber, OtherParty: fromNumber}
+	}
+	return ConversationKey{LocalNumber: fromNumber, OtherParty: toNumber}
+}
+
+// encodeCursor 把排序键编码为不透明的分页游标
+func encodeCursor(t time.Time, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", t.UnixNano(), id)))
+}
+
+// decodeCursor 解析 encodeCursor 生成的游标
+func decodeCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	ts, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid cursor")
+	}
+	return time.Unix(0, nanos), n, nil
+}
+
+// cursorParam 解析 cursor 参数，未传时返回 nil
+func cursorParam(c *gin.Context) (*Cursor, error) {
+	cursor := c.Query("cursor")
+	if cursor == "" {
+		return nil, nil
+	}
+	t, id, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	return &Cursor{Time: t, ID: id}, nil
+}
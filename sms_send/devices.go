@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DeviceConfig 是 forward.yaml 中 devices 段对单个设备的配置
+//
+//	devices:
+//	  quectel0:
+//	    number: "+8618612345678"         # SIM 卡本机号码，AMI 读不到号码时使用
+//	    phone_ids: "SIM1_18612345678"    # 拨号计划推送时使用的 phone_id 别名
+type DeviceConfig struct {
+	Device   string
+	Number   string
+	PhoneIDs []string
+}
+
+// DeviceInfo 是通过 AMI 查询到的设备状态
+type DeviceInfo struct {
+	Device       string    `json:"device"`
+	State        string    `json:"state"`
+	Number       string    `json:"number"`
+	IMSI         string    `json:"imsi"`
+	RSSI         string    `json:"rssi"`
+	Provider     string    `json:"provider"`
+	Registration string    `json:"registration"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// DeviceRegistry 缓存设备配置和 AMI 设备状态，用于把 phone_id/设备名解析为本机号码
+type DeviceRegistry struct {
+	mu      sync.RWMutex
+	configs map[string]*DeviceConfig
+	infos   map[string]*DeviceInfo
+}
+
+var devices = &DeviceRegistry{configs: map[string]*DeviceConfig{}, infos: map[string]*DeviceInfo{}}
+
+var phoneNumberPattern = regexp.MustCompile(`^\+?[0-9]{5,20}$`)
+
+// loadDeviceConfigs 解析 forward.yaml 中的 devices 段
+func loadDeviceConfigs(v interface{}) map[string]*DeviceConfig {
+	configs := map[string]*DeviceConfig{}
+	section, ok := v.(map[string]interface{})
+	if !ok {
+		return configs
+	}
+	for name, raw := range section {
+		settings, ok := raw.(map[string]interface{})
+		if !ok {
+			log.Warnf("设备配置格式错误: %s", name)
+			continue
+		}
+		number, _ := settings["number"].(string)
+		configs[name] = &DeviceConfig{
+			Device:   name,
+			Number:   strings.TrimSpace(number),
+			PhoneIDs: configStringList(settings["phone_ids"]),
+		}
+	}
+	return configs
+}
+
+// SetConfigs 替换设备配置
+func (r *DeviceRegistry) SetConfigs(configs map[string]*DeviceConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.configs = configs
+}
+
+// SetInfos 替换 AMI 查询到的设备状态，返回与上次相比状态有变化的设备
+func (r *DeviceRegistry) SetInfos(infos []*DeviceInfo) []*DeviceInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var changed []*DeviceInfo
+	previous := r.infos
+	r.infos = make(map[string]*DeviceInfo, len(infos))
+	for _, info := range infos {
+		r.infos[info.Device] = info
+		if old := previous[info.Device]; old == nil || old.State != info.State || old.Number != info.Number ||
+			old.RSSI != info.RSSI || old.Provider != info.Provider || old.Registration != info.Registration {
+			changed = append(changed, info)
+		}
+	}
+	return changed
+}
+
+// Infos 返回所有设备状态的快照，按设备名排序
+func (r *DeviceRegistry) Infos() []DeviceInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]DeviceInfo, 0, len(r.infos))
+	for _, info := range r.infos {
+		out = append(out, *info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Device < out[j].Device })
+	return out
+}
+
+// DeviceFor 将设备名或 phone_id 别名解析为设备名，未知时返回空字符串
+func (r *DeviceRegistry) DeviceFor(phoneID string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.configs[phoneID]; ok {
+		return phoneID
+	}
+	if _, ok := r.infos[phoneID]; ok {
+		return phoneID
+	}
+	for name, cfg := range r.configs {
+		if containsString(cfg.PhoneIDs, phoneID) {
+			return name
+		}
+	}
+	for name, cfg := range r.configs {
+		if cfg.Number != "" && cfg.Number == phoneID {
+			return name
+		}
+	}
+	for name, info := range r.infos {
+		if info.Number != "" && info.Number == phoneID {
+			return name
+		}
+	}
+	return ""
+}
+
+// Aliases 返回设备在 sms_log.phone_id 中可能出现的所有取值：设备名、配置的别名和本机号码
+func (r *DeviceRegistry) Aliases(device string) []string {
+	aliases := []string{device}
+	if n := r.NumberFor(device); n != "" {
+		aliases = append(aliases, n)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if cfg, ok := r.configs[device]; ok {
+		aliases = append(aliases, cfg.PhoneIDs...)
+	}
+	return aliases
+}
+
+// DeviceSummary 是前端设备选择器使用的设备信息
+type DeviceSummary struct {
+	Device string      `json:"device"`
+	Number string      `json:"number"`
+	Info   *DeviceInfo `json:"info,omitempty"`
+}
+
+// Summaries 合并配置和 AMI 查询到的设备，按设备名排序
+func (r *DeviceRegistry) Summaries() []DeviceSummary {
+	r.mu.RLock()
+	names := map[string]bool{}
+	for name := range r.configs {
+		names[name] = true
+	}
+	for name := range r.infos {
+		names[name] = true
+	}
+	r.mu.RUnlock()
+
+	out := make([]DeviceSummary, 0, len(names))
+	for name := range names {
+		r.mu.RLock()
+		info := r.infos[name]
+		r.mu.RUnlock()
+		var infoCopy *DeviceInfo
+		if info != nil {
+			c := *info
+			infoCopy = &c
+		}
+		out = append(out, DeviceSummary{Device: name, Number: r.NumberFor(name), Info: infoCopy})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Device < out[j].Device })
+	return out
+}
+
+// NumberFor 返回设备名或 phone_id 对应的本机号码。
+// 优先使用配置的号码，其次是 AMI 读到的 Subscriber Number；phone_id 本身就是号码时直接返回。
+func (r *DeviceRegistry) NumberFor(phoneID string) string {
+	if device := r.DeviceFor(phoneID); device != "" {
+		r.mu.RLock()
+		cfg, info := r.configs[device], r.infos[device]
+		r.mu.RUnlock()
+		if cfg != nil && cfg.Number != "" {
+			return cfg.Number
+		}
+		if info != nil && info.Number != "" {
+			return info.Number
+		}
+	}
+	if phoneNumberPattern.MatchString(phoneID) {
+		return phoneID
+	}
+	return ""
+}
+
+// deviceName 将 phone_id 解析为设备名，未知的 phone_id 原样返回
+func deviceName(phoneID string) string {
+	if device := devices.DeviceFor(phoneID); device != "" {
+		return device
+	}
+	return phoneID
+}
+
+// localNumber 返回用于 sms_log 的本机号码，无法解析时沿用 "unknown"
+func localNumber(phoneID string) string {
+	if n := devices.NumberFor(phoneID); n != "" {
+		return n
+	}
+	return "unknown"
+}
+
+// parseDeviceState 解析 "quectel show device state" 的输出
+func parseDeviceState(device string, lines []string) *DeviceInfo {
+	fields := map[string]string{}
+	for _, line := range lines {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	info := &DeviceInfo{
+		Device:       device,
+		State:        fields["State"],
+		Number:       fields["Subscriber Number"],
+		IMSI:         fields["IMSI"],
+		RSSI:         fields["RSSI"],
+		Provider:     fields["Provider Name"],
+		Registration: fields["GSM Registration Status"],
+		UpdatedAt:    time.Now(),
+	}
+	if strings.EqualFold(info.Number, "unknown") {
+		info.Number = ""
+	}
+	return info
+}
+
+// parseDeviceList 从 "quectel show devices" 的输出中取出设备名
+func parseDeviceList(lines []string) []string {
+	var names []string
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] == "ID" {
+			continue
+		}
+		names = append(names, fields[0])
+	}
+	return names
+}
+
+// queryDevices 通过 AMI 查询所有 quectel 设备的状态
+func queryDevices(ctx context.Context, session *amiSession) ([]*DeviceInfo, error) {
+	lines, err := session.Command(ctx, "quectel show devices")
+	if err != nil {
+		return nil, err
+	}
+	var infos []*DeviceInfo
+	for _, name := range parseDeviceList(lines) {
+		state, err := session.Command(ctx, "quectel show device state "+name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query device %s: %w", name, err)
+		}
+		infos = append(infos, parseDeviceState(name, state))
+	}
+	return infos, nil
+}
+
+func devicePollInterval() time.Duration {
+	if sec, err := strconv.Atoi(os.Getenv("DEVICE_POLL_INTERVAL")); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	return 60 * time.Second
+}
+
+// startDeviceMonitor 周期性地通过 AMI 刷新设备状态，连接断开时在下一个周期重连，停机时登出 AMI
+func startDeviceMonitor() {
+	runBackground(func() {
+		var session *amiSession
+		defer func() {
+			if session != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				session.Close(ctx)
+				cancel()
+				amiConnected.Set(0)
+			}
+		}()
+		backfilled, connected := false, false
+		for {
+			ctx, cancel := context.WithTimeout(appCtx, 30*time.Second)
+			var amiErr error
+			if session == nil {
+				amiConfig, err := getAMIConfig()
+				if err == nil {
+					session, err = dialAMI(ctx, amiConfig)
+				}
+				if err != nil {
+					amiErr = err
+					log.Warnf("Device monitor: AMI unavailable: %v", err)
+				} else if connected {
+					amiReconnectsTotal.Inc()
+				}
+			}
+			if session != nil {
+				infos, err := queryDevices(ctx, session)
+				if err != nil {
+					amiErr = err
+					log.Warnf("Device monitor: query failed, reconnecting next cycle: %v", err)
+					session.Close(ctx)
+					session = nil
+				} else {
+					connected = true
+					observeDevices(infos)
+					dispatchDeviceAlerts(alertMonitor.Observe(alertConfig, infos, time.Now()))
+					for _, info := range devices.SetInfos(infos) {
+						eventBus.Publish(StreamDevice, info.Device, *info)
+					}
+				}
+			}
+			cancel()
+			if amiErr != nil {
+				dispatchDeviceAlerts(alertMonitor.ObserveAMIDown(alertConfig, amiErr, time.Now()))
+			}
+			amiHealth.Set(amiErr)
+			if session != nil {
+				amiConnected.Set(1)
+			} else {
+				amiConnected.Set(0)
+			}
+			// 首轮查询之后回填一次，配置的号码映射在 AMI 不可用时同样生效
+			if !backfilled {
+				backfillLocalNumbers()
+				backfilled = true
+			}
+			if !sleepContext(devicePollInterval()) {
+				return
+			}
+		}
+	})
+}
+
+// backfillLocalNumbers 为历史记录中 from/to 为 "unknown" 的本机号码按 phone_id 回填
+func backfillLocalNumbers() {
+	phoneIDs, err := store.SMS.UnknownLocalPhoneIDs()
+	if err != nil {
+		log.Errorf("Backfill: %v", err)
+		return
+	}
+
+	var updated int64
+	for _, phoneID := range phoneIDs {
+		number := devices.NumberFor(phoneID)
+		if number == "" {
+			continue
+		}
+		nIn, nOut, err := store.SMS.SetLocalNumber(phoneID, number)
+		if err != nil {
+			log.Errorf("Backfill: %v", err)
+			continue
+		}
+		if nIn+nOut > 0 {
+			log.Infof("Backfill: set local number %s for phone_id %s (%d incoming, %d outgoing)", number, phoneID, nIn, nOut)
+		}
+		updated += nIn + nOut
+	}
+	// 本机号码变化后会话的归属也随之改变，重建会话汇总
+	if updated > 0 {
+		if err := store.SMS.RebuildConversations(); err != nil {
+			log.Errorf("Backfill: %v", err)
+		}
+	}
+}
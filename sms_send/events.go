@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// 实时事件类型，作为 SSE 的 event 字段
+const (
+	StreamSMS         = "sms"          // 新的收发短信，data 为 SMSMessage
+	StreamSMSStatus   = "sms_status"   // 已读、归档、删除等状态变化，data 为 {"action": ...}，客户端应重新拉取
+	StreamCall        = "call"         // 新的通话记录，data 为 CallRecord
+	StreamDevice      = "device"       // 设备状态变化，data 为 DeviceInfo
+	StreamDeviceAlert = "device_alert" // 设备告警或恢复，data 为 DeviceAlert
+)
+
+const (
+	// eventSubscriberBuffer 每个订阅者缓冲的事件数，缓冲满时丢弃新事件，慢客户端不会阻塞发布方
+	eventSubscriberBuffer = 64
+	eventHeartbeat        = 25 * time.Second
+)
+
+// StreamEvent 是事件总线上的一个事件
+type StreamEvent struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+	// PhoneID 是事件所属设备的 phone_id，用于按用户可访问的设备过滤；为空时所有订阅者可见
+	PhoneID string `json:"-"`
+}
+
+// EventBus 把短信、通话和设备事件分发给所有订阅者
+type EventBus struct {
+	mu   sync.Mutex
+	seq  uint64
+	subs map[chan StreamEvent]struct{}
+}
+
+var eventBus = &EventBus{subs: map[chan StreamEvent]struct{}{}}
+
+// Publish 发布事件，不等待订阅者
+func (b *EventBus) Publish(kind, phoneID string, data interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	ev := StreamEvent{ID: b.seq, Type: kind, Time: time.Now(), Data: data, PhoneID: phoneID}
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			log.Warnf("Event subscriber is too slow, dropping %s event %d", kind, ev.ID)
+		}
+	}
+}
+
+// Subscribe 注册订阅者，返回事件通道和取消订阅的函数
+func (b *EventBus) Subscribe() (<-chan StreamEvent, func()) {
+	ch := make(chan StreamEvent, eventSubscriberBuffer)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// Subscribers 返回当前订阅者数量
+func (b *EventBus) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// Pending 返回所有订阅者尚未写出的事件数
+func (b *EventBus) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for ch := range b.subs {
+		n += len(ch)
+	}
+	return n
+}
+
+// visibleTo 判断事件是否属于主体可访问的设备
+func (ev StreamEvent) visibleTo(p *Principal) bool {
+	if len(p.Devices) == 0 || ev.PhoneID == "" {
+		return true
+	}
+	return containsString(allowedPhoneIDs(p), ev.PhoneID)
+}
+
+// eventsHandler 以 Server-Sent Events 推送实时事件，只推送当前用户可访问设备的事件。
+// 连接空闲时每 25 秒发送一次注释行保活。
+func eventsHandler(c *gin.Context) {
+	p := currentPrincipal(c)
+	ch, cancel := eventBus.Subscribe()
+	defer cancel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	io.WriteString(c.Writer, "retry: 5000\n\n")
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-appCtx.Done():
+			// 停机时结束长连接，客户端会按 retry 重连
+			return false
+		case <-heartbeat.C:
+			io.WriteString(w, ": ping\n\n")
+			return true
+		case ev := <-ch:
+			if ev.visibleTo(p) {
+				c.SSEvent(ev.Type, ev)
+			}
+			return true
+		}
+	})
+}
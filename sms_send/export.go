@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// 导出格式
+const (
+	ExportCSV   = "csv"
+	ExportJSONL = "jsonl"
+	ExportXML   = "xml" // Android 应用 SMS Backup & Restore 的备份格式
+)
+
+// SMS Backup & Restore 中短信的 type：1 收到、2 已发送、3 草稿、4 发件箱、5 发送失败、6 排队中
+const (
+	backupSMSReceived = 1
+	backupSMSSent     = 2
+	backupSMSFailed   = 5
+)
+
+// SMS Backup & Restore 中通话的 type 与本服务 call_type 的对应关系
+var backupCallTypes = map[int]string{
+	1: "incoming",
+	2: "outgoing",
+	3: "missed",
+	4: "voicemail",
+	5: "rejected",
+	6: "blocked",
+}
+
+// BackupSMS 是 SMS Backup & Restore XML 中的 <sms> 元素
+type BackupSMS struct {
+	XMLName       xml.Name `xml:"sms"`
+	Protocol      string   `xml:"protocol,attr"`
+	Address       string   `xml:"address,attr"`
+	Date          int64    `xml:"date,attr"`
+	Type          int      `xml:"type,attr"`
+	Subject       string   `xml:"subject,attr"`
+	Body          string   `xml:"body,attr"`
+	TOA           string   `xml:"toa,attr"`
+	SCTOA         string   `xml:"sc_toa,attr"`
+	ServiceCenter string   `xml:"service_center,attr"`
+	Read          int      `xml:"read,attr"`
+	Status        int      `xml:"status,attr"`
+	Locked        int      `xml:"locked,attr"`
+	DateSent      int64    `xml:"date_sent,attr"`
+	ReadableDate  string   `xml:"readable_date,attr"`
+	ContactName   string   `xml:"contact_name,attr"`
+}
+
+// BackupCall 是 SMS Backup & Restore XML 中的 <call> 元素
+type BackupCall struct {
+	XMLName      xml.Name `xml:"call"`
+	Number       string   `xml:"number,attr"`
+	Duration     int      `xml:"duration,attr"`
+	Date         int64    `xml:"date,attr"`
+	Type         int      `xml:"type,attr"`
+	Presentation int      `xml:"presentation,attr"`
+	ReadableDate string   `xml:"readable_date,attr"`
+	ContactName  string   `xml:"contact_name,attr"`
+}
+
+// exportQuery 从请求参数中读取 number、since、until
+func exportQuery(c *gin.Context, scope SMSScope) (ExportQuery, error) {
+	q := ExportQuery{Scope: scope, Number: c.Query("number")}
+	for _, f := range []struct {
+		param    string
+		dst      **time.Time
+		endOfDay bool
+	}{{"since", &q.Since, false}, {"until", &q.Until, true}} {
+		if v := c.Query(f.param); v != "" {
+			t, err := parseSearchTime(v, f.endOfDay)
+			if err != nil {
+				return q, fmt.Errorf("%s must be RFC3339 or YYYY-MM-DD", f.param)
+			}
+			*f.dst = &t
+		}
+	}
+	return q, nil
+}
+
+// exportFormat 读取 format 参数并设置下载响应头
+func exportFormat(c *gin.Context, name string) (string, bool) {
+	format := c.DefaultQuery("format", ExportCSV)
+	contentType := map[string]string{
+		ExportCSV:   "text/csv; charset=utf-8",
+		ExportJSONL: "application/x-ndjson",
+		ExportXML:   "application/xml; charset=utf-8",
+	}[format]
+	if contentType == "" {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "format must be csv, jsonl or xml"})
+		return "", false
+	}
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("20060102-150405"), format)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	return format, true
+}
+
+// callScope 返回通话记录的查询范围：通话记录只有 phone_id，device/phone_id 参数展开为设备的所有别名
+func callScope(c *gin.Context) (SMSScope, bool) {
+	p := currentPrincipal(c)
+	scope := SMSScope{PhoneIDs: allowedPhoneIDs(p)}
+	for _, param := range []string{"device", "phone_id"} {
+		if v := c.Query(param); v != "" {
+			if !p.CanAccessDevice(v) {
+				c.JSON(http.StatusForbidden, APIResponse{Success: false, Message: "No access to device " + v})
+				return scope, false
+			}
+			scope.PhoneIDs = devices.Aliases(v)
+			break
+		}
+	}
+	return scope, true
+}
+
+// backupTime 把 SMS Backup & Restore 的毫秒时间戳转换为时间
+func backupTime(ms int64) time.Time {
+	return time.UnixMilli(ms)
+}
+
+// readableDate 生成备份文件中的 readable_date
+func readableDate(t time.Time) string {
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+// exportSMSHandler 导出短信，支持 number、since/until、device/phone_id/local 过滤
+func exportSMSHandler(c *gin.Context) {
+	q, err := exportQuery(c, smsScope(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: err.Error()})
+		return
+	}
+	count, err := store.SMS.CountExport(q)
+	if err != nil {
+		log.Errorf("Error exporting messages: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Export failed"})
+		return
+	}
+	format, ok := exportFormat(c, "sms")
+	if !ok {
+		return
+	}
+	names := map[string]string{}
+	if contacts, err := store.Contacts.List(); err != nil {
+		log.Warnf("Failed to look up contact names: %v", err)
+	} else {
+		for _, ct := range contacts {
+			names[ct.Number] = ct.Name
+		}
+	}
+
+	w := bufio.NewWriter(c.Writer)
+	defer w.Flush()
+	var write func(SMSMessage) error
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		defer cw.Flush()
+		cw.Write([]string{"id", "direction", "from_number", "to_number", "body", "status", "device", "phone_id", "read_at", "created_at"})
+		write = func(m SMSMessage) error {
+			readAt := ""
+			if m.ReadAt != nil {
+				readAt = m.ReadAt.Format(time.RFC3339)
+			}
+			return cw.Write([]string{strconv.Itoa(m.ID), m.Direction, m.FromNumber, m.ToNumber, m.Body, m.Status,
+				devices.DeviceFor(m.PhoneID), m.PhoneID, readAt, m.CreatedAt.Format(time.RFC3339)})
+		}
+	case ExportJSONL:
+		enc := json.NewEncoder(w)
+		write = func(m SMSMessage) error {
+			m.Device = devices.DeviceFor(m.PhoneID)
+			return enc.Encode(m)
+		}
+	case ExportXML:
+		fmt.Fprintf(w, "<?xml version='1.0' encoding='UTF-8' standalone='yes' ?>\n<smses count=\"%d\">\n", count)
+		defer io.WriteString(w, "</smses>\n")
+		write = func(m SMSMessage) error {
+			key := smsConversationKey(m.Direction, m.FromNumber, m.ToNumber)
+			b := BackupSMS{
+				Protocol: "0", Address: key.OtherParty, Date: m.CreatedAt.UnixMilli(), Type: backupSMSReceived,
+				Subject: "null", Body: m.Body, TOA: "null", SCTOA: "null", ServiceCenter: "null", Status: -1,
+				ReadableDate: readableDate(m.CreatedAt), ContactName: names[key.OtherParty],
+			}
+			if m.Direction == "outgoing" {
+				b.Type = backupSMSSent
+				if m.Status == "failed" {
+					b.Type = backupSMSFailed
+				}
+			}
+			if m.ReadAt != nil || m.Direction == "outgoing" {
+				b.Read = 1
+			}
+			if b.ContactName == "" {
+				b.ContactName = "(Unknown)"
+			}
+			return writeXMLLine(w, b)
+		}
+	}
+	rows := 0
+	err = store.SMS.Export(q, func(m SMSMessage) error {
+		rows++
+		return write(m)
+	})
+	detail := exportAuditDetail(c, format, rows)
+	if err != nil {
+		// 响应头已发送，只能记录日志
+		log.Errorf("Error exporting messages: %v", err)
+		recordAudit(c, AuditExport, "sms", false, detail+" error="+err.Error())
+		return
+	}
+	recordAudit(c, AuditExport, "sms", true, detail)
+}
+
+// exportCallsHandler 导出通话记录，支持 number、since/until、device/phone_id 过滤
+func exportCallsHandler(c *gin.Context) {
+	scope, ok := callScope(c)
+	if !ok {
+		return
+	}
+	q, err := exportQuery(c, scope)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: err.Error()})
+		return
+	}
+	count, err := store.Calls.CountExport(q)
+	if err != nil {
+		log.Errorf("Error exporting calls: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Export failed"})
+		return
+	}
+	format, ok := exportFormat(c, "calls")
+	if !ok {
+		return
+	}
+
+	w := bufio.NewWriter(c.Writer)
+	defer w.Flush()
+	var write func(CallRecord) error
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		defer cw.Flush()
+		cw.Write([]string{"id", "call_type", "phone_number", "contact_name", "duration_seconds", "call_time", "device", "phone_id", "source", "created_at"})
+		write = func(r CallRecord) error {
+			return cw.Write([]string{strconv.Itoa(r.ID), r.CallType, r.PhoneNumber, r.ContactName, strconv.Itoa(r.DurationSeconds),
+				r.CallTime, devices.DeviceFor(r.PhoneID), r.PhoneID, r.Source, r.CreatedAt.Format(time.RFC3339)})
+		}
+	case ExportJSONL:
+		enc := json.NewEncoder(w)
+		write = func(r CallRecord) error { return enc.Encode(r) }
+	case ExportXML:
+		fmt.Fprintf(w, "<?xml version='1.0' encoding='UTF-8' standalone='yes' ?>\n<calls count=\"%d\">\n", count)
+		defer io.WriteString(w, "</calls>\n")
+		write = func(r CallRecord) error {
+			b := BackupCall{
+				Number: r.PhoneNumber, Duration: r.DurationSeconds, Date: r.CreatedAt.UnixMilli(), Type: 1, Presentation: 1,
+				ReadableDate: readableDate(r.CreatedAt), ContactName: r.ContactName,
+			}
+			for t, name := range backupCallTypes {
+				if name == r.CallType {
+					b.Type = t
+				}
+			}
+			if b.ContactName == "" {
+				b.ContactName = "(Unknown)"
+			}
+			return writeXMLLine(w, b)
+		}
+	}
+	rows := 0
+	err = store.Calls.Export(q, func(r CallRecord) error {
+		rows++
+		return write(r)
+	})
+	detail := exportAuditDetail(c, format, rows)
+	if err != nil {
+		log.Errorf("Error exporting calls: %v", err)
+		recordAudit(c, AuditExport, "calls", false, detail+" error="+err.Error())
+		return
+	}
+	recordAudit(c, AuditExport, "calls", true, detail)
+}
+
+// exportAuditDetail 生成导出的审计详情：格式、导出的行数和请求中的过滤参数
+func exportAuditDetail(c *gin.Context, format string, rows int) string {
+	detail := fmt.Sprintf("format=%s rows=%d", format, rows)
+	for _, param := range []string{"number", "since", "until", "device", "phone_id", "local"} {
+		if v := c.Query(param); v != "" {
+			detail += fmt.Sprintf(" %s=%s", param, v)
+		}
+	}
+	return detail
+}
+
+// writeXMLLine 输出一个缩进的 XML 元素
+func writeXMLLine(w io.Writer, v interface{}) error {
+	out, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "  %s\n", out)
+	return err
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExportIsAudited(t *testing.T) {
+	newTestRouter(t)
+	for _, body := range []string{"第一条", "第二条"} {
+		if err := insertSMSLog("incoming", "13800000001", "unknown", body, "received", "quectel0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/export/sms?format=jsonl&number=13800000001&since=2020-01-01", nil)
+	req.Header.Set("X-Auth-Secret", testSecret)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export = %d %s", w.Code, w.Body.String())
+	}
+	if lines := strings.Count(w.Body.String(), "\n"); lines != 2 {
+		t.Fatalf("exported %d lines, want 2", lines)
+	}
+
+	var actor, target, result, detail string
+	err := db.QueryRow("SELECT actor, target, result, detail FROM audit_log WHERE action = ?", AuditExport).Scan(&actor, &target, &result, &detail)
+	if err != nil {
+		t.Fatalf("export audit entry: %v", err)
+	}
+	if actor != "secret" || target != "sms" || result != "success" {
+		t.Errorf("audit entry = %s %s %s, want secret sms success", actor, target, result)
+	}
+	for _, want := range []string{"format=jsonl", "rows=2", "number=13800000001", "since=2020-01-01"} {
+		if !strings.Contains(detail, want) {
+			t.Errorf("audit detail %q does not contain %q", detail, want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 组件状态
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// ComponentHealth 是单个组件的检查结果
+type ComponentHealth struct {
+	Status    string     `json:"status"`
+	Detail    string     `json:"detail,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// HealthReport 是 /healthz、/readyz 的响应
+type HealthReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components,omitempty"`
+}
+
+// amiHealthState 记录设备监控最近一次 AMI 登录和查询的结果，/readyz 不单独登录 AMI
+type amiHealthState struct {
+	mu        sync.Mutex
+	err       error
+	checkedAt time.Time
+}
+
+var amiHealth = &amiHealthState{}
+
+// Set 记录一次 AMI 检查结果，err 为 nil 表示登录和查询成功
+func (s *amiHealthState) Set(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err, s.checkedAt = err, time.Now()
+}
+
+func (s *amiHealthState) check() ComponentHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checkedAt.IsZero() {
+		return ComponentHealth{Status: HealthFail, Detail: "not checked yet"}
+	}
+	checkedAt := s.checkedAt
+	h := ComponentHealth{Status: HealthOK, CheckedAt: &checkedAt}
+	// 设备监控卡住时结果会过期，允许错过两个周期
+	if stale := 3*devicePollInterval() + 30*time.Second; time.Since(s.checkedAt) > stale {
+		h.Status, h.Detail = HealthFail, "device monitor has not reported since "+s.checkedAt.Format(time.RFC3339)
+	} else if s.err != nil {
+		h.Status, h.Detail = HealthFail, s.err.Error()
+	}
+	return h
+}
+
+func checkDatabase(ctx context.Context) ComponentHealth {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := db.PingContext(ctx); err != nil {
+		return ComponentHealth{Status: HealthFail, Detail: err.Error()}
+	}
+	return ComponentHealth{Status: HealthOK, Detail: fmt.Sprintf("%s, %dms", store.Dialect.Name(), time.Since(start).Milliseconds())}
+}
+
+// checkModems 要求至少一个设备已注册到网络
+func checkModems() ComponentHealth {
+	infos := devices.Infos()
+	var registered []string
+	for _, info := range infos {
+		if strings.HasPrefix(info.Registration, "Registered") {
+			registered = append(registered, info.Device)
+		}
+	}
+	if len(registered) == 0 {
+		return ComponentHealth{Status: HealthFail, Detail: fmt.Sprintf("no registered modem (%d found)", len(infos))}
+	}
+	return ComponentHealth{Status: HealthOK, Detail: fmt.Sprintf("%d/%d registered: %s", len(registered), len(infos), strings.Join(registered, ", "))}
+}
+
+// checkConfig 报告 forward.yaml 中被跳过的规则
+func checkConfig() ComponentHealth {
+	if len(forwardRuleErrors) > 0 {
+		return ComponentHealth{Status: HealthFail, Detail: strings.Join(forwardRuleErrors, "; ")}
+	}
+	return ComponentHealth{Status: HealthOK, Detail: fmt.Sprintf("%d rules", len(forwardRules))}
+}
+
+// healthzHandler 存活检查，进程能处理请求即返回 200
+func healthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthReport{Status: HealthOK})
+}
+
+// readyzChecks 是 /readyz 的各项检查，按名称可通过 components 参数选择
+var readyzChecks = map[string]func(ctx context.Context) ComponentHealth{
+	"database": checkDatabase,
+	"ami":      func(context.Context) ComponentHealth { return amiHealth.check() },
+	"modems":   func(context.Context) ComponentHealth { return checkModems() },
+	"config":   func(context.Context) ComponentHealth { return checkConfig() },
+}
+
+// readyzHandler 就绪检查：数据库、AMI 登录、已注册的设备和转发配置，任一失败返回 503。
+// components=database,ami 只检查列出的组件，供容器 HEALTHCHECK 使用
+func readyzHandler(c *gin.Context) {
+	names := configStringList(c.Query("components"))
+	if len(names) == 0 {
+		names = []string{"database", "ami", "modems", "config"}
+	}
+	report := HealthReport{Status: HealthOK, Components: map[string]ComponentHealth{}}
+	code := http.StatusOK
+	for _, name := range names {
+		check, ok := readyzChecks[name]
+		if !ok {
+			c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Unknown component: " + name})
+			return
+		}
+		h := check(c.Request.Context())
+		report.Components[name] = h
+		if h.Status != HealthOK {
+			report.Status, code = HealthFail, http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(code, report)
+}
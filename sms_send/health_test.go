@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyzComponents(t *testing.T) {
+	newTestRouter(t)
+	router.GET("/readyz", readyzHandler)
+	old := amiHealth
+	amiHealth = &amiHealthState{}
+	t.Cleanup(func() { amiHealth = old })
+	amiHealth.Set(errors.New("login failed"))
+
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{"?components=database", http.StatusOK},
+		{"?components=database,ami", http.StatusServiceUnavailable},
+		{"?components=database,bogus", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz"+tt.query, nil))
+		if w.Code != tt.code {
+			t.Errorf("GET /readyz%s = %d %s, want %d", tt.query, w.Code, w.Body.String(), tt.code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz?components=database,ami", nil))
+	var report HealthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Components) != 2 || report.Components["ami"].Detail != "login failed" || report.Components["database"].Status != HealthOK {
+		t.Errorf("report = %+v, want only database ok and ami failing", report)
+	}
+}
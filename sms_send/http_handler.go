@@ -1,7 +1,7 @@
 package main
 
 import (
-	"database/sql"
+	"encoding/base64"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
@@ -38,6 +38,8 @@ type APIResponse struct {
 	Message string      `json:"message"`
 	Data    interface{} `json:"data,omitempty"`
 	Total   int         `json:"total,omitempty"`
+	// NextCursor 用于游标分页，传给下一次请求的 cursor 参数以获取更早的记录，没有更多记录时为空
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 type CallRequest struct {
@@ -45,52 +47,123 @@ type CallRequest struct {
 	Number    string `json:"number"`
 	Name      string `json:"name"`
 	Time      string `json:"time"`
-	Type      string `json:"type"` // "incoming", "outgoing", "missed", "ended", "unknown"
+	Type      string `json:"type"` // asterisk 推送 "ringing"（响铃）、"answered"/"missed"（挂机）；其他来源可能有 "incoming"、"outgoing" 等
 	Duration  int    `json:"duration"`
 	Source    string `json:"source"`
 	PhoneID   string `json:"phone_id"`
 	Timestamp string `json:"timestamp"`
 }
 
+// USSDRequest 接收来自 asterisk 的 USSD 推送，内容使用 base64 传输避免转义问题
+type USSDRequest struct {
+	Secret     string `json:"secret"`
+	TextBase64 string `json:"text_base64"`
+	Device     string `json:"device"`
+	Time       string `json:"time"`
+	Source     string `json:"source"`
+	PhoneID    string `json:"phone_id"`
+}
+
 // Conversation represents a summary of an SMS conversation.
 type Conversation struct {
+	LocalNumber   string    `json:"local_number"`
+	Device        string    `json:"device"`
 	OtherParty    string    `json:"other_party"`
 	LastMessage   string    `json:"last_message"`
 	LastMessageAt time.Time `json:"last_message_at"`
 	TotalMessages int       `json:"total_messages"`
+	UnreadCount   int       `json:"unread_count"`
+	Archived      bool      `json:"archived"`
+	LastMessageID int       `json:"last_message_id"`
+	ContactName   string    `json:"contact_name,omitempty"`
 }
 
 // SMSMessage represents a single SMS message in a conversation.
 type SMSMessage struct {
-	ID         int       `json:"id"`
-	Direction  string    `json:"direction"`
-	FromNumber string    `json:"from_number"`
-	ToNumber   string    `json:"to_number"`
-	Body       string    `json:"body"`
-	Status     string    `json:"status"`
-	PhoneID    string    `json:"phone_id"`
-	CreatedAt  time.Time `json:"created_at"`
+	ID         int        `json:"id"`
+	Direction  string     `json:"direction"`
+	FromNumber string     `json:"from_number"`
+	ToNumber   string     `json:"to_number"`
+	Body       string     `json:"body"`
+	Status     string     `json:"status"`
+	PhoneID    string     `json:"phone_id"`
+	Device     string     `json:"device"`
+	ReadAt     *time.Time `json:"read_at"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+	Backfilled bool       `json:"backfilled,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
 }
 
 func setupRoutes() {
-	// Public API group for receiving data from Asterisk/Gammu
-	publicApi := router.Group("/api/v1")
+	// Ingest API group for receiving data from Asterisk/Gammu
+	ingestApi := router.Group("/api/v1")
+	ingestApi.Use(signatureMiddleware(), ingestAuthMiddleware())
 	{
-		publicApi.POST("/sms/receive", smsHandler)
-		publicApi.POST("/call/receive", callHandler)
+		ingestApi.POST("/sms/receive", smsHandler)
+		ingestApi.POST("/call/receive", callHandler)
+		ingestApi.POST("/ussd/receive", ussdHandler)
 	}
 
-	// Authenticated API group for frontend
-	authApi := router.Group("/api/v1")
-	authApi.Use(authMiddleware())
+	// Authenticated API for frontend and machine clients
+	api := router.Group("/api/v1")
 	{
-		authApi.POST("/sms/send", sendSMSHandler)
-		authApi.GET("/sms/conversations", getConversationsHandler)
-		authApi.GET("/sms/conversation/:number", getConversationDetailsHandler)
-	}
-
-	// Standalone auth validation route
+		api.POST("/sms/send", authMiddleware(ScopeSMSSend), sendSMSHandler)
+		api.GET("/sms/conversations", authMiddleware(ScopeSMSRead), getConversationsHandler)
+		api.GET("/sms/conversation/:number", authMiddleware(ScopeSMSRead), getConversationDetailsHandler)
+		api.POST("/sms/conversation/:number/read", authMiddleware(ScopeSMSRead), markConversationReadHandler)
+		api.POST("/sms/conversations/bulk", authMiddleware(ScopeSMSRead), conversationBulkHandler)
+		api.POST("/sms/messages/bulk", authMiddleware(ScopeSMSRead), messageBulkHandler)
+		api.GET("/sms/search", authMiddleware(ScopeSMSRead), searchSMSHandler)
+		api.GET("/sms/trash", authMiddleware(ScopeSMSRead), getTrashHandler)
+		api.DELETE("/sms/trash", authMiddleware(ScopeAdmin), emptyTrashHandler)
+		api.GET("/devices", authMiddleware(ScopeSMSRead), getDevicesHandler)
+		api.GET("/balance", authMiddleware(ScopeSMSRead), getBalanceHandler)
+		api.GET("/balance/:device", authMiddleware(ScopeSMSRead), getBalanceHistoryHandler)
+		api.GET("/keepalive", authMiddleware(ScopeSMSRead), getKeepAliveHandler)
+		api.GET("/events", authMiddleware(ScopeSMSRead), eventsHandler)
+		api.GET("/export/sms", authMiddleware(ScopeSMSRead), exportSMSHandler)
+		api.GET("/export/calls", authMiddleware(ScopeSMSRead), exportCallsHandler)
+		api.GET("/contacts", authMiddleware(ScopeSMSRead), listContactsHandler)
+		api.PUT("/contacts/:number", authMiddleware(ScopeSMSSend), saveContactHandler)
+		api.DELETE("/contacts/:number", authMiddleware(ScopeSMSSend), deleteContactHandler)
+		api.GET("/auth/me", authMiddleware(""), meHandler)
+	}
+
+	// User and token management, admin only
+	adminApi := router.Group("/api/v1")
+	adminApi.Use(authMiddleware(ScopeAdmin))
+	{
+		adminApi.GET("/users", listUsersHandler)
+		adminApi.POST("/users", createUserHandler)
+		adminApi.PUT("/users/:id", updateUserHandler)
+		adminApi.DELETE("/users/:id", deleteUserHandler)
+		adminApi.GET("/tokens", listAPITokensHandler)
+		adminApi.POST("/tokens", createAPITokenHandler)
+		adminApi.DELETE("/tokens/:id", revokeAPITokenHandler)
+		adminApi.GET("/audit", getAuditLogHandler)
+		adminApi.GET("/retention", getRetentionHandler)
+		adminApi.POST("/retention/run", runRetentionHandler)
+		adminApi.POST("/import/sms-backup", importBackupHandler)
+		adminApi.GET("/backfill", getBackfillHandler)
+		adminApi.POST("/backfill/run", runBackfillHandler)
+		adminApi.POST("/balance/:device/check", checkBalanceHandler)
+		adminApi.POST("/keepalive/:device/run", runKeepAliveHandler)
+	}
+
+	// Standalone auth routes
 	router.POST("/api/v1/auth/validate", validateSecretHandler)
+	router.POST("/api/v1/auth/login", loginHandler)
+	router.POST("/api/v1/auth/logout", logoutHandler)
+
+	// Token management page
+	router.GET("/tokens", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "tokens.html", nil)
+	})
+
+	// Devices page: modem state, balance and keep-alive
+	router.GET("/devices", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "devices.html", nil)
+	})
 
 	// Route for the conversation detail page
 	router.GET("/conversation/:number", func(c *gin.Context) {
@@ -100,18 +173,6 @@ func setupRoutes() {
 	})
 }
 
-// authMiddleware checks for the X-Auth-Secret header.
-func authMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		secret := c.GetHeader("X-Auth-Secret")
-		if err := validateSecret(secret); err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, APIResponse{Success: false, Message: "Authentication failed"})
-			return
-		}
-		c.Next()
-	}
-}
-
 // validateSecretHandler allows the frontend to check if a secret is valid.
 func validateSecretHandler(c *gin.Context) {
 	var req struct {
@@ -123,101 +184,141 @@ func validateSecretHandler(c *gin.Context) {
 	}
 
 	if err := validateSecret(req.Secret); err != nil {
+		recordAuditAs(c, "secret", "secret", AuditValidateSecret, "", false, "")
 		c.JSON(http.StatusUnauthorized, APIResponse{Success: false, Message: "Invalid secret"})
 		return
 	}
 
+	recordAuditAs(c, "secret", "secret", AuditValidateSecret, "", true, "")
 	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "Secret is valid"})
 }
 
-// getConversationsHandler handles fetching the list of SMS conversations.
+// requestedLocal 解析 local/device/phone_id 参数：能解析为本机号码时返回 local，否则返回需按 phone_id 精确过滤的值
+func requestedLocal(c *gin.Context) (local, phoneID string) {
+	local = c.Query("local")
+	for _, param := range []string{"device", "phone_id"} {
+		if v := c.Query(param); v != "" && local == "" && phoneID == "" {
+			if local = devices.NumberFor(v); local == "" {
+				phoneID = v
+			}
+		}
+	}
+	return local, phoneID
+}
+
+// smsScope 返回当前请求的短信查询范围：用户的设备权限，以及可选的 device/phone_id/local 参数
+func smsScope(c *gin.Context) SMSScope {
+	local, phoneID := requestedLocal(c)
+	return SMSScope{PhoneIDs: allowedPhoneIDs(currentPrincipal(c)), LocalNumber: local, PhoneID: phoneID}
+}
+
+// pageLimit 读取 limit 参数，超出范围时使用默认值
+func pageLimit(c *gin.Context, def, maxLimit int) int {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(def)))
+	if err != nil || limit < 1 || limit > maxLimit {
+		return def
+	}
+	return limit
+}
+
+// getConversationsHandler handles fetching the list of SMS conversations from the conversations summary table.
+// view=inbox (default) hides archived conversations, view=archived shows only archived ones, view=all shows both.
+// Pass next_cursor from the previous response as cursor to fetch older conversations; page is still accepted.
 func getConversationsHandler(c *gin.Context) {
+	limit := pageLimit(c, 10, 200)
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	offset := (page - 1) * limit
-
-	// First, get the total number of conversations
-	var total int
-	totalQuery := `SELECT COUNT(*) FROM (SELECT DISTINCT IF(direction = 'incoming', from_number, to_number) as other_party FROM sms_log) AS T`
-	err := db.QueryRow(totalQuery).Scan(&total)
+	if page < 1 {
+		page = 1
+	}
+	view := c.DefaultQuery("view", "inbox")
+	if view != "inbox" && view != "archived" && view != "all" {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "view must be inbox, archived or all"})
+		return
+	}
+	before, err := cursorParam(c)
 	if err != nil {
-		log.Errorf("Error querying total conversations: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to retrieve total conversations count"})
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: err.Error()})
 		return
 	}
 
-	// Then, get the paginated list of conversations
-	query := `
-        SELECT 
-            other_party, 
-            (SELECT body FROM sms_log WHERE id = T.max_id) as last_message, 
-            (SELECT created_at FROM sms_log WHERE id = T.max_id) as last_message_at, 
-            T.total_messages
-        FROM (
-            SELECT 
-                IF(direction = 'incoming', from_number, to_number) as other_party, 
-                MAX(id) as max_id, 
-                COUNT(*) as total_messages
-            FROM sms_log
-            GROUP BY other_party
-        ) AS T
-        ORDER BY last_message_at DESC
-        LIMIT ? OFFSET ?;
-    `
-
-	rows, err := db.Query(query, limit, offset)
+	conversations, total, err := store.SMS.Conversations(ConversationQuery{
+		Scope:  smsScope(c),
+		View:   view,
+		Limit:  limit,
+		Offset: (page - 1) * limit,
+		Before: before,
+	})
 	if err != nil {
 		log.Errorf("Error querying conversations: %v", err)
 		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to retrieve conversations"})
 		return
 	}
-	defer rows.Close()
 
-	var conversations []Conversation
-	for rows.Next() {
-		var conv Conversation
-		if err := rows.Scan(&conv.OtherParty, &conv.LastMessage, &conv.LastMessageAt, &conv.TotalMessages); err != nil {
-			log.Errorf("Error scanning conversation row: %v", err)
-			continue
-		}
-		conversations = append(conversations, conv)
+	numbers := make([]string, 0, len(conversations))
+	for _, conv := range conversations {
+		numbers = append(numbers, conv.OtherParty)
+	}
+	names, err := store.Contacts.Names(numbers)
+	if err != nil {
+		log.Warnf("Failed to look up contact names: %v", err)
+	}
+	for i := range conversations {
+		conversations[i].Device = devices.DeviceFor(conversations[i].LocalNumber)
+		conversations[i].ContactName = names[conversations[i].OtherParty]
 	}
 
-	c.JSON(http.StatusOK, APIResponse{Success: true, Data: conversations, Total: total})
+	resp := APIResponse{Success: true, Data: conversations, Total: total}
+	if len(conversations) == limit {
+		last := conversations[len(conversations)-1]
+		resp.NextCursor = encodeCursor(last.LastMessageAt, last.LastMessageID)
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
-// getConversationDetailsHandler handles fetching all messages for a specific number.
+// getConversationDetailsHandler returns the latest messages exchanged with a number, oldest first,
+// optionally limited to one local SIM. Pass next_cursor as cursor to load older messages.
 func getConversationDetailsHandler(c *gin.Context) {
 	number := c.Param("number")
+	limit := pageLimit(c, 50, 500)
+	before, err := cursorParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: err.Error()})
+		return
+	}
 
-	query := `
-        SELECT id, direction, from_number, to_number, body, status, phone_id, created_at 
-        FROM sms_log 
-        WHERE from_number = ? OR to_number = ? 
-        ORDER BY created_at ASC;
-    `
-
-	rows, err := db.Query(query, number, number)
+	messages, err := store.SMS.Messages(MessageQuery{Scope: smsScope(c), OtherParty: number, Limit: limit, Before: before})
 	if err != nil {
 		log.Errorf("Error querying conversation details for %s: %v", number, err)
 		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to retrieve conversation details"})
 		return
 	}
-	defer rows.Close()
+	for i := range messages {
+		messages[i].Device = devices.DeviceFor(messages[i].PhoneID)
+	}
 
-	var messages []SMSMessage
-	for rows.Next() {
-		var msg SMSMessage
-		var phoneID sql.NullString // Handle possible NULL phone_id
-		if err := rows.Scan(&msg.ID, &msg.Direction, &msg.FromNumber, &msg.ToNumber, &msg.Body, &msg.Status, &phoneID, &msg.CreatedAt); err != nil {
-			log.Errorf("Error scanning message row: %v", err)
-			continue
-		}
-		msg.PhoneID = phoneID.String
-		messages = append(messages, msg)
+	resp := APIResponse{Success: true}
+	if len(messages) == limit {
+		oldest := messages[len(messages)-1]
+		resp.NextCursor = encodeCursor(oldest.CreatedAt, oldest.ID)
 	}
+	// 查询按时间倒序取最新的一页，返回时恢复为正序
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	resp.Data = messages
+	c.JSON(http.StatusOK, resp)
+}
 
-	c.JSON(http.StatusOK, APIResponse{Success: true, Data: messages})
+// getDevicesHandler 返回当前用户可用的设备及其本机号码和状态
+func getDevicesHandler(c *gin.Context) {
+	p := currentPrincipal(c)
+	var list []DeviceSummary
+	for _, d := range devices.Summaries() {
+		if p.CanAccessDevice(d.Device) {
+			list = append(list, d)
+		}
+	}
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: list, Total: len(list)})
 }
 
 // sendSMSHandler is the HTTP handler for sending SMS.
@@ -237,45 +338,61 @@ func sendSMSHandler(c *gin.Context) {
 	if req.Device == "" {
 		req.Device = "quectel0"
 	}
-
-	// Get AMI config from DB for every request to ensure it's up to date
-	amiConfig, err := GetAMIConfigFromDB(db)
-	if err != nil {
-		log.Printf("Error getting AMI config from DB: %v", err)
-		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to get AMI configuration"})
+	if !currentPrincipal(c).CanAccessDevice(req.Device) {
+		recordAudit(c, AuditSMSSend, req.Recipient, false, "device not allowed: "+req.Device)
+		c.JSON(http.StatusForbidden, APIResponse{Success: false, Message: "Not allowed to send from device " + req.Device})
 		return
 	}
 
-	// Send the SMS via shell command
-	amiResponse, err := SendSMSShell(amiConfig, req.Device, req.Recipient, req.Message)
+	// Send the SMS via shell command，与 deliverSMS 一样不需要 AMI 配置
+	sendStart := time.Now()
+	amiResponse, err := SendSMSShell(nil, req.Device, req.Recipient, req.Message)
+	observeSMSSend(req.Device, sendStart, err)
 
 	smsStatus := "sent"
 	if err != nil {
 		smsStatus = "failed"
 		log.Printf("Error sending SMS via AMI: %v", err)
 		// Log the failed attempt before returning an error response
-		if logErr := insertSMSLog("outgoing", "unknown", req.Recipient, req.Message, smsStatus, req.Device); logErr != nil {
+		if logErr := insertSMSLog("outgoing", localNumber(req.Device), req.Recipient, req.Message, smsStatus, req.Device); logErr != nil {
 			log.Errorf("Failed to log outgoing SMS: %v", logErr)
 		}
+		recordAudit(c, AuditSMSSend, req.Recipient, false, fmt.Sprintf("device=%s error=%v", req.Device, err))
 		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to send SMS: " + err.Error()})
 		return
 	}
 
 	// Log the successful outgoing SMS
-	if logErr := insertSMSLog("outgoing", "unknown", req.Recipient, req.Message, smsStatus, req.Device); logErr != nil {
+	if logErr := insertSMSLog("outgoing", localNumber(req.Device), req.Recipient, req.Message, smsStatus, req.Device); logErr != nil {
 		log.Errorf("Failed to log outgoing SMS: %v", logErr)
 	}
+	recordAudit(c, AuditSMSSend, req.Recipient, true, "device="+req.Device)
 
 	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "短信发送成功: " + amiResponse})
 }
 
+// deliverSMS 通过设备发送一条短信并记入短信记录，供自动回复、保活等服务端发起的发送使用
+func deliverSMS(device, recipient, message string) error {
+	start := time.Now()
+	_, err := SendSMSShell(nil, device, recipient, message)
+	observeSMSSend(device, start, err)
+	status := "sent"
+	if err != nil {
+		status = "failed"
+	}
+	if logErr := insertSMSLog("outgoing", localNumber(device), recipient, message, status, device); logErr != nil {
+		log.Errorf("Failed to log outgoing SMS: %v", logErr)
+	}
+	return err
+}
+
 // CORSMiddleware 跨域中间件
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Auth-Secret")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-Auth-Secret, X-API-Token, X-Signature, X-Signature-Timestamp")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -295,7 +412,7 @@ func smsHandler(c *gin.Context) {
 	}
 
 	// 验证密钥（可选）
-	if err := validateSecret(smsReq.Secret); err != nil {
+	if err := checkIngestSecret(c, smsReq.Secret); err != nil {
 		c.JSON(http.StatusUnauthorized, APIResponse{Success: false, Message: "认证失败"})
 		return
 	}
@@ -313,9 +430,13 @@ func smsHandler(c *gin.Context) {
 	}
 
 	// Log the incoming SMS
-	if logErr := insertSMSLog("incoming", smsReq.Number, "unknown", smsReq.Text, "received", smsReq.PhoneID); logErr != nil {
+	if logErr := insertSMSLog("incoming", smsReq.Number, localNumber(smsReq.PhoneID), smsReq.Text, "received", smsReq.PhoneID); logErr != nil {
 		log.Errorf("Failed to log incoming SMS: %v", logErr)
 	}
+	balanceChecker.HandleReply(deviceName(smsReq.PhoneID), BalanceMethodSMS, smsReq.Number, smsReq.Text)
+	// 自动回复要经模块发送短信，在后台进行，避免推送方等待超时后重试
+	cfg := autoReplyConfig
+	runBackground(func() { autoReply(cfg, smsReq.PhoneID, smsReq.Number, smsReq.Text) })
 
 	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "短信接收并处理成功"})
 }
@@ -349,31 +470,14 @@ func processSMS(smsReq SMSReciveRequest) error {
 	loc, _ := time.LoadLocation("Asia/Shanghai")
 	formattedTime := parsedTime.In(loc).Format("2006-01-02 15:04:05")
 
-	// 遍历所有配置的转发规则
-	for name, cfg := range config {
-		c, ok := cfg.(map[string]interface{})
-		if !ok {
-			log.Warnf("配置格式错误: %s", name)
-			continue
-		}
-
-		rule, ok := c["rule"].(string)
-		if !ok {
-			log.Warnf("规则配置错误: %s", name)
-			continue
-		}
-
-		ruleType, ok := c["type"].(string)
-		if !ok {
-			log.Warnf("类型配置错误: %s", name)
-			continue
-		}
-
-		// 根据规则类型匹配
-		if shouldSendNotification(ruleType, rule, smsReq.Text) {
-			log.Infof("触发规则: %s, 类型: %s", name, ruleType)
-			sendNotification(c, smsReq.Number, formattedTime, smsReq.Text, rule, smsReq)
-		}
+	// 遍历命中的转发规则
+	ev := ForwardEvent{Kind: EventSMS, Number: smsReq.Number, Text: smsReq.Text, PhoneID: smsReq.PhoneID}
+	for _, r := range matchingRules(ev) {
+		log.Infof("触发规则: %s, 类型: %s", r.Name, r.RuleType)
+		r := r
+		notifyRule(r, ev.Kind, func() error {
+			return sendNotification(r.Settings, smsReq.Number, formattedTime, smsReq.Text, r.Rule, smsReq)
+		})
 	}
 
 	return nil
@@ -407,7 +511,7 @@ func callHandler(c *gin.Context) {
 	}
 
 	// 验证密钥（可选）
-	if err := validateSecret(callReq.Secret); err != nil {
+	if err := checkIngestSecret(c, callReq.Secret); err != nil {
 		c.JSON(http.StatusUnauthorized, APIResponse{Success: false, Message: "认证失败"})
 		return
 	}
@@ -421,11 +525,22 @@ func callHandler(c *gin.Context) {
 		"duration": callReq.Duration,
 	}).Info("收到call推送")
 
+	// 推送中没有名称时使用联系人中保存的名称
+	if callReq.Name == "" {
+		callReq.Name = contactName(callReq.Number)
+	}
+
 	// Process call for forwarding (if any)
 	if err := processCALL(callReq); err != nil {
 		log.Errorf("Failed to process call for forwarding: %v", err)
 	}
 
+	// 响铃推送只用于实时通知，通话记录以挂机后的推送为准
+	if strings.EqualFold(callReq.Type, "ringing") {
+		c.JSON(http.StatusOK, APIResponse{Success: true, Message: "call接收并处理成功"})
+		return
+	}
+
 	// Log the call
 	if logErr := insertCallLog(callReq.Type, callReq.Number, callReq.Name, callReq.Duration, callReq.Time, callReq.PhoneID, callReq.Source); logErr != nil {
 		log.Errorf("Failed to log call: %v", logErr)
@@ -454,48 +569,105 @@ func processCALL(callReq CallRequest) error {
 	loc, _ := time.LoadLocation("Asia/Shanghai")
 	callReq.Time = parsedTime.In(loc).Format("2006-01-02 15:04:05")
 
-	// 遍历所有配置的转发规则
-	for name, cfg := range config {
-		c, ok := cfg.(map[string]interface{})
-		if !ok {
-			log.Warnf("call配置格式错误: %s", name)
-			continue
-		}
+	kind := callEventKind(callReq.Type)
+	if kind == "" {
+		log.Infof("通话类型 %q 不触发转发规则", callReq.Type)
+		return nil
+	}
 
-		rule, ok := c["rule"].(string)
-		if !ok && rule != "all" {
-			log.Warnf("call规则配置错误: %s", name)
-			continue
-		}
+	// 遍历命中的转发规则
+	ev := ForwardEvent{
+		Kind:     kind,
+		Number:   callReq.Number,
+		Text:     callReq.Number,
+		CallType: callReq.Type,
+		Duration: callReq.Duration,
+		PhoneID:  callReq.PhoneID,
+	}
+	for _, r := range matchingRules(ev) {
+		log.Infof("触发call规则: %s, 事件: %s", r.Name, ev.Kind)
+		r := r
+		notifyRule(r, ev.Kind, func() error { return sendCallNotification(r.Settings, r.Rule, callReq) })
+	}
 
-		ruleType, ok := c["type"].(string)
-		if !ok && ruleType != "all" {
-			log.Warnf("call类型配置错误: %s", name)
-			continue
-		}
-		// 根据规则类型匹配
-		sendCallNotification(c, rule, callReq)
+	return nil
+}
+
+// ussdHandler 处理来自 asterisk 的 USSD 推送
+func ussdHandler(c *gin.Context) {
+	var ussdReq USSDRequest
+	if err := c.ShouldBindJSON(&ussdReq); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "无效的 JSON 数据: " + err.Error()})
+		return
+	}
+
+	// 验证密钥（可选）
+	if err := checkIngestSecret(c, ussdReq.Secret); err != nil {
+		c.JSON(http.StatusUnauthorized, APIResponse{Success: false, Message: "认证失败"})
+		return
+	}
+
+	text, err := base64.StdEncoding.DecodeString(ussdReq.TextBase64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "无效的 USSD 内容: " + err.Error()})
+		return
+	}
+	log.WithFields(log.Fields{
+		"device":   ussdReq.Device,
+		"time":     ussdReq.Time,
+		"phone_id": ussdReq.PhoneID,
+	}).Info("收到USSD推送")
+
+	if err := processUSSD(ussdReq, string(text)); err != nil {
+		log.Errorf("Failed to process USSD for forwarding: %v", err)
+	}
+	device := ussdReq.Device
+	if device == "" {
+		device = deviceName(ussdReq.PhoneID)
+	}
+	balanceChecker.HandleReply(device, BalanceMethodUSSD, "", string(text))
+
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "USSD接收并处理成功"})
+}
+
+func processUSSD(ussdReq USSDRequest, text string) error {
+	parsedTime, err := time.Parse(time.RFC3339, ussdReq.Time)
+	if err != nil {
+		parsedTime = time.Now()
 	}
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	formattedTime := parsedTime.In(loc).Format("2006-01-02 15:04:05")
 
+	ev := ForwardEvent{Kind: EventUSSD, Text: text, PhoneID: ussdReq.PhoneID}
+	for _, r := range matchingRules(ev) {
+		log.Infof("触发USSD规则: %s", r.Name)
+		r := r
+		notifyRule(r, ev.Kind, func() error { return sendUSSDNotification(r.Settings, formattedTime, text, ussdReq) })
+	}
 	return nil
 }
 
 func insertSMSLog(direction, fromNumber, toNumber, body, status, phoneID string) error {
-	query := `INSERT INTO sms_log (direction, from_number, to_number, body, status, phone_id) VALUES (?, ?, ?, ?, ?, ?)`
-	_, err := db.Exec(query, direction, fromNumber, toNumber, body, status, phoneID)
+	msg := SMSMessage{Direction: direction, FromNumber: fromNumber, ToNumber: toNumber, Body: body, Status: status, PhoneID: phoneID}
+	smsMessagesTotal.WithLabelValues(deviceName(phoneID), status).Inc()
+	id, err := store.SMS.Insert(msg)
 	if err != nil {
-		return fmt.Errorf("failed to insert SMS log: %w", err)
+		return err
 	}
 	log.Infof("SMS logged: Direction=%s, From=%s, To=%s, Status=%s", direction, fromNumber, toNumber, status)
+	msg.ID, msg.Device, msg.CreatedAt = int(id), devices.DeviceFor(phoneID), time.Now()
+	eventBus.Publish(StreamSMS, phoneID, msg)
 	return nil
 }
 
 func insertCallLog(callType, phoneNumber, contactName string, durationSeconds int, callTime, phoneID, source string) error {
-	query := `INSERT INTO call_log (call_type, phone_number, contact_name, duration_seconds, call_time, phone_id, source) VALUES (?, ?, ?, ?, ?, ?, ?)`
-	_, err := db.Exec(query, callType, phoneNumber, contactName, durationSeconds, callTime, phoneID, source)
+	call := CallRecord{CallType: callType, PhoneNumber: phoneNumber, ContactName: contactName, DurationSeconds: durationSeconds, CallTime: callTime, PhoneID: phoneID, Source: source}
+	id, err := store.Calls.Insert(call)
 	if err != nil {
-		return fmt.Errorf("failed to insert call log: %w", err)
+		return err
 	}
 	log.Infof("Call logged: Type=%s, Number=%s, Duration=%d", callType, phoneNumber, durationSeconds)
+	call.ID, call.CreatedAt = int(id), time.Now()
+	eventBus.Publish(StreamCall, phoneID, call)
 	return nil
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testSecret = "s3cret"
+
+// newTestRouter 在 SQLite 测试库上注册 API 路由，推送和发送使用 FORWARD_SECRET 认证
+func newTestRouter(t *testing.T) {
+	t.Helper()
+	newTestStore(t)
+	t.Setenv("FORWARD_SECRET", testSecret)
+	gin.SetMode(gin.TestMode)
+	router = gin.New()
+	setupRoutes()
+}
+
+// fakeAsterisk 把 PATH 中的 asterisk 换成记录参数的脚本，返回参数记录文件
+func fakeAsterisk(t *testing.T, exitCode int) string {
+	t.Helper()
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$*\" >> " + calls + "\necho queued\nexit " + strconv.Itoa(exitCode) + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "asterisk"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return calls
+}
+
+func doJSON(t *testing.T, method, path string, body interface{}, header map[string]string) (*httptest.ResponseRecorder, APIResponse) {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp APIResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s %s: invalid response %q", method, path, w.Body.String())
+	}
+	return w, resp
+}
+
+func TestReceiveSMSHandler(t *testing.T) {
+	newTestRouter(t)
+
+	sms := SMSReciveRequest{Secret: testSecret, Number: "13800000001", Time: "2026-10-19T10:00:00+0800", Text: "明天见", Source: "asterisk", PhoneID: "quectel0"}
+	w, resp := doJSON(t, "POST", "/api/v1/sms/receive", sms, nil)
+	if w.Code != http.StatusOK || !resp.Success {
+		t.Fatalf("receive = %d %+v, want 200", w.Code, resp)
+	}
+	msgs, err := store.SMS.Messages(MessageQuery{OtherParty: "13800000001", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Direction != "incoming" || msgs[0].Body != "明天见" || msgs[0].PhoneID != "quectel0" {
+		t.Fatalf("messages after receive = %+v, want one incoming 明天见 on quectel0", msgs)
+	}
+
+	sms.Secret = "wrong"
+	w, _ = doJSON(t, "POST", "/api/v1/sms/receive", sms, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("receive with wrong secret = %d, want 401", w.Code)
+	}
+	if msgs, _ := store.SMS.Messages(MessageQuery{OtherParty: "13800000001", Limit: 10}); len(msgs) != 1 {
+		t.Errorf("rejected push was logged, got %d messages", len(msgs))
+	}
+}
+
+func TestSendSMSHandler(t *testing.T) {
+	newTestRouter(t)
+	calls := fakeAsterisk(t, 0)
+	auth := map[string]string{"X-Auth-Secret": testSecret}
+
+	w, resp := doJSON(t, "POST", "/api/v1/sms/send", SMSSendRequest{Device: "quectel0", Recipient: "13800000002", Message: "你好"}, auth)
+	if w.Code != http.StatusOK || !resp.Success {
+		t.Fatalf("send = %d %+v, want 200", w.Code, resp)
+	}
+	out, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("asterisk was not called: %v", err)
+	}
+	if !strings.Contains(string(out), `quectel sms "quectel0" "13800000002" "你好"`) {
+		t.Errorf("asterisk called with %q", out)
+	}
+	msgs, err := store.SMS.Messages(MessageQuery{OtherParty: "13800000002", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Direction != "outgoing" || msgs[0].Status != "sent" {
+		t.Fatalf("messages after send = %+v, want one sent outgoing message", msgs)
+	}
+
+	w, _ = doJSON(t, "POST", "/api/v1/sms/send", SMSSendRequest{Recipient: "13800000002"}, auth)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("send without message = %d, want 400", w.Code)
+	}
+	w, _ = doJSON(t, "POST", "/api/v1/sms/send", SMSSendRequest{Recipient: "13800000002", Message: "hi"}, nil)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("send without credentials = %d, want 401", w.Code)
+	}
+}
+
+func TestSendSMSHandlerLogsFailure(t *testing.T) {
+	newTestRouter(t)
+	fakeAsterisk(t, 1)
+
+	w, resp := doJSON(t, "POST", "/api/v1/sms/send", SMSSendRequest{Device: "quectel0", Recipient: "13800000003", Message: "hi"}, map[string]string{"X-Auth-Secret": testSecret})
+	if w.Code != http.StatusInternalServerError || resp.Success {
+		t.Fatalf("send with failing asterisk = %d %+v, want 500", w.Code, resp)
+	}
+	msgs, err := store.SMS.Messages(MessageQuery{OtherParty: "13800000003", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Status != "failed" {
+		t.Fatalf("messages after failed send = %+v, want one failed message", msgs)
+	}
+}
+
+func TestCallEventKind(t *testing.T) {
+	tests := map[string]string{
+		"ringing":     EventIncomingCall,
+		"incoming":    EventIncomingCall,
+		"answered":    EventAnsweredCall,
+		"missed":      EventMissedCall,
+		"Missed_Call": EventMissedCall,
+		"outgoing":    "",
+		"rejected":    "",
+		"":            "",
+	}
+	for callType, want := range tests {
+		if got := callEventKind(callType); got != want {
+			t.Errorf("callEventKind(%q) = %q, want %q", callType, got, want)
+		}
+	}
+
+	// 未声明 events 的 type: all 规则收到响铃和未接事件，不重复收到已接听的挂机事件
+	r, err := parseForwardRule("all", map[string]interface{}{"type": "all", "rule": "all"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Events[EventIncomingCall] || !r.Events[EventMissedCall] || r.Events[EventAnsweredCall] {
+		t.Errorf("default events of a type: all rule = %v", r.Events)
+	}
+}
+
+func TestCallHandlerLogsOnlyHangupPush(t *testing.T) {
+	newTestRouter(t)
+	call := CallRequest{Secret: testSecret, Number: "13800000004", Time: "2026-10-19T10:00:00+0800", Source: "asterisk", PhoneID: "quectel0"}
+	for _, push := range []struct {
+		callType string
+		duration int
+	}{{"ringing", 0}, {"answered", 42}} {
+		call.Type, call.Duration = push.callType, push.duration
+		if w, resp := doJSON(t, "POST", "/api/v1/call/receive", call, nil); w.Code != http.StatusOK || !resp.Success {
+			t.Fatalf("%s push = %d %+v, want 200", push.callType, w.Code, resp)
+		}
+	}
+	var n, duration int
+	var callType string
+	if err := db.QueryRow("SELECT COUNT(*), MAX(call_type), MAX(duration_seconds) FROM call_log").Scan(&n, &callType, &duration); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || callType != "answered" || duration != 42 {
+		t.Errorf("call_log has %d rows (type %s, duration %d), want only the answered call with 42s", n, callType, duration)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// importBatchSize 导入时每个事务写入的记录数
+const importBatchSize = 500
+
+// ImportResult 是一次导入的统计
+type ImportResult struct {
+	Messages   int `json:"messages"`   // 新写入的短信
+	Calls      int `json:"calls"`      // 新写入的通话记录
+	Duplicates int `json:"duplicates"` // 已存在而跳过的记录
+	Ignored    int `json:"ignored"`    // 彩信、草稿等不支持导入的记录
+	Contacts   int `json:"contacts"`   // 新增的联系人
+}
+
+// historyImporter 把备份中的记录转换为本服务的短信和通话记录，按批写入
+type historyImporter struct {
+	device string // 导入记录所属的设备（phone_id）
+	local  string // 设备的本机号码
+	result ImportResult
+	sms    []SMSMessage
+	calls  []CallRecord
+	names  map[string]string
+}
+
+func (im *historyImporter) addSMS(b BackupSMS) error {
+	address := strings.TrimSpace(b.Address)
+	if address == "" {
+		im.result.Ignored++
+		return nil
+	}
+	created := backupTime(b.Date)
+	msg := SMSMessage{Body: b.Body, PhoneID: im.device, CreatedAt: created}
+	switch b.Type {
+	case backupSMSReceived:
+		msg.Direction, msg.Status, msg.FromNumber, msg.ToNumber = "incoming", "received", address, im.local
+		if b.Read == 1 {
+			msg.ReadAt = &created
+		}
+	case backupSMSSent, backupSMSFailed:
+		msg.Direction, msg.Status, msg.FromNumber, msg.ToNumber = "outgoing", "sent", im.local, address
+		if b.Type == backupSMSFailed {
+			msg.Status = "failed"
+		}
+		msg.ReadAt = &created
+	default:
+		im.result.Ignored++
+		return nil
+	}
+	im.addName(address, b.ContactName)
+	im.sms = append(im.sms, msg)
+	if len(im.sms) >= importBatchSize {
+		return im.flushSMS()
+	}
+	return nil
+}
+
+func (im *historyImporter) addCall(b BackupCall) error {
+	callType, ok := backupCallTypes[b.Type]
+	if !ok || strings.TrimSpace(b.Number) == "" {
+		im.result.Ignored++
+		return nil
+	}
+	created := backupTime(b.Date)
+	contact := b.ContactName
+	if contact == "(Unknown)" {
+		contact = ""
+	}
+	im.addName(b.Number, b.ContactName)
+	im.calls = append(im.calls, CallRecord{
+		CallType:        callType,
+		PhoneNumber:     strings.TrimSpace(b.Number),
+		ContactName:     contact,
+		DurationSeconds: b.Duration,
+		CallTime:        created.Format("2006-01-02T15:04:05Z07:00"),
+		PhoneID:         im.device,
+		Source:          "import",
+		CreatedAt:       created,
+	})
+	if len(im.calls) >= importBatchSize {
+		return im.flushCalls()
+	}
+	return nil
+}
+
+// addName 记录备份中的联系人名称，导入结束后只补充尚不存在的联系人
+func (im *historyImporter) addName(number, name string) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == "(Unknown)" || len(name) > 100 {
+		return
+	}
+	im.names[strings.TrimSpace(number)] = name
+}
+
+func (im *historyImporter) flushSMS() error {
+	if len(im.sms) == 0 {
+		return nil
+	}
+	n, err := store.SMS.Import(im.sms)
+	if err != nil {
+		return err
+	}
+	im.result.Messages += n
+	im.result.Duplicates += len(im.sms) - n
+	im.sms = im.sms[:0]
+	return nil
+}
+
+func (im *historyImporter) flushCalls() error {
+	if len(im.calls) == 0 {
+		return nil
+	}
+	n, err := store.Calls.Import(im.calls)
+	if err != nil {
+		return err
+	}
+	im.result.Calls += n
+	im.result.Duplicates += len(im.calls) - n
+	im.calls = im.calls[:0]
+	return nil
+}
+
+func (im *historyImporter) flushContacts() error {
+	numbers := make([]string, 0, len(im.names))
+	for number := range im.names {
+		numbers = append(numbers, number)
+	}
+	existing, err := store.Contacts.Names(numbers)
+	if err != nil {
+		return err
+	}
+	for number, name := range im.names {
+		if _, ok := existing[number]; ok {
+			continue
+		}
+		if err := store.Contacts.Upsert(Contact{Number: number, Name: name}); err != nil {
+			return err
+		}
+		im.result.Contacts++
+	}
+	return nil
+}
+
+// importBackup 流式解析 SMS Backup & Restore 的 XML（<smses> 或 <calls>），逐批写入
+func (im *historyImporter) importBackup(r io.Reader) error {
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid backup file: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "smses", "calls":
+			// 根元素，继续读取子元素
+		case "sms":
+			var b BackupSMS
+			if err := dec.DecodeElement(&b, &start); err != nil {
+				return fmt.Errorf("invalid sms element: %w", err)
+			}
+			if err := im.addSMS(b); err != nil {
+				return err
+			}
+		case "call":
+			var b BackupCall
+			if err := dec.DecodeElement(&b, &start); err != nil {
+				return fmt.Errorf("invalid call element: %w", err)
+			}
+			if err := im.addCall(b); err != nil {
+				return err
+			}
+		default:
+			// 彩信等暂不支持，整体跳过
+			im.result.Ignored++
+			if err := dec.Skip(); err != nil {
+				return fmt.Errorf("invalid backup file: %w", err)
+			}
+		}
+	}
+	if err := im.flushSMS(); err != nil {
+		return err
+	}
+	if err := im.flushCalls(); err != nil {
+		return err
+	}
+	return im.flushContacts()
+}
+
+// importBackupHandler 导入 SMS Backup & Restore 导出的短信或通话记录 XML。
+// 文件以 multipart 的 file 字段或直接作为请求体上传；device 参数指定记录归属的设备，决定本机号码。
+func importBackupHandler(c *gin.Context) {
+	device := c.Query("device")
+	if device == "" {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Missing device parameter"})
+		return
+	}
+	local := c.Query("local")
+	if local == "" {
+		local = localNumber(device)
+	}
+
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Missing file: " + err.Error()})
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Failed to read file: " + err.Error()})
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	im := &historyImporter{device: device, local: local, names: map[string]string{}}
+	err := im.importBackup(body)
+	detail := fmt.Sprintf("device=%s messages=%d calls=%d duplicates=%d ignored=%d", device, im.result.Messages, im.result.Calls, im.result.Duplicates, im.result.Ignored)
+	if err != nil {
+		log.Errorf("Import failed: %v", err)
+		recordAudit(c, AuditHistoryImport, "sms-backup", false, detail+" error="+err.Error())
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Import failed: " + err.Error(), Data: im.result})
+		return
+	}
+	log.Infof("Imported history: %s", detail)
+	recordAudit(c, AuditHistoryImport, "sms-backup", true, detail)
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: im.result})
+}
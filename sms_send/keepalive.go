@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultKeepAliveCheckHours = 6
+	keepAliveStartupDelay      = 5 * time.Minute
+)
+
+// SIMKeepAliveConfig 是 keepalive.sims 中单张 SIM 卡的保活策略
+type SIMKeepAliveConfig struct {
+	Device    string `json:"device"`
+	EveryDays int    `json:"every_days"`
+	USSD      string `json:"ussd,omitempty"`
+	SMSTo     string `json:"sms_to,omitempty"`
+	SMSText   string `json:"sms_text,omitempty"`
+}
+
+// Method 返回保活方式
+func (s *SIMKeepAliveConfig) Method() string {
+	if s.USSD != "" {
+		return BalanceMethodUSSD
+	}
+	return BalanceMethodSMS
+}
+
+// Target 返回 USSD 代码或短信接收号码
+func (s *SIMKeepAliveConfig) Target() string {
+	if s.USSD != "" {
+		return s.USSD
+	}
+	return s.SMSTo
+}
+
+// KeepAliveConfig 是 forward.yaml 中的 keepalive 段
+//
+//	keepalive:
+//	  check_hours: 6
+//	  sims:
+//	    quectel0:
+//	      every_days: 60       # 超过 60 天没有发送成功的短信时保活一次
+//	      sms_to: "10086"
+//	      sms_text: "CXLL"     # 或 ussd: "*100#"
+type KeepAliveConfig struct {
+	CheckHours int
+	SIMs       map[string]*SIMKeepAliveConfig
+}
+
+var keepAliveConfig = &KeepAliveConfig{CheckHours: defaultKeepAliveCheckHours, SIMs: map[string]*SIMKeepAliveConfig{}}
+
+// loadKeepAliveConfig 解析 forward.yaml 中的 keepalive 段，配置错误的 SIM 卡会被跳过
+func loadKeepAliveConfig(v interface{}) *KeepAliveConfig {
+	cfg := &KeepAliveConfig{CheckHours: defaultKeepAliveCheckHours, SIMs: map[string]*SIMKeepAliveConfig{}}
+	section, ok := v.(map[string]interface{})
+	if !ok {
+		return cfg
+	}
+	if n := configInt(section["check_hours"]); n > 0 {
+		cfg.CheckHours = n
+	}
+	sims, _ := section["sims"].(map[string]interface{})
+	for device, raw := range sims {
+		settings, ok := raw.(map[string]interface{})
+		if !ok {
+			log.Warnf("保活配置格式错误: %s", device)
+			continue
+		}
+		str := func(key string) string {
+			if settings[key] == nil {
+				return ""
+			}
+			return strings.TrimSpace(fmt.Sprint(settings[key]))
+		}
+		sim := &SIMKeepAliveConfig{Device: device, EveryDays: configInt(settings["every_days"]), USSD: str("ussd"), SMSTo: str("sms_to"), SMSText: str("sms_text")}
+		if sim.EveryDays <= 0 || (sim.USSD == "" && (sim.SMSTo == "" || sim.SMSText == "")) {
+			log.Warnf("保活配置错误: %s: every_days and either ussd or sms_to and sms_text are required", device)
+			continue
+		}
+		cfg.SIMs[device] = sim
+	}
+	return cfg
+}
+
+// KeepAliveStatus 是设备页面展示的保活状态
+type KeepAliveStatus struct {
+	*SIMKeepAliveConfig
+	Method string `json:"method"`
+	// LastActivity 是最近一次发送成功的短信或保活的时间，从未发送过时为空
+	LastActivity *time.Time       `json:"last_activity"`
+	NextDue      time.Time        `json:"next_due"`
+	Last         *KeepAliveRecord `json:"last,omitempty"`
+}
+
+// lastActivity 返回设备最近一次发送成功的短信（含保活短信）或保活 USSD 的时间
+func lastActivity(device string) (time.Time, bool, error) {
+	last, ok, err := store.SMS.LastSent(devices.Aliases(device), "")
+	if err != nil {
+		return last, false, err
+	}
+	kept, keptOK, err := store.KeepAlive.LastSent(device)
+	if err != nil {
+		return last, false, err
+	}
+	if keptOK && (!ok || kept.After(last)) {
+		return kept, true, nil
+	}
+	return last, ok, nil
+}
+
+func keepAliveStatus(sim *SIMKeepAliveConfig, latest map[string]KeepAliveRecord) (KeepAliveStatus, error) {
+	st := KeepAliveStatus{SIMKeepAliveConfig: sim, Method: sim.Method(), NextDue: time.Now()}
+	last, ok, err := lastActivity(sim.Device)
+	if err != nil {
+		return st, err
+	}
+	if ok {
+		st.LastActivity = &last
+		st.NextDue = last.AddDate(0, 0, sim.EveryDays)
+	}
+	if rec, ok := latest[sim.Device]; ok {
+		st.Last = &rec
+	}
+	return st, nil
+}
+
+// sendKeepAlive 发送一次保活短信或 USSD 并记录结果，保活短信同时写入短信记录
+func sendKeepAlive(sim *SIMKeepAliveConfig) (KeepAliveRecord, error) {
+	rec := KeepAliveRecord{Device: sim.Device, Method: sim.Method(), Target: sim.Target(), Status: "sent"}
+	var err error
+	if sim.Method() == BalanceMethodUSSD {
+		_, err = SendUSSDShell(sim.Device, sim.USSD)
+	} else {
+		err = deliverSMS(sim.Device, sim.SMSTo, sim.SMSText)
+	}
+	if err != nil {
+		rec.Status, rec.Detail = "failed", err.Error()
+	}
+	rec.CreatedAt = time.Now()
+	id, dbErr := store.KeepAlive.Insert(rec)
+	if dbErr != nil {
+		log.Errorf("Failed to record keep-alive: %v", dbErr)
+	}
+	rec.ID = int(id)
+	return rec, err
+}
+
+// checkKeepAlives 为超过 every_days 天没有发送记录的 SIM 卡保活，从未发送过的 SIM 卡立即保活
+func checkKeepAlives(cfg *KeepAliveConfig) {
+	names := make([]string, 0, len(cfg.SIMs))
+	for device := range cfg.SIMs {
+		names = append(names, device)
+	}
+	sort.Strings(names)
+	for _, device := range names {
+		sim := cfg.SIMs[device]
+		last, ok, err := lastActivity(device)
+		if err != nil {
+			log.Errorf("Keep-alive check of %s failed: %v", device, err)
+			continue
+		}
+		if ok && time.Since(last) < time.Duration(sim.EveryDays)*24*time.Hour {
+			continue
+		}
+		if _, err := sendKeepAlive(sim); err != nil {
+			log.Warnf("Keep-alive on %s failed: %v", device, err)
+			continue
+		}
+		log.Infof("Keep-alive sent on %s via %s, last activity %v", device, sim.Method(), last)
+	}
+}
+
+// startKeepAlive 每 check_hours 小时检查一次需要保活的 SIM 卡
+func startKeepAlive() {
+	cfg := keepAliveConfig
+	if len(cfg.SIMs) == 0 {
+		return
+	}
+	log.Infof("Keep-alive enabled for %d SIMs, checking every %d hours", len(cfg.SIMs), cfg.CheckHours)
+	runBackground(func() {
+		if !sleepContext(keepAliveStartupDelay) {
+			return
+		}
+		for {
+			checkKeepAlives(cfg)
+			if !sleepContext(time.Duration(cfg.CheckHours) * time.Hour) {
+				return
+			}
+		}
+	})
+}
+
+// getKeepAliveHandler 返回当前用户可访问设备的保活策略和状态
+func getKeepAliveHandler(c *gin.Context) {
+	p := currentPrincipal(c)
+	records, err := store.KeepAlive.Latest()
+	if err != nil {
+		log.Errorf("Error querying keep-alive: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to query keep-alive"})
+		return
+	}
+	latest := map[string]KeepAliveRecord{}
+	for _, rec := range records {
+		latest[rec.Device] = rec
+	}
+	list := []KeepAliveStatus{}
+	for _, sim := range keepAliveConfig.SIMs {
+		if !p.CanAccessDevice(sim.Device) {
+			continue
+		}
+		st, err := keepAliveStatus(sim, latest)
+		if err != nil {
+			log.Errorf("Error querying keep-alive: %v", err)
+			c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to query keep-alive"})
+			return
+		}
+		list = append(list, st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Device < list[j].Device })
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: list, Total: len(list)})
+}
+
+// runKeepAliveHandler 立即对设备保活一次
+func runKeepAliveHandler(c *gin.Context) {
+	device := c.Param("device")
+	sim := keepAliveConfig.SIMs[device]
+	if sim == nil {
+		c.JSON(http.StatusNotFound, APIResponse{Success: false, Message: "No keep-alive configured for device " + device})
+		return
+	}
+	rec, err := sendKeepAlive(sim)
+	detail := fmt.Sprintf("method=%s target=%s", rec.Method, rec.Target)
+	if err != nil {
+		recordAudit(c, AuditKeepAlive, device, false, detail+" error="+err.Error())
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Keep-alive failed: " + err.Error(), Data: rec})
+		return
+	}
+	recordAudit(c, AuditKeepAlive, device, true, detail)
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: rec})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// appCtx 在收到停止信号时取消，后台任务和 SSE 长连接据此退出
+	appCtx, stopApp = context.WithCancel(context.Background())
+	backgroundJobs  sync.WaitGroup
+)
+
+// runBackground 在后台运行周期任务，停机时等待其退出
+func runBackground(fn func()) {
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		fn()
+	}()
+}
+
+// sleepContext 等待 d，期间收到停止信号时返回 false
+func sleepContext(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-appCtx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// shutdownTimeout 是停机时等待进行中请求和后台任务的最长时间，SHUTDOWN_TIMEOUT 单位为秒
+func shutdownTimeout() time.Duration {
+	if sec, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	return 25 * time.Second
+}
+
+func startHTTPServer() {
+	httpPort := os.Getenv("SMS_SEND_PORT")
+	if httpPort == "" {
+		httpPort = "1285"
+	}
+	srv := &http.Server{Addr: ":" + httpPort, Handler: router, ReadHeaderTimeout: 10 * time.Second}
+	errCh := make(chan error, 1)
+	go func() {
+		log.Infof("HTTP 服务启动，监听端口 %s", httpPort)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+	}()
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err := <-errCh:
+		log.Fatalf("HTTP 服务启动失败: %v", err)
+	case <-sigCtx.Done():
+	}
+	stop()
+	shutdown(srv)
+}
+
+// shutdown 依次停止接收新请求、等待进行中的请求（发送短信、转发通知、写库）完成、
+// 等待后台任务退出并登出 AMI，最后关闭数据库。超过 shutdownTimeout 时不再等待。
+func shutdown(srv *http.Server) {
+	timeout := shutdownTimeout()
+	log.Infof("收到停止信号，最多等待 %s 完成进行中的请求", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stopApp()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warnf("HTTP server did not drain in time: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		backgroundJobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn("Background jobs did not stop in time")
+	}
+
+	if err := db.Close(); err != nil {
+		log.Errorf("Failed to close database: %v", err)
+	}
+	log.Info("短信转发服务已停止")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// 批量操作
+const (
+	BulkRead      = "read"
+	BulkUnread    = "unread"
+	BulkArchive   = "archive"
+	BulkUnarchive = "unarchive"
+	BulkDelete    = "delete"  // 移入回收站
+	BulkRestore   = "restore" // 从回收站恢复
+	BulkPurge     = "purge"   // 彻底删除回收站中的消息
+)
+
+// bulkActionScope 返回执行批量操作所需的权限：已读状态只需读权限，归档/删除需要发送权限，彻底删除仅限管理员
+func bulkActionScope(action string) string {
+	switch action {
+	case BulkRead, BulkUnread:
+		return ScopeSMSRead
+	case BulkArchive, BulkUnarchive, BulkDelete, BulkRestore:
+		return ScopeSMSSend
+	case BulkPurge:
+		return ScopeAdmin
+	}
+	return ""
+}
+
+// bulkAuditAction 返回需要记录审计日志的批量操作对应的审计动作，已读状态和归档不记录
+func bulkAuditAction(action string) string {
+	switch action {
+	case BulkDelete:
+		return AuditSMSDelete
+	case BulkRestore:
+		return AuditSMSRestore
+	case BulkPurge:
+		return AuditSMSPurge
+	}
+	return ""
+}
+
+// ConversationKey 标识一个会话：本机 SIM 号码 + 对方号码
+type ConversationKey struct {
+	LocalNumber string `json:"local_number"`
+	OtherParty  string `json:"other_party"`
+}
+
+// ConversationBulkRequest 会话批量操作请求
+type ConversationBulkRequest struct {
+	Action        string            `json:"action"`
+	Conversations []ConversationKey `json:"conversations"`
+}
+
+// MessageBulkRequest 消息批量操作请求
+type MessageBulkRequest struct {
+	Action string `json:"action"`
+	IDs    []int  `json:"ids"`
+}
+
+// requireBulkScope 检查当前主体是否有权执行批量操作，无权限时直接写入响应
+func requireBulkScope(c *gin.Context, action string) bool {
+	scope := bulkActionScope(action)
+	if scope == "" {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Unknown action: " + action})
+		return false
+	}
+	if !currentPrincipal(c).HasScope(scope) {
+		c.JSON(http.StatusForbidden, APIResponse{Success: false, Message: "Insufficient permissions for action " + action})
+		return false
+	}
+	return true
+}
+
+// conversationBulkHandler 对多个会话执行已读/未读、归档/取消归档、删除/恢复
+func conversationBulkHandler(c *gin.Context) {
+	var req ConversationBulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid request: " + err.Error()})
+		return
+	}
+	if req.Action == BulkPurge {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Purge is only supported for messages in the trash"})
+		return
+	}
+	if !requireBulkScope(c, req.Action) {
+		return
+	}
+	if len(req.Conversations) == 0 {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "No conversations given"})
+		return
+	}
+
+	scope := SMSScope{PhoneIDs: allowedPhoneIDs(currentPrincipal(c))}
+	var affected int64
+	for _, key := range req.Conversations {
+		n, err := store.SMS.UpdateConversation(scope, req.Action, key)
+		if err != nil {
+			log.Errorf("Bulk %s failed for %s/%s: %v", req.Action, key.LocalNumber, key.OtherParty, err)
+			c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to update conversations"})
+			return
+		}
+		affected += n
+	}
+	if action := bulkAuditAction(req.Action); action != "" {
+		recordAudit(c, action, fmt.Sprintf("%d conversations", len(req.Conversations)), true, fmt.Sprintf("messages=%d", affected))
+	}
+	publishSMSStatus(req.Action, affected)
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: fmt.Sprintf("%d messages updated", affected), Total: int(affected)})
+}
+
+// messageBulkHandler 对指定 ID 的消息执行已读/未读、删除/恢复以及彻底删除
+func messageBulkHandler(c *gin.Context) {
+	var req MessageBulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid request: " + err.Error()})
+		return
+	}
+	if req.Action == BulkArchive || req.Action == BulkUnarchive {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Archiving applies to conversations, not messages"})
+		return
+	}
+	if !requireBulkScope(c, req.Action) {
+		return
+	}
+	if len(req.IDs) == 0 {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "No message ids given"})
+		return
+	}
+
+	scope := SMSScope{PhoneIDs: allowedPhoneIDs(currentPrincipal(c))}
+	affected, err := store.SMS.UpdateMessages(scope, req.Action, req.IDs)
+	if err != nil {
+		log.Errorf("Bulk %s failed for messages %v: %v", req.Action, req.IDs, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to update messages"})
+		return
+	}
+	if action := bulkAuditAction(req.Action); action != "" {
+		recordAudit(c, action, fmt.Sprintf("%d messages", len(req.IDs)), true, fmt.Sprintf("affected=%d", affected))
+	}
+	publishSMSStatus(req.Action, affected)
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: fmt.Sprintf("%d messages updated", affected), Total: int(affected)})
+}
+
+// markConversationReadHandler 打开会话时把其中的来信标记为已读，支持 local/device 参数限定 SIM
+func markConversationReadHandler(c *gin.Context) {
+	number := c.Param("number")
+	affected, err := store.SMS.MarkConversationRead(smsScope(c), number)
+	if err != nil {
+		log.Errorf("Error marking conversation %s as read: %v", number, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to mark conversation as read"})
+		return
+	}
+	publishSMSStatus(BulkRead, affected)
+	c.JSON(http.StatusOK, APIResponse{Success: true, Total: int(affected)})
+}
+
+// getTrashHandler 分页列出回收站中的消息
+func getTrashHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	scope := SMSScope{PhoneIDs: allowedPhoneIDs(currentPrincipal(c))}
+	messages, total, err := store.SMS.Trash(scope, limit, (page-1)*limit)
+	if err != nil {
+		log.Errorf("Error querying trash: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to query trash"})
+		return
+	}
+	for i := range messages {
+		messages[i].Device = devices.DeviceFor(messages[i].PhoneID)
+	}
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: messages, Total: total})
+}
+
+// emptyTrashHandler 彻底删除回收站中当前用户可见的所有消息
+func emptyTrashHandler(c *gin.Context) {
+	affected, err := store.SMS.EmptyTrash(SMSScope{PhoneIDs: allowedPhoneIDs(currentPrincipal(c))})
+	if err != nil {
+		log.Errorf("Error emptying trash: %v", err)
+		recordAudit(c, AuditSMSPurge, "trash", false, err.Error())
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to empty trash"})
+		return
+	}
+	recordAudit(c, AuditSMSPurge, "trash", true, fmt.Sprintf("affected=%d", affected))
+	publishSMSStatus(BulkPurge, affected)
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: fmt.Sprintf("%d messages deleted", affected), Total: int(affected)})
+}
+
+// publishSMSStatus 通知实时客户端消息状态有变化。事件不带号码，避免泄露给无权访问该设备的用户。
+func publishSMSStatus(action string, affected int64) {
+	if affected > 0 {
+		eventBus.Publish(StreamSMSStatus, "", gin.H{"action": action})
+	}
+}
@@ -3,16 +3,15 @@ package main
 import (
 	"database/sql"
 	"embed"
-	"fmt"
+	"encoding/json"
+	"flag"
 	"github.com/gin-gonic/gin"
-	_ "github.com/go-sql-driver/mysql"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"html/template"
 	"io/fs"
 	"net/http"
 	"os"
-	"time"
 )
 
 var (
@@ -26,7 +25,11 @@ var (
 //go:embed all:web
 var staticFiles embed.FS
 
+var migrateFlag = flag.String("migrate", "", "run database migrations and exit: status | up")
+var backfillFlag = flag.String("backfill", "", "backfill missing SMS from sms.txt and smsdb and exit: dry-run | run")
+
 func main() {
+	flag.Parse()
 	log.SetFormatter(&log.JSONFormatter{})
 	log.Info("启动短信转发服务...")
 	// 读取配置文件
@@ -38,33 +41,62 @@ func main() {
 	Debug = os.Getenv("DEBUG") == "true"
 
 	// --- Database Connection ---
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", dbConfig.Username, dbConfig.Password, dbConfig.Host, dbConfig.Port, dbConfig.DBName)
-
-	// Retry connecting to the database on startup
-	for i := 0; i < 10; i++ {
-		db, err = sql.Open("mysql", dsn)
-		if err == nil {
-			err = db.Ping()
-			if err == nil {
-				log.Println("Successfully connected to the database.")
-				break
-			}
+	store, err = openStore(dbConfig)
+	if err != nil {
+		log.Fatalf("Could not open the database: %v", err)
+	}
+	// auth、tokens、audit 等管理表仍直接使用 db，SQL 在两个后端通用
+	db = store.DB
+
+	// 执行数据库结构迁移；-migrate 参数只查看状态或执行迁移，不启动服务
+	switch *migrateFlag {
+	case "":
+	case "status":
+		if err := printMigrationStatus(os.Stdout); err != nil {
+			log.Fatalf("Failed to read migration status: %v", err)
 		}
-		log.Printf("Failed to connect to database, retrying in 5 seconds... (Attempt %d/10)", i+1)
-		time.Sleep(5 * time.Second)
+		return
+	case "up":
+		if err := runMigrations(); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+		return
+	default:
+		log.Fatalf("Unknown -migrate command %q, expected status or up", *migrateFlag)
 	}
-	if err != nil {
-		log.Fatalf("Could not connect to the database after several retries: %v", err)
+	if err := runMigrations(); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
 	}
-	// defer db.Close() // Keep DB connection open for the lifetime of the app
-
-	// Create database tables if they don't exist
-	if err := createSMSTable(); err != nil {
-		log.Fatalf("Failed to create sms_log table: %v", err)
+	store.Dialect.AfterMigrate()
+	if err := store.SMS.EnsureConversations(); err != nil {
+		log.Fatalf("Failed to build conversation summaries: %v", err)
+	}
+	// -backfill 参数补录一次后退出，不启动服务
+	switch *backfillFlag {
+	case "":
+	case "dry-run", "run":
+		report, err := runBackfill(backfillConfig, *backfillFlag == "dry-run")
+		if err != nil {
+			log.Fatalf("Backfill failed: %v", err)
+		}
+		recordBackfillAudit(report)
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+		return
+	default:
+		log.Fatalf("Unknown -backfill command %q, expected dry-run or run", *backfillFlag)
 	}
-	if err := createCallLogTable(); err != nil {
-		log.Fatalf("Failed to create call_log table: %v", err)
+	if err := bootstrapAdmin(); err != nil {
+		log.Fatalf("Failed to create initial admin user: %v", err)
 	}
+	startAuditRetention()
+	startRetention()
+	startDeviceMonitor()
+	startBackfill()
+	startBalanceChecks()
+	startKeepAlive()
+	startTelegramReplies()
 
 	// 初始化 Gin
 	initGin()
@@ -73,47 +105,6 @@ func main() {
 	startHTTPServer()
 }
 
-func createSMSTable() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS sms_log (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		direction VARCHAR(10) NOT NULL, -- 'incoming' or 'outgoing'
-		from_number VARCHAR(50) NOT NULL,
-		to_number VARCHAR(50) NOT NULL,
-		body TEXT NOT NULL,
-		status VARCHAR(20) NOT NULL, -- 'received', 'sent', 'failed'
-		phone_id VARCHAR(50),
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-	_, err := db.Exec(query)
-	if err != nil {
-		return fmt.Errorf("error creating sms_log table: %w", err)
-	}
-	log.Println("sms_log table verified/created successfully.")
-	return nil
-}
-
-func createCallLogTable() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS call_log (
-		id INT AUTO_INCREMENT PRIMARY KEY,
-		call_type VARCHAR(20) NOT NULL, -- 'incoming', 'outgoing', 'missed', etc.
-		phone_number VARCHAR(50) NOT NULL,
-		contact_name VARCHAR(100),
-		duration_seconds INT,
-		call_time VARCHAR(50),
-		phone_id VARCHAR(50),
-		source VARCHAR(50),
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-	_, err := db.Exec(query)
-	if err != nil {
-		return fmt.Errorf("error creating call_log table: %w", err)
-	}
-	log.Println("call_log table verified/created successfully.")
-	return nil
-}
-
 func initGin() {
 	// 设置 Gin 模式
 	gin.SetMode(gin.ReleaseMode)
@@ -147,18 +138,10 @@ func initGin() {
 	router.GET("/login", func(c *gin.Context) {
 		c.HTML(http.StatusOK, "login.html", nil)
 	})
+	router.GET("/metrics", metricsHandler())
+	router.GET("/healthz", healthzHandler)
+	router.GET("/readyz", readyzHandler)
 
 	// 设置路由
 	setupRoutes()
 }
-
-func startHTTPServer() {
-	httpPort := os.Getenv("SMS_SEND_PORT")
-	if httpPort == "" {
-		httpPort = "1285"
-	}
-	log.Infof("HTTP 服务启动，监听端口 %s", httpPort)
-	if err := router.Run(":" + httpPort); err != nil {
-		log.Fatalf("HTTP 服务启动失败: %v", err)
-	}
-}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry 只包含本服务的指标和 Go 运行时、进程指标
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	smsMessagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_gateway_sms_total",
+		Help: "SMS received, sent and failed to send, by device.",
+	}, []string{"device", "status"})
+
+	smsSendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sms_gateway_sms_send_duration_seconds",
+		Help:    "Time taken to hand an outgoing SMS to the modem through AMI.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 20, 30},
+	}, []string{"device", "result"})
+
+	notificationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_gateway_notifications_total",
+		Help: "Notifications attempted, by provider, forwarding rule and event.",
+	}, []string{"provider", "rule", "event"})
+
+	notificationFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sms_gateway_notification_failures_total",
+		Help: "Notifications that failed, by provider, forwarding rule and event.",
+	}, []string{"provider", "rule", "event"})
+
+	amiConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "sms_gateway_ami_connected",
+		Help: "Whether the device monitor is connected to the Asterisk Manager Interface.",
+	})
+
+	amiReconnectsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "sms_gateway_ami_reconnects_total",
+		Help: "AMI connections re-established by the device monitor after a failure.",
+	})
+
+	deviceUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sms_gateway_device_up",
+		Help: "Whether the modem reports the Free or Ring state, by device.",
+	}, []string{"device"})
+
+	simBalance = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sms_gateway_sim_balance",
+		Help: "Last prepaid balance reported by the carrier, by device.",
+	}, []string{"device"})
+
+	simExpiry = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sms_gateway_sim_expiry_timestamp_seconds",
+		Help: "Last SIM expiry date reported by the carrier, as a Unix timestamp, by device.",
+	}, []string{"device"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		smsMessagesTotal, smsSendDuration, notificationsTotal, notificationFailuresTotal,
+		amiConnected, amiReconnectsTotal, deviceUp, simBalance, simExpiry,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "sms_gateway_event_subscribers",
+			Help: "Clients connected to the /api/v1/events stream.",
+		}, func() float64 { return float64(eventBus.Subscribers()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "sms_gateway_event_queue_depth",
+			Help: "Events waiting to be written to /api/v1/events clients.",
+		}, func() float64 { return float64(eventBus.Pending()) }),
+	)
+}
+
+// observeSMSSend 记录一次发送耗时
+func observeSMSSend(device string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	smsSendDuration.WithLabelValues(deviceName(device), result).Observe(time.Since(start).Seconds())
+}
+
+// observeNotification 记录一次规则触发的通知及其结果
+func observeNotification(r *ForwardRule, event string, err error) {
+	provider, _ := r.Settings["notify"].(string)
+	notificationsTotal.WithLabelValues(provider, r.Name, event).Inc()
+	if err != nil {
+		notificationFailuresTotal.WithLabelValues(provider, r.Name, event).Inc()
+	}
+}
+
+// observeDevices 更新设备在线状态
+func observeDevices(infos []*DeviceInfo) {
+	for _, info := range infos {
+		up := 0.0
+		if info.State == "Free" || strings.HasPrefix(info.State, "Ring") {
+			up = 1
+		}
+		deviceUp.WithLabelValues(info.Device).Set(up)
+	}
+}
+
+// metricsHandler 输出 Prometheus 指标。设置了 METRICS_TOKEN 时需要 Authorization: Bearer <METRICS_TOKEN>。
+func metricsHandler() gin.HandlerFunc {
+	h := promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+	return func(c *gin.Context) {
+		if token := os.Getenv("METRICS_TOKEN"); token != "" {
+			got := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, APIResponse{Success: false, Message: "认证失败"})
+				return
+			}
+		}
+		h.ServeHTTP(c.Writer, c.Request)
+	}
+}
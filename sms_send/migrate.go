@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 数据库结构变更以 migrations/<后端>/NNNN_name.sql 的形式嵌入程序，启动时按版本号依次执行，
+// 已执行的版本记录在 schema_migrations 表中。只支持向前迁移，两个后端的版本号保持一致。
+//
+//go:embed migrations/mysql/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+var (
+	// 添加列和索引的语句执行前检查是否已存在，已存在时跳过
+	addColumnPattern = regexp.MustCompile(`(?im)^\s*ALTER\s+TABLE\s+(\w+)\s+ADD\s+COLUMN\s+(\w+)`)
+	addIndexPattern  = regexp.MustCompile(`(?im)^\s*ALTER\s+TABLE\s+(\w+)\s+ADD\s+(?:UNIQUE\s+)?INDEX\s+(\w+)`)
+	// 语句前的 "-- migrate:if-added table.column" 表示只有本次迁移实际添加了该列时才执行，用于回填新列
+	ifAddedPattern = regexp.MustCompile(`(?m)^\s*--\s*migrate:if-added\s+(\w+)\.(\w+)\s*$`)
+)
+
+// Migration 是一个版本的结构变更
+type Migration struct {
+	Version    int
+	Name       string
+	Statements []string
+}
+
+// MigrationStatus 是某个版本的执行情况
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// loadMigrations 读取嵌入的迁移文件并按版本号排序
+func loadMigrations() ([]Migration, error) {
+	dir, err := store.Dialect.Migrations()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(dir, ".")
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	seen := map[int]string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		version, title, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), "_")
+		v, err := strconv.Atoi(version)
+		if !ok || err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %s, expected NNNN_name.sql", name)
+		}
+		if other, dup := seen[v]; dup {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", v, other, name)
+		}
+		seen[v] = name
+		content, err := fs.ReadFile(dir, name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: v, Name: title, Statements: splitSQLStatements(string(content))})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitSQLStatements 按分号拆分语句，忽略只有注释的片段。迁移文件的字符串和注释中不应包含分号。
+func splitSQLStatements(content string) []string {
+	var statements []string
+	for _, part := range strings.Split(content, ";") {
+		hasSQL := false
+		for _, line := range strings.Split(part, "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+				hasSQL = true
+				break
+			}
+		}
+		if hasSQL {
+			statements = append(statements, strings.TrimSpace(part))
+		}
+	}
+	return statements
+}
+
+func createSchemaMigrationsTable() error {
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`
+	if _, err := db.Exec(query); err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+	return nil
+}
+
+// appliedMigrations 返回已执行的版本及执行时间
+func appliedMigrations() (map[int]time.Time, error) {
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// migrationStatus 返回所有迁移及其执行情况
+func migrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int]time.Time{}
+	if exists, err := store.Dialect.TableExists("schema_migrations"); err != nil {
+		return nil, err
+	} else if exists {
+		if applied, err = appliedMigrations(); err != nil {
+			return nil, err
+		}
+	}
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Migration: m}
+		if at, ok := applied[m.Version]; ok {
+			s.AppliedAt = &at
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// printMigrationStatus 输出迁移状态，供 -migrate status 使用
+func printMigrationStatus(w io.Writer) error {
+	status, err := migrationStatus()
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, s := range status {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		} else {
+			pending++
+		}
+		fmt.Fprintf(w, "%04d  %-32s %s\n", s.Version, s.Name, applied)
+	}
+	fmt.Fprintf(w, "%d migrations, %d pending\n", len(status), pending)
+	return nil
+}
+
+// runMigrations 在迁移锁保护下执行所有未执行的迁移。
+//
+// 引入迁移之前的安装没有 schema_migrations 表，但部分表和列已由旧版本创建；MySQL 的 DDL 也不在事务中，
+// 迁移中途失败后部分列已经添加。因此添加列和索引前先查询是否已存在，已存在时跳过该语句，
+// 依赖新列的回填语句用 migrate:if-added 标记，只在本次实际添加了列时执行。
+func runMigrations() error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := store.Dialect.LockMigrations(conn)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	hadMigrations, err := store.Dialect.TableExists("schema_migrations")
+	if err != nil {
+		return err
+	}
+	legacySchema := false
+	if !hadMigrations {
+		if legacySchema, err = store.Dialect.TableExists("sms_log"); err != nil {
+			return err
+		}
+		if legacySchema {
+			log.Info("Existing database without schema_migrations found, adopting it")
+		}
+	}
+	if err := createSchemaMigrationsTable(); err != nil {
+		return err
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		log.Infof("Applying migration %04d_%s", m.Version, m.Name)
+		added := map[string]bool{}
+		for _, stmt := range m.Statements {
+			skip, err := applyStatement(ctx, conn, stmt, added)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+			if skip != "" {
+				log.Infof("Migration %04d_%s: skipping statement, %s", m.Version, m.Name, skip)
+			}
+		}
+		if _, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+			return fmt.Errorf("failed to record migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+	log.Println("Database schema is up to date.")
+	return nil
+}
+
+// applyStatement 执行迁移中的一条语句，跳过时返回原因。added 记录本次迁移实际添加的列（table.column）。
+func applyStatement(ctx context.Context, conn *sql.Conn, stmt string, added map[string]bool) (string, error) {
+	if m := ifAddedPattern.FindStringSubmatch(stmt); m != nil && !added[m[1]+"."+m[2]] {
+		return fmt.Sprintf("column %s.%s existed before this migration", m[1], m[2]), nil
+	}
+	var column string
+	if m := addColumnPattern.FindStringSubmatch(stmt); m != nil {
+		exists, err := store.Dialect.ColumnExists(m[1], m[2])
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return fmt.Sprintf("column %s.%s already exists", m[1], m[2]), nil
+		}
+		column = m[1] + "." + m[2]
+	} else if m := addIndexPattern.FindStringSubmatch(stmt); m != nil {
+		exists, err := store.Dialect.IndexExists(m[1], m[2])
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return fmt.Sprintf("index %s.%s already exists", m[1], m[2]), nil
+		}
+	}
+	if _, err := conn.ExecContext(ctx, stmt); err != nil {
+		return "", err
+	}
+	if column != "" {
+		added[column] = true
+	}
+	return "", nil
+}
@@ -0,0 +1,23 @@
+-- 短信和通话记录
+CREATE TABLE IF NOT EXISTS sms_log (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	direction VARCHAR(10) NOT NULL, -- 'incoming' or 'outgoing'
+	from_number VARCHAR(50) NOT NULL,
+	to_number VARCHAR(50) NOT NULL,
+	body TEXT NOT NULL,
+	status VARCHAR(20) NOT NULL, -- 'received', 'sent', 'failed'
+	phone_id VARCHAR(50),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS call_log (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	call_type VARCHAR(20) NOT NULL, -- 'incoming', 'outgoing', 'missed', etc.
+	phone_number VARCHAR(50) NOT NULL,
+	contact_name VARCHAR(100),
+	duration_seconds INT,
+	call_time VARCHAR(50),
+	phone_id VARCHAR(50),
+	source VARCHAR(50),
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,17 @@
+-- 用户账号和登录会话
+CREATE TABLE IF NOT EXISTS users (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	username VARCHAR(64) NOT NULL UNIQUE,
+	password_hash VARCHAR(100) NOT NULL,
+	role VARCHAR(20) NOT NULL, -- 'admin', 'operator', 'readonly'
+	devices VARCHAR(500) NOT NULL DEFAULT '', -- comma separated device/phone_id list, empty = all
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	token_hash CHAR(64) PRIMARY KEY,
+	user_id INT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_sessions_user (user_id)
+);
@@ -0,0 +1,13 @@
+-- 机器客户端使用的 API token，只保存哈希
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	token_hash CHAR(64) NOT NULL UNIQUE,
+	token_prefix VARCHAR(20) NOT NULL,
+	scopes VARCHAR(200) NOT NULL,
+	created_by VARCHAR(64) NOT NULL,
+	expires_at TIMESTAMP NULL,
+	last_used_at TIMESTAMP NULL,
+	revoked_at TIMESTAMP NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
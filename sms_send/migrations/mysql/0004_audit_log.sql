@@ -0,0 +1,15 @@
+-- 发送、登录和管理操作的审计日志
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	actor VARCHAR(100) NOT NULL,
+	actor_type VARCHAR(20) NOT NULL,
+	client_ip VARCHAR(64) NOT NULL,
+	action VARCHAR(50) NOT NULL,
+	target VARCHAR(255) NOT NULL,
+	result VARCHAR(20) NOT NULL, -- 'success' or 'failure'
+	detail TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_audit_created (created_at),
+	INDEX idx_audit_action (action),
+	INDEX idx_audit_actor (actor)
+);
@@ -0,0 +1,15 @@
+-- 已读状态、回收站和会话归档
+CREATE TABLE IF NOT EXISTS conversation_archive (
+	local_number VARCHAR(50) NOT NULL,
+	other_party VARCHAR(50) NOT NULL,
+	archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (local_number, other_party)
+);
+
+ALTER TABLE sms_log ADD COLUMN read_at TIMESTAMP NULL DEFAULT NULL;
+
+-- 已有的历史消息视为已读。旧版本已添加 read_at 时其中的未读状态是真实的，不能覆盖
+-- migrate:if-added sms_log.read_at
+UPDATE sms_log SET read_at = created_at;
+
+ALTER TABLE sms_log ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL;
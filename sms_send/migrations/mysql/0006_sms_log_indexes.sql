@@ -0,0 +1,5 @@
+-- 会话、分页和搜索查询使用的索引
+ALTER TABLE sms_log ADD INDEX idx_sms_from_to (from_number, to_number, created_at);
+ALTER TABLE sms_log ADD INDEX idx_sms_to_from (to_number, from_number, created_at);
+ALTER TABLE sms_log ADD INDEX idx_sms_created (created_at);
+ALTER TABLE sms_log ADD INDEX idx_sms_phone (phone_id);
@@ -0,0 +1,2 @@
+-- 从 sms.txt 或 chan_quectel smsdb 补录的短信
+ALTER TABLE sms_log ADD COLUMN backfilled TINYINT(1) NOT NULL DEFAULT 0;
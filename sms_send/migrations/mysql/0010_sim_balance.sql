@@ -0,0 +1,11 @@
+-- SIM 卡余额和有效期的查询结果
+CREATE TABLE IF NOT EXISTS sim_balance (
+	id INT AUTO_INCREMENT PRIMARY KEY,
+	device VARCHAR(50) NOT NULL,
+	method VARCHAR(10) NOT NULL, -- 'ussd' or 'sms'
+	balance DOUBLE NULL,
+	expires_on VARCHAR(10) NULL, -- YYYY-MM-DD
+	reply TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_sim_balance_device (device, id)
+);
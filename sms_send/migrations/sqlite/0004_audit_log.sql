@@ -0,0 +1,16 @@
+-- 发送、登录和管理操作的审计日志
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	actor VARCHAR(100) NOT NULL,
+	actor_type VARCHAR(20) NOT NULL,
+	client_ip VARCHAR(64) NOT NULL,
+	action VARCHAR(50) NOT NULL,
+	target VARCHAR(255) NOT NULL,
+	result VARCHAR(20) NOT NULL, -- 'success' or 'failure'
+	detail TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_created ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_action ON audit_log (action);
+CREATE INDEX IF NOT EXISTS idx_audit_actor ON audit_log (actor);
@@ -0,0 +1,11 @@
+-- 已读状态、回收站和会话归档
+CREATE TABLE IF NOT EXISTS conversation_archive (
+	local_number VARCHAR(50) NOT NULL,
+	other_party VARCHAR(50) NOT NULL,
+	archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (local_number, other_party)
+);
+
+ALTER TABLE sms_log ADD COLUMN read_at TIMESTAMP NULL DEFAULT NULL;
+
+ALTER TABLE sms_log ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL;
@@ -0,0 +1,5 @@
+-- 会话、分页和搜索查询使用的索引
+CREATE INDEX IF NOT EXISTS idx_sms_from_to ON sms_log (from_number, to_number, created_at);
+CREATE INDEX IF NOT EXISTS idx_sms_to_from ON sms_log (to_number, from_number, created_at);
+CREATE INDEX IF NOT EXISTS idx_sms_created ON sms_log (created_at);
+CREATE INDEX IF NOT EXISTS idx_sms_phone ON sms_log (phone_id);
@@ -0,0 +1,15 @@
+-- 按（本机号码, 对方号码）汇总的会话列表，启动时为空会根据 sms_log 重建
+CREATE TABLE IF NOT EXISTS conversations (
+	local_number VARCHAR(50) NOT NULL,
+	other_party VARCHAR(50) NOT NULL,
+	phone_id VARCHAR(50), -- phone_id of the last message, used for device filtering
+	last_message_id INT NOT NULL,
+	last_message TEXT NOT NULL,
+	last_message_at TIMESTAMP NULL DEFAULT NULL,
+	total_messages INT NOT NULL DEFAULT 0,
+	unread_count INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (local_number, other_party)
+);
+
+CREATE INDEX IF NOT EXISTS idx_conversations_last ON conversations (last_message_at, last_message_id);
+CREATE INDEX IF NOT EXISTS idx_conversations_phone ON conversations (phone_id);
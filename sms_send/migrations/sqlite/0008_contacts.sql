@@ -0,0 +1,6 @@
+-- 号码对应的联系人名称，用于会话列表和来电通知
+CREATE TABLE IF NOT EXISTS contacts (
+	number VARCHAR(50) PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
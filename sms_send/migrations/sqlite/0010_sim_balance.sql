@@ -0,0 +1,12 @@
+-- SIM 卡余额和有效期的查询结果
+CREATE TABLE IF NOT EXISTS sim_balance (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	device VARCHAR(50) NOT NULL,
+	method VARCHAR(10) NOT NULL, -- 'ussd' or 'sms'
+	balance REAL NULL,
+	expires_on VARCHAR(10) NULL, -- YYYY-MM-DD
+	reply TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_sim_balance_device ON sim_balance (device, id);
@@ -0,0 +1,12 @@
+-- 防止 SIM 卡因长期不使用被停用而发送的保活短信或 USSD
+CREATE TABLE IF NOT EXISTS keepalive_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	device VARCHAR(50) NOT NULL,
+	method VARCHAR(10) NOT NULL, -- 'ussd' or 'sms'
+	target VARCHAR(50) NOT NULL,
+	status VARCHAR(20) NOT NULL, -- 'sent' or 'failed'
+	detail TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_keepalive_device ON keepalive_log (device, id);
@@ -0,0 +1,13 @@
+-- 转发到 Telegram 的短信通知，用于把 Telegram 中的回复发回原发送人
+CREATE TABLE IF NOT EXISTS telegram_messages (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	bot_id VARCHAR(20) NOT NULL,
+	chat_id VARCHAR(50) NOT NULL,
+	message_id BIGINT NOT NULL,
+	device VARCHAR(50) NOT NULL,
+	number VARCHAR(50) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_telegram_message ON telegram_messages (bot_id, chat_id, message_id);
+CREATE INDEX IF NOT EXISTS idx_telegram_created ON telegram_messages (created_at);
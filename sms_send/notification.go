@@ -7,7 +7,6 @@ import (
 	"io"
 	"net/http"
 	"net/smtp"
-	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -15,48 +14,80 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func sendNotification(config map[string]interface{}, sender string, time string, text string, rule string, smsReq SMSReciveRequest) {
+func sendNotification(config map[string]interface{}, sender string, time string, text string, rule string, smsReq SMSReciveRequest) error {
 	message := fmt.Sprintf("触发规则: %s\n发送时间: %s\n发送人: %s \nphoneID: %s\n短信内容: %s\nSource: %s", rule, time, sender, smsReq.PhoneID, text, smsReq.Source)
 	messagePhone := fmt.Sprintf("%s\n%s\n%s\n%s", text, smsReq.PhoneID, smsReq.Time, smsReq.Source)
-	sendForward(config, "短信通知", sender, message, messagePhone)
+	return sendForward(config, "短信通知", sender, message, messagePhone, ForwardOrigin{Number: sender, Device: smsReq.PhoneID})
 }
 
-func sendCallNotification(config map[string]interface{}, rule string, callReq CallRequest) {
+func sendCallNotification(config map[string]interface{}, rule string, callReq CallRequest) error {
 	message := fmt.Sprintf("发送时间: %s\n发送人: %s \n%s\nphoneID: %s\n来电号码: %s\nSource: %s", callReq.Time, callReq.Number, callReq.Type, callReq.PhoneID, callReq.Name, callReq.Source)
 	messagePhone := fmt.Sprintf("%s\n%s\n%s\n%s\n%s\n%s", callReq.Number, callReq.Type, callReq.PhoneID, callReq.Time, callReq.Name, callReq.Source)
-	sendForward(config, "来电通知", "来电通知", message, messagePhone)
+	return sendForward(config, "来电通知", "来电通知", message, messagePhone, ForwardOrigin{Number: callReq.Number})
 }
 
-func sendForward(config map[string]interface{}, title string, mobileTitle string, message string, messagePhone string) {
+func sendUSSDNotification(config map[string]interface{}, time string, text string, ussdReq USSDRequest) error {
+	message := fmt.Sprintf("接收时间: %s\n设备: %s\nphoneID: %s\nUSSD内容: %s", time, ussdReq.Device, ussdReq.PhoneID, text)
+	messagePhone := fmt.Sprintf("%s\n%s\n%s", text, ussdReq.PhoneID, time)
+	return sendForward(config, "USSD通知", "USSD通知", message, messagePhone, ForwardOrigin{})
+}
+
+func sendDeviceAlertNotification(config map[string]interface{}, alert DeviceAlert) error {
+	title := "设备告警"
+	if alert.Resolved {
+		title = "设备恢复"
+	}
+	message := alert.Message()
+	messagePhone := fmt.Sprintf("%s\n%s\n%s", message, alert.Device, alert.CreatedAt.Format("2006-01-02 15:04:05"))
+	return sendForward(config, title, title, message, messagePhone, ForwardOrigin{})
+}
+
+// ForwardOrigin 是触发通知的号码，用于短信转发防循环和 Telegram 回复
+type ForwardOrigin struct {
+	Number string // 短信发送人或来电号码，USSD 和设备告警为空
+	Device string // 收到短信的设备，只有短信通知才设置，Telegram 回复通过该设备发回
+}
+
+// notifyRule 发送规则的通知并记录指标。sms 类型要经模块逐条发送，耗时较长，在后台发送，不阻塞推送请求
+func notifyRule(r *ForwardRule, event string, send func() error) {
+	if notify, _ := r.Settings["notify"].(string); notify == "sms" {
+		runBackground(func() { observeNotification(r, event, send()) })
+		return
+	}
+	observeNotification(r, event, send())
+}
+
+// sendForward 按规则的 notify 类型发送通知
+func sendForward(config map[string]interface{}, title string, mobileTitle string, message string, messagePhone string, origin ForwardOrigin) error {
 	notifyType, ok := config["notify"].(string)
 	if !ok {
 		log.Error("通知类型配置错误")
-		return
+		return fmt.Errorf("missing notify type")
 	}
 
 	switch notifyType {
 	case "wechat":
 		url, ok := config["url"].(string)
 		if ok {
-			sendWechat(url, title, message)
+			return sendWechat(url, title, message)
 		}
 	case "bark":
 		url, ok := config["url"].(string)
 		if ok {
-			sendBark(url, mobileTitle, messagePhone)
+			return sendBark(url, mobileTitle, messagePhone)
 		}
 	case "gotify":
 		url, ok1 := config["url"].(string)
 		token, ok2 := config["token"].(string)
 		if ok1 && ok2 {
-			sendGotify(url, token, mobileTitle, messagePhone)
+			return sendGotify(url, token, mobileTitle, messagePhone)
 		}
 	case "ntfy":
 		url, ok1 := config["url"].(string)
 		topic, ok2 := config["topic"].(string)
 		token, _ := config["token"].(string) // token is optional
 		if ok1 && ok2 {
-			sendNtfy(url, topic, token, mobileTitle, messagePhone)
+			return sendNtfy(url, topic, token, mobileTitle, messagePhone)
 		}
 	case "email":
 		smtpHost, ok1 := config["smtp_host"].(string)
@@ -66,37 +97,51 @@ func sendForward(config map[string]interface{}, title string, mobileTitle string
 		from, ok5 := config["from"].(string)
 		to, ok6 := config["to"].(string)
 		if ok1 && ok2 && ok3 && ok4 && ok5 && ok6 {
-			sendEmail(smtpHost, smtpPort, username, password, from, to, title, message)
+			return sendEmail(smtpHost, smtpPort, username, password, from, to, title, message)
 		}
 	case "qq":
 		qq, ok1 := config["qq"].(string)
 		token, ok2 := config["token"].(string)
 		if ok1 && ok2 {
-			sendQQPush(token, qq, fmt.Sprintf("%s\n%s", title, message))
+			return sendQQPush(token, qq, fmt.Sprintf("%s\n%s", title, message))
 		}
 	case "feishu":
 		url, ok := config["url"].(string)
 		if ok {
-			sendFeishu(url, title, message)
+			return sendFeishu(url, title, message)
 		}
 	case "dingtalk":
 		url, ok := config["url"].(string)
 		if ok {
-			sendDingtalk(url, title, message)
+			return sendDingtalk(url, title, message)
 		}
 	case "telegram":
 		botToken, ok1 := config["bot_token"].(string)
 		chatID, ok2 := config["chat_id"].(string)
 		proxyURL, _ := config["proxy"].(string) // 代理配置，可选
+		apiURL, _ := config["api_url"].(string) // Bot API 地址，可选
 		if ok1 && ok2 {
-			sendTelegram(botToken, chatID, message, proxyURL)
+			messageID, err := sendTelegram(apiURL, botToken, chatID, message, proxyURL)
+			if err == nil && origin.Device != "" && configBool(config["reply"]) {
+				recordTelegramMessage(botToken, chatID, messageID, origin)
+			}
+			return err
+		}
+	case "sms":
+		device, ok1 := config["device"].(string)
+		to := configStringList(config["to"])
+		if ok1 && len(to) > 0 {
+			return sendSMSForward(device, to, fmt.Sprintf("%s\n%s", mobileTitle, messagePhone), origin.Number, configInt(config["max_parts"]))
 		}
 	default:
 		log.Warnf("未知的通知类型: %s", notifyType)
+		return fmt.Errorf("unknown notify type %q", notifyType)
 	}
+	log.Errorf("通知配置不完整: %s", notifyType)
+	return fmt.Errorf("incomplete settings for notify type %s", notifyType)
 }
 
-func sendWechat(url string, title, message string) {
+func sendWechat(url string, title, message string) error {
 	type Content struct {
 		Content string `json:"content"`
 	}
@@ -110,7 +155,7 @@ func sendWechat(url string, title, message string) {
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBytes))
 	if err != nil {
 		log.Errorf("创建微信请求失败: %v", err)
-		return
+		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
@@ -118,11 +163,15 @@ func sendWechat(url string, title, message string) {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Errorf("发送微信通知失败: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
 	log.Infof("微信通知响应状态: %s", resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("wechat returned status %d", resp.StatusCode)
+	}
+	return nil
 }
 
 // BarkRequest Bark请求参数
@@ -141,7 +190,7 @@ type BarkRequest struct {
 	AutoCopy  int    `json:"autoCopy,omitempty"`
 }
 
-func sendBark(url, title, body string) {
+func sendBark(url, title, body string) error {
 	// 构建请求参数
 	msgMap := BarkRequest{
 		Title:     title,
@@ -151,9 +200,7 @@ func sendBark(url, title, body string) {
 	}
 
 	// 检测验证码模式 - 使用修复后的函数
-	pattern := `(?i)(验证码|授权码|校验码|检验码|确认码|激活码|动态码|安全码|验证代码|CODE|Verification)`
-	matched, _ := regexp.MatchString(pattern, body)
-	if matched {
+	if isVerificationMessage(body) {
 		// 提取验证码
 		code := extractVerificationCode(body)
 		if code != "" {
@@ -167,14 +214,14 @@ func sendBark(url, title, body string) {
 	requestMsg, err := json.Marshal(msgMap)
 	if err != nil {
 		log.Errorf("序列化请求数据失败: %v", err)
-		return
+		return err
 	}
 
 	log.Infof("Bark请求数据: %s", string(requestMsg))
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestMsg))
 	if err != nil {
 		log.Errorf("创建Bark请求失败: %v", err)
-		return
+		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
@@ -182,15 +229,16 @@ func sendBark(url, title, body string) {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Errorf("发送Bark通知失败: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		log.Info("Bark通知发送成功")
-	} else {
+	if resp.StatusCode != http.StatusOK {
 		log.Errorf("Bark通知发送失败，状态码: %d", resp.StatusCode)
+		return fmt.Errorf("bark returned status %d", resp.StatusCode)
 	}
+	log.Info("Bark通知发送成功")
+	return nil
 }
 
 type GotifyRequest struct {
@@ -199,7 +247,7 @@ type GotifyRequest struct {
 	Priority int    `json:"priority,omitempty"`
 }
 
-func sendGotify(url, token, title, message string) {
+func sendGotify(url, token, title, message string) error {
 	msg := GotifyRequest{
 		Title:    title,
 		Message:  message,
@@ -209,7 +257,7 @@ func sendGotify(url, token, title, message string) {
 	req, err := http.NewRequest("POST", url+"/message?token="+token, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		log.Errorf("创建Gotify请求失败: %v", err)
-		return
+		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
@@ -217,17 +265,22 @@ func sendGotify(url, token, title, message string) {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Errorf("发送Gotify通知失败: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Errorf("Gotify通知发送失败，状态码: %d", resp.StatusCode)
+		return fmt.Errorf("gotify returned status %d", resp.StatusCode)
+	}
 	log.Info("Gotify通知发送成功")
+	return nil
 }
 
-func sendNtfy(ntfyURL, topic, token, title, message string) {
+func sendNtfy(ntfyURL, topic, token, title, message string) error {
 	req, err := http.NewRequest("POST", ntfyURL+"/"+topic, strings.NewReader(message))
 	if err != nil {
 		log.Errorf("创建Ntfy请求失败: %v", err)
-		return
+		return err
 	}
 	req.Header.Set("Title", title)
 	req.Header.Set("Priority", "4")
@@ -239,19 +292,20 @@ func sendNtfy(ntfyURL, topic, token, title, message string) {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Errorf("发送Ntfy通知失败: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		log.Info("Ntfy通知发送成功")
-	} else {
+	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		log.Errorf("Ntfy通知发送失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("ntfy returned status %d", resp.StatusCode)
 	}
+	log.Info("Ntfy通知发送成功")
+	return nil
 }
 
-func sendEmail(smtpHost, smtpPort, username, password, from, to, subject, body string) {
+func sendEmail(smtpHost, smtpPort, username, password, from, to, subject, body string) error {
 	auth := smtp.PlainAuth("", username, password, smtpHost)
 	msg := []byte("To: " + to + "\r\n" +
 		"Subject: " + subject + "\r\n" +
@@ -260,9 +314,10 @@ func sendEmail(smtpHost, smtpPort, username, password, from, to, subject, body s
 	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, from, []string{to}, msg)
 	if err != nil {
 		log.Errorf("邮件发送失败: %v", err)
-		return
+		return err
 	}
 	log.Info("邮件发送成功")
+	return nil
 }
 
 // FeishuRequest 飞书机器人请求结构
@@ -273,7 +328,7 @@ type FeishuRequest struct {
 	} `json:"content"`
 }
 
-func sendFeishu(webhookURL, title, message string) {
+func sendFeishu(webhookURL, title, message string) error {
 	// 构建飞书消息
 	feishuMsg := FeishuRequest{
 		MsgType: "text",
@@ -287,13 +342,13 @@ func sendFeishu(webhookURL, title, message string) {
 	payload, err := json.Marshal(feishuMsg)
 	if err != nil {
 		log.Errorf("序列化飞书请求失败: %v", err)
-		return
+		return err
 	}
 
 	req, err := http.NewRequest("POST", webhookURL, bytes.NewBuffer(payload))
 	if err != nil {
 		log.Errorf("创建飞书请求失败: %v", err)
-		return
+		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
@@ -301,16 +356,17 @@ func sendFeishu(webhookURL, title, message string) {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Errorf("发送飞书通知失败: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode == http.StatusOK {
-		log.Info("飞书通知发送成功")
-	} else {
+	if resp.StatusCode != http.StatusOK {
 		log.Errorf("飞书通知发送失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("feishu returned status %d", resp.StatusCode)
 	}
+	log.Info("飞书通知发送成功")
+	return nil
 }
 
 // DingtalkRequest 钉钉机器人请求结构
@@ -324,7 +380,7 @@ type DingtalkRequest struct {
 	} `json:"at"`
 }
 
-func sendDingtalk(webhookURL, title, message string) {
+func sendDingtalk(webhookURL, title, message string) error {
 	// 构建钉钉消息
 	dingtalkMsg := DingtalkRequest{
 		MsgType: "text",
@@ -343,13 +399,13 @@ func sendDingtalk(webhookURL, title, message string) {
 	payload, err := json.Marshal(dingtalkMsg)
 	if err != nil {
 		log.Errorf("序列化钉钉请求失败: %v", err)
-		return
+		return err
 	}
 
 	req, err := http.NewRequest("POST", webhookURL, bytes.NewBuffer(payload))
 	if err != nil {
 		log.Errorf("创建钉钉请求失败: %v", err)
-		return
+		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
@@ -357,79 +413,25 @@ func sendDingtalk(webhookURL, title, message string) {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Errorf("发送钉钉通知失败: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode == http.StatusOK {
-		log.Info("钉钉通知发送成功")
-	} else {
+	if resp.StatusCode != http.StatusOK {
 		log.Errorf("钉钉通知发送失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+		return fmt.Errorf("dingtalk returned status %d", resp.StatusCode)
 	}
+	log.Info("钉钉通知发送成功")
+	return nil
 }
 
-// TelegramRequest Telegram 发送消息请求结构
-type TelegramRequest struct {
-	ChatID string `json:"chat_id"`
-	Text   string `json:"text"`
-}
-
-// sendTelegram 发送Telegram消息，支持代理
-func sendTelegram(botToken, chatID, message, proxyURL string) {
-	// Telegram Bot API URL
-	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", botToken)
-
-	// 构建消息
-	tgMsg := TelegramRequest{
-		ChatID: chatID,
-		Text:   message,
-	}
-
-	payload, err := json.Marshal(tgMsg)
-	if err != nil {
-		log.Errorf("序列化Telegram请求失败: %v", err)
-		return
-	}
-
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(payload))
-	if err != nil {
-		log.Errorf("创建Telegram请求失败: %v", err)
-		return
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	// 创建HTTP客户端，支持代理
-	client := &http.Client{Timeout: 30 * time.Second}
-
-	// 如果配置了代理，设置代理传输
-	if proxyURL != "" {
-		proxy, err := url.Parse(proxyURL)
-		if err != nil {
-			log.Errorf("解析代理URL失败: %v", err)
-			return
-		}
+// verificationPattern 匹配验证码类短信的关键词
+var verificationPattern = regexp.MustCompile(`(?i)(验证码|授权码|校验码|检验码|确认码|激活码|动态码|安全码|验证代码|CODE|Verification)`)
 
-		transport := &http.Transport{
-			Proxy: http.ProxyURL(proxy),
-		}
-		client.Transport = transport
-		log.Infof("使用代理发送Telegram消息: %s", proxyURL)
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Errorf("发送Telegram通知失败: %v", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode == http.StatusOK {
-		log.Info("Telegram通知发送成功")
-	} else {
-		log.Errorf("Telegram通知发送失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
-	}
+// isVerificationMessage 判断内容是否是验证码类短信
+func isVerificationMessage(body string) bool {
+	return verificationPattern.MatchString(body)
 }
 
 // extractVerificationCode 从内容中提取验证码
@@ -464,7 +466,7 @@ func extractVerificationCode(content string) string {
 
 type PostData map[string]interface{}
 
-func sendQQPush(token, cqq, msg string) {
+func sendQQPush(token, cqq, msg string) error {
 	log.Infof("发送QQPush通知: token=%s, cqq=%s, msg=%s", token, cqq, msg)
 
 	posturl := fmt.Sprintf("https://wx.scjtqs.com/qq/push/pushMsg?token=%s", token)
@@ -483,13 +485,13 @@ func sendQQPush(token, cqq, msg string) {
 	})
 	if err != nil {
 		log.Errorf("序列化QQPush请求数据失败: %v", err)
-		return
+		return err
 	}
 
 	req, err := http.NewRequest("POST", posturl, bytes.NewBuffer(postdata))
 	if err != nil {
 		log.Errorf("创建QQPush请求失败: %v", err)
-		return
+		return err
 	}
 	req.Header = header
 
@@ -497,7 +499,7 @@ func sendQQPush(token, cqq, msg string) {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Errorf("发送QQPush通知失败: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
@@ -505,8 +507,120 @@ func sendQQPush(token, cqq, msg string) {
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Errorf("读取QQPush响应失败: %v", err)
-		return
+		return err
 	}
 
 	log.Infof("QQPush通知发送成功, 响应: %s", string(body))
+	return nil
+}
+
+const (
+	smsPartLength          = 70 // 含中文的短信按 UCS-2 编码，单条最多 70 个字符
+	smsPartLengthASCII     = 160
+	defaultSMSForwardParts = 3
+)
+
+// splitSMS 把消息拆成不超过 maxParts 条短信，每条带 (1/3) 形式的序号，超出部分截断并以省略号结尾
+func splitSMS(message string, maxParts int) []string {
+	if maxParts <= 0 {
+		maxParts = defaultSMSForwardParts
+	}
+	runes := []rune(strings.TrimSpace(message))
+	size, ellipsis := smsPartLength, "…"
+	if isASCII(string(runes)) {
+		// 截断标记也用 ASCII，避免整条短信变成 UCS-2 编码
+		size, ellipsis = smsPartLengthASCII, "..."
+	}
+	if len(runes) <= size {
+		return []string{string(runes)}
+	}
+	size -= len(fmt.Sprintf("(%d/%d)", maxParts, maxParts))
+	var parts []string
+	for len(runes) > 0 && len(parts) < maxParts {
+		n := size
+		if n > len(runes) {
+			n = len(runes)
+		}
+		parts = append(parts, string(runes[:n]))
+		runes = runes[n:]
+	}
+	if len(runes) > 0 {
+		last := []rune(parts[len(parts)-1])
+		parts[len(parts)-1] = string(last[:len(last)-len([]rune(ellipsis))]) + ellipsis
+	}
+	for i := range parts {
+		parts[i] = fmt.Sprintf("(%d/%d)%s", i+1, len(parts), parts[i])
+	}
+	return parts
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// sameNumber 比较两个号码，忽略空格、横线和 +86 国家码
+func sameNumber(a, b string) bool {
+	clean := func(s string) string {
+		s = strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(s))
+		s = strings.TrimPrefix(s, "+")
+		if len(s) == 13 && strings.HasPrefix(s, "86") {
+			s = s[2:]
+		}
+		return s
+	}
+	return a != "" && b != "" && clean(a) == clean(b)
+}
+
+// sendSMSForward 通过设备把通知以短信转发到手机号码，每条短信都记入短信记录。
+// 为避免循环，不会转发给触发通知的号码，也不会转发给本服务管理的 SIM 卡。
+func sendSMSForward(device string, to []string, message, origin string, maxParts int) error {
+	parts := splitSMS(message, maxParts)
+	var errs, sent []string
+	for _, recipient := range to {
+		if sameNumber(recipient, origin) {
+			log.Infof("短信转发跳过 %s：通知来自该号码", recipient)
+			continue
+		}
+		if ownSIMNumber(recipient) {
+			log.Warnf("短信转发跳过 %s：该号码是本服务的 SIM 卡", recipient)
+			continue
+		}
+		if err := deliverSMSParts(device, recipient, parts); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", recipient, err))
+			continue
+		}
+		sent = append(sent, recipient)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("sms forward via %s failed: %s", device, strings.Join(errs, "; "))
+	}
+	if len(sent) > 0 {
+		log.Infof("短信转发成功: %s -> %s (%d 条)", device, strings.Join(sent, ","), len(parts))
+	}
+	return nil
+}
+
+// ownSIMNumber 判断号码是否属于本服务管理的 SIM 卡
+func ownSIMNumber(number string) bool {
+	for _, d := range devices.Summaries() {
+		if sameNumber(d.Number, number) {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverSMSParts 依次发送拆分后的短信，某条失败时不再发送后续部分
+func deliverSMSParts(device, recipient string, parts []string) error {
+	for _, part := range parts {
+		if err := deliverSMS(device, recipient, part); err != nil {
+			return err
+		}
+	}
+	return nil
 }
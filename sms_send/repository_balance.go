@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// sqlBalanceRepository 是 BalanceRepository 基于 database/sql 的实现
+type sqlBalanceRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+const balanceColumns = "id, device, method, balance, expires_on, reply, created_at"
+
+func (r *sqlBalanceRepository) Insert(rec BalanceRecord) (int64, error) {
+	var expires interface{}
+	if rec.ExpiresOn != "" {
+		expires = rec.ExpiresOn
+	}
+	res, err := r.db.Exec("INSERT INTO sim_balance (device, method, balance, expires_on, reply, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		rec.Device, rec.Method, rec.Balance, expires, rec.Reply, r.dialect.TimeArg(rec.CreatedAt))
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert balance of %s: %w", rec.Device, err)
+	}
+	return res.LastInsertId()
+}
+
+// Latest 返回每个设备最近一次的查询结果
+func (r *sqlBalanceRepository) Latest() ([]BalanceRecord, error) {
+	return r.query("SELECT " + balanceColumns + " FROM sim_balance WHERE id IN (SELECT MAX(id) FROM sim_balance GROUP BY device) ORDER BY device")
+}
+
+// History 返回设备最近 limit 次查询结果，新的在前
+func (r *sqlBalanceRepository) History(device string, limit int) ([]BalanceRecord, error) {
+	return r.query("SELECT "+balanceColumns+" FROM sim_balance WHERE device = ? ORDER BY id DESC LIMIT ?", device, limit)
+}
+
+func (r *sqlBalanceRepository) query(query string, args ...interface{}) ([]BalanceRecord, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sim_balance: %w", err)
+	}
+	defer rows.Close()
+	records := []BalanceRecord{}
+	for rows.Next() {
+		var rec BalanceRecord
+		var balance sql.NullFloat64
+		var expires, reply sql.NullString
+		if err := rows.Scan(&rec.ID, &rec.Device, &rec.Method, &balance, &expires, &reply, &rec.CreatedAt); err != nil {
+			return nil, err
+		}
+		if balance.Valid {
+			rec.Balance = &balance.Float64
+		}
+		rec.ExpiresOn, rec.Reply = expires.String, reply.String
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqlCallRepository 是 CallRepository 基于 database/sql 的实现
+type sqlCallRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func (r *sqlCallRepository) Insert(call CallRecord) (int64, error) {
+	query := `INSERT INTO call_log (call_type, phone_number, contact_name, duration_seconds, call_time, phone_id, source) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	res, err := r.db.Exec(query, call.CallType, call.PhoneNumber, call.ContactName, call.DurationSeconds, call.CallTime, call.PhoneID, call.Source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert call log: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+func (r *sqlCallRepository) OlderThan(before time.Time, afterID, limit int) ([]CallRecord, error) {
+	query := `SELECT id, call_type, phone_number, COALESCE(contact_name, ''), COALESCE(duration_seconds, 0), COALESCE(call_time, ''),
+		COALESCE(phone_id, ''), COALESCE(source, ''), created_at FROM call_log WHERE id > ? AND created_at < ? ORDER BY id LIMIT ?`
+	rows, err := r.db.Query(query, afterID, r.dialect.TimeArg(before), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired calls: %w", err)
+	}
+	defer rows.Close()
+
+	var calls []CallRecord
+	for rows.Next() {
+		var call CallRecord
+		if err := rows.Scan(&call.ID, &call.CallType, &call.PhoneNumber, &call.ContactName, &call.DurationSeconds, &call.CallTime, &call.PhoneID, &call.Source, &call.CreatedAt); err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+	}
+	return calls, rows.Err()
+}
+
+func (r *sqlCallRepository) DeleteByIDs(ids []int) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	res, err := r.db.Exec("DELETE FROM call_log WHERE id IN ("+inPlaceholders(len(ids))+")", args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete calls: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// exportFilter 生成导出的过滤条件，通话记录没有本机号码列，只按 phone_id 过滤
+func (r *sqlCallRepository) exportFilter(q ExportQuery) (string, []interface{}) {
+	where, args := scopeFilter(q.Scope, "phone_id", "")
+	where = " WHERE 1=1" + where
+	if q.Number != "" {
+		where += " AND phone_number = ?"
+		args = append(args, q.Number)
+	}
+	if q.Since != nil {
+		where += " AND created_at >= ?"
+		args = append(args, r.dialect.TimeArg(*q.Since))
+	}
+	if q.Until != nil {
+		where += " AND created_at <= ?"
+		args = append(args, r.dialect.TimeArg(*q.Until))
+	}
+	return where, args
+}
+
+func (r *sqlCallRepository) CountExport(q ExportQuery) (int, error) {
+	where, args := r.exportFilter(q)
+	var n int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM call_log"+where, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count calls: %w", err)
+	}
+	return n, nil
+}
+
+func (r *sqlCallRepository) Export(q ExportQuery, fn func(CallRecord) error) error {
+	where, args := r.exportFilter(q)
+	query := `SELECT id, call_type, phone_number, COALESCE(contact_name, ''), COALESCE(duration_seconds, 0), COALESCE(call_time, ''),
+		COALESCE(phone_id, ''), COALESCE(source, ''), created_at FROM call_log` + where + " ORDER BY created_at, id"
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to export calls: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var call CallRecord
+		if err := rows.Scan(&call.ID, &call.CallType, &call.PhoneNumber, &call.ContactName, &call.DurationSeconds, &call.CallTime, &call.PhoneID, &call.Source, &call.CreatedAt); err != nil {
+			return err
+		}
+		if err := fn(call); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *sqlCallRepository) Import(calls []CallRecord) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	imported := 0
+	for _, call := range calls {
+		createdAt := r.dialect.TimeArg(call.CreatedAt.Truncate(time.Second))
+		var exists int
+		err := tx.QueryRow("SELECT COUNT(*) FROM call_log WHERE call_type = ? AND phone_number = ? AND created_at = ?", call.CallType, call.PhoneNumber, createdAt).Scan(&exists)
+		if err != nil {
+			return 0, fmt.Errorf("failed to check existing call: %w", err)
+		}
+		if exists > 0 {
+			continue
+		}
+		_, err = tx.Exec(`INSERT INTO call_log (call_type, phone_number, contact_name, duration_seconds, call_time, phone_id, source, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			call.CallType, call.PhoneNumber, call.ContactName, call.DurationSeconds, call.CallTime, call.PhoneID, call.Source, createdAt)
+		if err != nil {
+			return 0, fmt.Errorf("failed to import call: %w", err)
+		}
+		imported++
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
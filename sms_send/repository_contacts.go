@@ -0,0 +1,72 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqlContactRepository 是 ContactRepository 基于 database/sql 的实现
+type sqlContactRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func (r *sqlContactRepository) List() ([]Contact, error) {
+	rows, err := r.db.Query("SELECT number, name, updated_at FROM contacts ORDER BY name, number")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query contacts: %w", err)
+	}
+	defer rows.Close()
+	contacts := []Contact{}
+	for rows.Next() {
+		var ct Contact
+		if err := rows.Scan(&ct.Number, &ct.Name, &ct.UpdatedAt); err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, ct)
+	}
+	return contacts, rows.Err()
+}
+
+// Names 返回号码到联系人名称的映射，没有联系人的号码不在结果中
+func (r *sqlContactRepository) Names(numbers []string) (map[string]string, error) {
+	names := map[string]string{}
+	if len(numbers) == 0 {
+		return names, nil
+	}
+	args := make([]interface{}, 0, len(numbers))
+	for _, n := range numbers {
+		args = append(args, n)
+	}
+	rows, err := r.db.Query("SELECT number, name FROM contacts WHERE number IN ("+inPlaceholders(len(numbers))+")", args...)
+	if err != nil {
+		return names, fmt.Errorf("failed to query contacts: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var number, name string
+		if err := rows.Scan(&number, &name); err != nil {
+			return names, err
+		}
+		names[number] = name
+	}
+	return names, rows.Err()
+}
+
+func (r *sqlContactRepository) Upsert(c Contact) error {
+	query := "REPLACE INTO contacts (number, name, updated_at) VALUES (?, ?, ?)"
+	if _, err := r.db.Exec(query, c.Number, c.Name, r.dialect.TimeArg(time.Now())); err != nil {
+		return fmt.Errorf("failed to save contact %s: %w", c.Number, err)
+	}
+	return nil
+}
+
+func (r *sqlContactRepository) Delete(number string) (bool, error) {
+	res, err := r.db.Exec("DELETE FROM contacts WHERE number = ?", number)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete contact %s: %w", number, err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqlKeepAliveRepository 是 KeepAliveRepository 基于 database/sql 的实现
+type sqlKeepAliveRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func (r *sqlKeepAliveRepository) Insert(rec KeepAliveRecord) (int64, error) {
+	res, err := r.db.Exec("INSERT INTO keepalive_log (device, method, target, status, detail, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		rec.Device, rec.Method, rec.Target, rec.Status, rec.Detail, r.dialect.TimeArg(rec.CreatedAt))
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert keep-alive of %s: %w", rec.Device, err)
+	}
+	return res.LastInsertId()
+}
+
+func (r *sqlKeepAliveRepository) Latest() ([]KeepAliveRecord, error) {
+	rows, err := r.db.Query(`SELECT id, device, method, target, status, detail, created_at FROM keepalive_log
+		WHERE id IN (SELECT MAX(id) FROM keepalive_log GROUP BY device) ORDER BY device`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query keepalive_log: %w", err)
+	}
+	defer rows.Close()
+	records := []KeepAliveRecord{}
+	for rows.Next() {
+		var rec KeepAliveRecord
+		var detail sql.NullString
+		if err := rows.Scan(&rec.ID, &rec.Device, &rec.Method, &rec.Target, &rec.Status, &detail, &rec.CreatedAt); err != nil {
+			return nil, err
+		}
+		rec.Detail = detail.String
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func (r *sqlKeepAliveRepository) LastSent(device string) (t time.Time, ok bool, err error) {
+	err = r.db.QueryRow("SELECT created_at FROM keepalive_log WHERE device = ? AND status = 'sent' ORDER BY id DESC LIMIT 1", device).Scan(&t)
+	if err == sql.ErrNoRows {
+		return t, false, nil
+	}
+	if err != nil {
+		return t, false, fmt.Errorf("failed to query keepalive_log: %w", err)
+	}
+	return t, true, nil
+}
@@ -0,0 +1,733 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// localNumberExpr 是 sms_log 中本机 SIM 号码的表达式，otherPartyExpr 是对方号码
+const (
+	localNumberExpr = "CASE WHEN direction = 'incoming' THEN to_number ELSE from_number END"
+	otherPartyExpr  = "CASE WHEN direction = 'incoming' THEN from_number ELSE to_number END"
+)
+
+// sqlSMSRepository 是 SMSRepository 基于 database/sql 的实现，MySQL 和 SQLite 共用
+type sqlSMSRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// inPlaceholders 返回 n 个 ? 组成的 IN 列表
+func inPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// scopeFilter 生成 SMSScope 的过滤条件。localColumn 为空时不按本机号码过滤（调用方已在会话条件中限定）。
+func scopeFilter(s SMSScope, phoneIDColumn, localColumn string) (string, []interface{}) {
+	var filter string
+	var args []interface{}
+	if len(s.PhoneIDs) > 0 {
+		filter += fmt.Sprintf(" AND %s IN (%s)", phoneIDColumn, inPlaceholders(len(s.PhoneIDs)))
+		for _, id := range s.PhoneIDs {
+			args = append(args, id)
+		}
+	}
+	if s.PhoneID != "" {
+		filter += " AND " + phoneIDColumn + " = ?"
+		args = append(args, s.PhoneID)
+	}
+	if s.LocalNumber != "" && localColumn != "" {
+		filter += " AND " + localColumn + " = ?"
+		args = append(args, s.LocalNumber)
+	}
+	return filter, args
+}
+
+// keysetFilter 生成按 (timeColumn, idColumn) 倒序翻页的条件
+func (r *sqlSMSRepository) keysetFilter(before *Cursor, timeColumn, idColumn string) (string, []interface{}) {
+	if before == nil {
+		return "", nil
+	}
+	t := r.dialect.TimeArg(before.Time)
+	return fmt.Sprintf(" AND (%s < ? OR (%s = ? AND %s < ?))", timeColumn, timeColumn, idColumn), []interface{}{t, t, before.ID}
+}
+
+// now 返回写入时间列使用的当前时间
+func (r *sqlSMSRepository) now() interface{} {
+	return r.dialect.TimeArg(time.Now())
+}
+
+// Insert 记录一条短信并更新会话汇总，发出的短信无需阅读，直接记为已读
+func (r *sqlSMSRepository) Insert(msg SMSMessage) (int64, error) {
+	var readAt interface{}
+	if msg.Direction == "outgoing" {
+		readAt = r.now()
+	}
+	query := `INSERT INTO sms_log (direction, from_number, to_number, body, status, phone_id, read_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	res, err := r.db.Exec(query, msg.Direction, msg.FromNumber, msg.ToNumber, msg.Body, msg.Status, msg.PhoneID, readAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert SMS log: %w", err)
+	}
+	key := smsConversationKey(msg.Direction, msg.FromNumber, msg.ToNumber)
+	if msg.Direction == "incoming" {
+		// 会话收到新短信时取消归档，让它回到收件箱
+		if _, err := r.db.Exec("DELETE FROM conversation_archive WHERE local_number = ? AND other_party = ?", key.LocalNumber, key.OtherParty); err != nil {
+			log.Errorf("Failed to unarchive conversation %s/%s: %v", key.LocalNumber, key.OtherParty, err)
+		}
+	}
+	r.refreshConversations([]ConversationKey{key})
+	return res.LastInsertId()
+}
+
+// Conversations 从会话汇总表分页读取会话，最新的在前
+func (r *sqlSMSRepository) Conversations(q ConversationQuery) ([]Conversation, int, error) {
+	filter, filterArgs := scopeFilter(q.Scope, "C.phone_id", "C.local_number")
+	switch q.View {
+	case "", "inbox":
+		filter += " AND A.local_number IS NULL"
+	case "archived":
+		filter += " AND A.local_number IS NOT NULL"
+	case "all":
+	default:
+		return nil, 0, fmt.Errorf("unknown view %q", q.View)
+	}
+	from := ` FROM conversations C LEFT JOIN conversation_archive A ON A.local_number = C.local_number AND A.other_party = C.other_party WHERE 1=1`
+
+	var total int
+	if err := r.db.QueryRow("SELECT COUNT(*)"+from+filter, filterArgs...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count conversations: %w", err)
+	}
+
+	keyset, keysetArgs := r.keysetFilter(q.Before, "C.last_message_at", "C.last_message_id")
+	offset := q.Offset
+	if q.Before != nil {
+		offset = 0
+	}
+	query := `
+        SELECT C.local_number, C.other_party, C.last_message_id, C.last_message, C.last_message_at,
+            C.total_messages, C.unread_count, A.local_number IS NOT NULL` + from + filter + keyset + `
+        ORDER BY C.last_message_at DESC, C.last_message_id DESC
+        LIMIT ? OFFSET ?;
+    `
+	args := append(append(filterArgs, keysetArgs...), q.Limit, offset)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query conversations: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+	for rows.Next() {
+		var conv Conversation
+		if err := rows.Scan(&conv.LocalNumber, &conv.OtherParty, &conv.LastMessageID, &conv.LastMessage, &conv.LastMessageAt, &conv.TotalMessages, &conv.UnreadCount, &conv.Archived); err != nil {
+			log.Errorf("Error scanning conversation row: %v", err)
+			continue
+		}
+		conversations = append(conversations, conv)
+	}
+	return conversations, total, rows.Err()
+}
+
+// Messages 返回与某个号码往来的消息，按时间倒序；Scope.LocalNumber 非空时只取该 SIM 的会话
+func (r *sqlSMSRepository) Messages(q MessageQuery) ([]SMSMessage, error) {
+	cond, args := otherPartyCond(q.OtherParty)
+	if q.Scope.LocalNumber != "" {
+		cond, args = conversationCond(ConversationKey{LocalNumber: q.Scope.LocalNumber, OtherParty: q.OtherParty})
+	}
+	filter, filterArgs := scopeFilter(q.Scope, "phone_id", "")
+	keyset, keysetArgs := r.keysetFilter(q.Before, "created_at", "id")
+	args = append(append(append(args, filterArgs...), keysetArgs...), q.Limit)
+
+	query := `
+        SELECT id, direction, from_number, to_number, body, status, COALESCE(phone_id, ''), read_at, backfilled, created_at
+        FROM sms_log WHERE deleted_at IS NULL AND ` + cond + filter + keyset + `
+        ORDER BY created_at DESC, id DESC
+        LIMIT ?;
+    `
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages with %s: %w", q.OtherParty, err)
+	}
+	defer rows.Close()
+
+	var messages []SMSMessage
+	for rows.Next() {
+		var msg SMSMessage
+		if err := rows.Scan(&msg.ID, &msg.Direction, &msg.FromNumber, &msg.ToNumber, &msg.Body, &msg.Status, &msg.PhoneID, &msg.ReadAt, &msg.Backfilled, &msg.CreatedAt); err != nil {
+			log.Errorf("Error scanning message row: %v", err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// Search 搜索回收站以外的短信正文，所有关键词都需命中
+func (r *sqlSMSRepository) Search(q SearchQuery) ([]SearchResult, int, error) {
+	where, args := scopeFilter(q.Scope, "phone_id", localNumberExpr)
+	where = " AND deleted_at IS NULL" + where
+	cond, condArgs := r.dialect.SearchCondition(q.Terms)
+	where += cond
+	args = append(args, condArgs...)
+
+	for _, f := range []struct{ value, column string }{
+		{q.Direction, "direction"},
+		{q.Status, "status"},
+		{q.Sender, "from_number"},
+	} {
+		if f.value != "" {
+			where += " AND " + f.column + " = ?"
+			args = append(args, f.value)
+		}
+	}
+	if q.Since != nil {
+		where += " AND created_at >= ?"
+		args = append(args, r.dialect.TimeArg(*q.Since))
+	}
+	if q.Until != nil {
+		where += " AND created_at <= ?"
+		args = append(args, r.dialect.TimeArg(*q.Until))
+	}
+
+	var total int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM sms_log WHERE 1=1"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	query := `SELECT id, direction, from_number, to_number, body, status, COALESCE(phone_id, ''), read_at, created_at, ` +
+		localNumberExpr + `, ` + otherPartyExpr + ` FROM sms_log WHERE 1=1` + where + ` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`
+	rows, err := r.db.Query(query, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var res SearchResult
+		if err := rows.Scan(&res.ID, &res.Direction, &res.FromNumber, &res.ToNumber, &res.Body, &res.Status, &res.PhoneID, &res.ReadAt, &res.CreatedAt, &res.LocalNumber, &res.OtherParty); err != nil {
+			log.Errorf("Error scanning search row: %v", err)
+			continue
+		}
+		results = append(results, res)
+	}
+	return results, total, rows.Err()
+}
+
+// MarkConversationRead 把与某个号码往来的未读来信标记为已读
+func (r *sqlSMSRepository) MarkConversationRead(scope SMSScope, otherParty string) (int64, error) {
+	cond, args := otherPartyCond(otherParty)
+	filter, filterArgs := scopeFilter(scope, "phone_id", localNumberExpr)
+	query := "UPDATE sms_log SET read_at = ? WHERE " + cond + " AND direction = 'incoming' AND read_at IS NULL AND deleted_at IS NULL" + filter
+	res, err := r.db.Exec(query, append(append([]interface{}{r.now()}, args...), filterArgs...)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark conversation %s as read: %w", otherParty, err)
+	}
+	affected, _ := res.RowsAffected()
+	if affected > 0 {
+		keys, err := r.conversationKeysForOtherParty(otherParty)
+		if err != nil {
+			log.Errorf("Failed to refresh conversations for %s: %v", otherParty, err)
+		}
+		r.refreshConversations(keys)
+	}
+	return affected, nil
+}
+
+// UpdateConversation 对单个会话执行批量操作，返回受影响的消息数
+func (r *sqlSMSRepository) UpdateConversation(scope SMSScope, action string, key ConversationKey) (int64, error) {
+	cond, args := conversationCond(key)
+	filter, filterArgs := scopeFilter(scope, "phone_id", "")
+	where := " WHERE " + cond + filter
+	args = append(args, filterArgs...)
+	now := r.now()
+
+	var query string
+	switch action {
+	case BulkRead:
+		query = "UPDATE sms_log SET read_at = ?" + where + " AND direction = 'incoming' AND read_at IS NULL AND deleted_at IS NULL"
+		args = append([]interface{}{now}, args...)
+	case BulkUnread:
+		query = "UPDATE sms_log SET read_at = NULL" + where + " AND direction = 'incoming' AND deleted_at IS NULL"
+	case BulkDelete:
+		query = "UPDATE sms_log SET deleted_at = ?" + where + " AND deleted_at IS NULL"
+		args = append([]interface{}{now}, args...)
+	case BulkRestore:
+		query = "UPDATE sms_log SET deleted_at = NULL" + where + " AND deleted_at IS NOT NULL"
+	case BulkArchive, BulkUnarchive:
+		// 归档状态记录在 conversation_archive 中，先确认会话在当前用户可见范围内
+		var n int64
+		if err := r.db.QueryRow("SELECT COUNT(*) FROM sms_log"+where+" AND deleted_at IS NULL", args...).Scan(&n); err != nil {
+			return 0, fmt.Errorf("failed to check conversation: %w", err)
+		}
+		if n == 0 {
+			return 0, nil
+		}
+		if action == BulkArchive {
+			query = "REPLACE INTO conversation_archive (local_number, other_party, archived_at) VALUES (?, ?, ?)"
+			args = []interface{}{key.LocalNumber, key.OtherParty, now}
+		} else {
+			query = "DELETE FROM conversation_archive WHERE local_number = ? AND other_party = ?"
+			args = []interface{}{key.LocalNumber, key.OtherParty}
+		}
+		if _, err := r.db.Exec(query, args...); err != nil {
+			return 0, fmt.Errorf("failed to %s conversation: %w", action, err)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("action %s is not supported for conversations", action)
+	}
+
+	res, err := r.db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to %s conversation: %w", action, err)
+	}
+	r.refreshConversations([]ConversationKey{key})
+	return res.RowsAffected()
+}
+
+// UpdateMessages 对指定 ID 的消息执行已读/未读、删除/恢复以及彻底删除
+func (r *sqlSMSRepository) UpdateMessages(scope SMSScope, action string, ids []int) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	filter, filterArgs := scopeFilter(scope, "phone_id", "")
+	where := " WHERE id IN (" + inPlaceholders(len(ids)) + ")" + filter
+	var args []interface{}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	args = append(args, filterArgs...)
+	now := r.now()
+
+	keys, err := r.conversationKeysForIDs(ids)
+	if err != nil {
+		return 0, err
+	}
+
+	var query string
+	switch action {
+	case BulkRead:
+		query = "UPDATE sms_log SET read_at = ?" + where + " AND direction = 'incoming' AND read_at IS NULL"
+		args = append([]interface{}{now}, args...)
+	case BulkUnread:
+		query = "UPDATE sms_log SET read_at = NULL" + where + " AND direction = 'incoming'"
+	case BulkDelete:
+		query = "UPDATE sms_log SET deleted_at = ?" + where + " AND deleted_at IS NULL"
+		args = append([]interface{}{now}, args...)
+	case BulkRestore:
+		query = "UPDATE sms_log SET deleted_at = NULL" + where + " AND deleted_at IS NOT NULL"
+	case BulkPurge:
+		// 只能彻底删除已在回收站中的消息
+		query = "DELETE FROM sms_log" + where + " AND deleted_at IS NOT NULL"
+	default:
+		return 0, fmt.Errorf("action %s is not supported for messages", action)
+	}
+
+	res, err := r.db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to %s messages: %w", action, err)
+	}
+	r.refreshConversations(keys)
+	return res.RowsAffected()
+}
+
+// Trash 分页列出回收站中的消息，最近删除的在前
+func (r *sqlSMSRepository) Trash(scope SMSScope, limit, offset int) ([]SMSMessage, int, error) {
+	filter, filterArgs := scopeFilter(scope, "phone_id", localNumberExpr)
+	where := " WHERE deleted_at IS NOT NULL" + filter
+
+	var total int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM sms_log"+where, filterArgs...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count trash: %w", err)
+	}
+
+	query := "SELECT id, direction, from_number, to_number, body, status, COALESCE(phone_id, ''), read_at, deleted_at, created_at FROM sms_log" +
+		where + " ORDER BY deleted_at DESC, id DESC LIMIT ? OFFSET ?"
+	rows, err := r.db.Query(query, append(filterArgs, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query trash: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []SMSMessage{}
+	for rows.Next() {
+		var msg SMSMessage
+		if err := rows.Scan(&msg.ID, &msg.Direction, &msg.FromNumber, &msg.ToNumber, &msg.Body, &msg.Status, &msg.PhoneID, &msg.ReadAt, &msg.DeletedAt, &msg.CreatedAt); err != nil {
+			log.Errorf("Error scanning trash row: %v", err)
+			continue
+		}
+		messages = append(messages, msg)
+	}
+	return messages, total, rows.Err()
+}
+
+// EmptyTrash 彻底删除回收站中范围内的所有消息
+func (r *sqlSMSRepository) EmptyTrash(scope SMSScope) (int64, error) {
+	filter, filterArgs := scopeFilter(scope, "phone_id", localNumberExpr)
+	res, err := r.db.Exec("DELETE FROM sms_log WHERE deleted_at IS NOT NULL"+filter, filterArgs...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to empty trash: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// UnknownLocalPhoneIDs 返回本机号码记为 unknown 的消息所属的 phone_id
+func (r *sqlSMSRepository) UnknownLocalPhoneIDs() ([]string, error) {
+	rows, err := r.db.Query(`SELECT DISTINCT phone_id FROM sms_log
+		WHERE phone_id IS NOT NULL AND ((direction = 'incoming' AND to_number = 'unknown') OR (direction = 'outgoing' AND from_number = 'unknown'))`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query phone ids: %w", err)
+	}
+	defer rows.Close()
+	var phoneIDs []string
+	for rows.Next() {
+		var phoneID string
+		if err := rows.Scan(&phoneID); err == nil {
+			phoneIDs = append(phoneIDs, phoneID)
+		}
+	}
+	return phoneIDs, rows.Err()
+}
+
+// LastSent 返回这些 phone_id 最近一条发送成功的短信的时间，to 不为空时只看发给该号码的短信，没有时 ok 为 false
+func (r *sqlSMSRepository) LastSent(phoneIDs []string, to string) (t time.Time, ok bool, err error) {
+	if len(phoneIDs) == 0 {
+		return t, false, nil
+	}
+	args := make([]interface{}, 0, len(phoneIDs)+1)
+	for _, id := range phoneIDs {
+		args = append(args, id)
+	}
+	query := "SELECT created_at FROM sms_log WHERE direction = 'outgoing' AND status = 'sent' AND phone_id IN (" + inPlaceholders(len(phoneIDs)) + ")"
+	if to != "" {
+		query += " AND to_number = ?"
+		args = append(args, to)
+	}
+	err = r.db.QueryRow(query+" ORDER BY id DESC LIMIT 1", args...).Scan(&t)
+	if err == sql.ErrNoRows {
+		return t, false, nil
+	}
+	if err != nil {
+		return t, false, fmt.Errorf("failed to query last sent SMS: %w", err)
+	}
+	return t, true, nil
+}
+
+// SetLocalNumber 把 phone_id 下本机号码为 unknown 的消息补上号码
+func (r *sqlSMSRepository) SetLocalNumber(phoneID, number string) (int64, int64, error) {
+	in, err := r.db.Exec("UPDATE sms_log SET to_number = ? WHERE direction = 'incoming' AND to_number = 'unknown' AND phone_id = ?", number, phoneID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to update incoming rows for %s: %w", phoneID, err)
+	}
+	out, err := r.db.Exec("UPDATE sms_log SET from_number = ? WHERE direction = 'outgoing' AND from_number = 'unknown' AND phone_id = ?", number, phoneID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to update outgoing rows for %s: %w", phoneID, err)
+	}
+	nIn, _ := in.RowsAffected()
+	nOut, _ := out.RowsAffected()
+	return nIn, nOut, nil
+}
+
+// refreshConversation 根据 sms_log 重新计算一个会话的汇总，会话中已无消息时删除汇总
+func (r *sqlSMSRepository) refreshConversation(key ConversationKey) error {
+	cond, args := conversationCond(key)
+	var total int
+	var unread, lastID *int64
+	query := `SELECT COUNT(*), SUM(CASE WHEN direction = 'incoming' AND read_at IS NULL THEN 1 ELSE 0 END), MAX(id)
+		FROM sms_log WHERE deleted_at IS NULL AND ` + cond
+	if err := r.db.QueryRow(query, args...).Scan(&total, &unread, &lastID); err != nil {
+		return fmt.Errorf("failed to summarize conversation %s/%s: %w", key.LocalNumber, key.OtherParty, err)
+	}
+	if total == 0 || lastID == nil {
+		if _, err := r.db.Exec("DELETE FROM conversations WHERE local_number = ? AND other_party = ?", key.LocalNumber, key.OtherParty); err != nil {
+			return fmt.Errorf("failed to remove conversation %s/%s: %w", key.LocalNumber, key.OtherParty, err)
+		}
+		return nil
+	}
+	unreadCount := int64(0)
+	if unread != nil {
+		unreadCount = *unread
+	}
+
+	query = `REPLACE INTO conversations (local_number, other_party, phone_id, last_message_id, last_message, last_message_at, total_messages, unread_count)
+		SELECT ?, ?, phone_id, id, body, created_at, ?, ? FROM sms_log WHERE id = ?`
+	if _, err := r.db.Exec(query, key.LocalNumber, key.OtherParty, total, unreadCount, *lastID); err != nil {
+		return fmt.Errorf("failed to update conversation %s/%s: %w", key.LocalNumber, key.OtherParty, err)
+	}
+	return nil
+}
+
+// refreshConversations 刷新多个会话的汇总，出错时只记录日志
+func (r *sqlSMSRepository) refreshConversations(keys []ConversationKey) {
+	for _, key := range keys {
+		if err := r.refreshConversation(key); err != nil {
+			log.Errorf("Failed to refresh conversation summary: %v", err)
+		}
+	}
+}
+
+// conversationKeysForIDs 返回指定消息所属的会话
+func (r *sqlSMSRepository) conversationKeysForIDs(ids []int) ([]ConversationKey, error) {
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	rows, err := r.db.Query("SELECT DISTINCT direction, from_number, to_number FROM sms_log WHERE id IN ("+inPlaceholders(len(ids))+")", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up conversations: %w", err)
+	}
+	defer rows.Close()
+
+	seen := map[ConversationKey]bool{}
+	var keys []ConversationKey
+	for rows.Next() {
+		var direction, from, to string
+		if err := rows.Scan(&direction, &from, &to); err != nil {
+			return nil, err
+		}
+		if key := smsConversationKey(direction, from, to); !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	return keys, rows.Err()
+}
+
+// conversationKeysForOtherParty 返回与某个对方号码的所有会话
+func (r *sqlSMSRepository) conversationKeysForOtherParty(number string) ([]ConversationKey, error) {
+	rows, err := r.db.Query("SELECT local_number, other_party FROM conversations WHERE other_party = ?", number)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up conversations: %w", err)
+	}
+	defer rows.Close()
+	var keys []ConversationKey
+	for rows.Next() {
+		var key ConversationKey
+		if err := rows.Scan(&key.LocalNumber, &key.OtherParty); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RebuildConversations 根据 sms_log 重建整个会话汇总表
+func (r *sqlSMSRepository) RebuildConversations() error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM conversations"); err != nil {
+		return fmt.Errorf("failed to clear conversations: %w", err)
+	}
+	query := `
+	INSERT INTO conversations (local_number, other_party, phone_id, last_message_id, last_message, last_message_at, total_messages, unread_count)
+	SELECT T.local_number, T.other_party, m.phone_id, m.id, m.body, m.created_at, T.total_messages, T.unread_count
+	FROM (
+		SELECT
+			` + localNumberExpr + ` as local_number,
+			` + otherPartyExpr + ` as other_party,
+			MAX(id) as max_id,
+			COUNT(*) as total_messages,
+			SUM(CASE WHEN direction = 'incoming' AND read_at IS NULL THEN 1 ELSE 0 END) as unread_count
+		FROM sms_log
+		WHERE deleted_at IS NULL
+		GROUP BY local_number, other_party
+	) AS T
+	JOIN sms_log m ON m.id = T.max_id`
+	res, err := tx.Exec(query)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild conversations: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	n, _ := res.RowsAffected()
+	log.Infof("Rebuilt conversation summaries: %d conversations", n)
+	return nil
+}
+
+// EnsureConversations 升级后首次启动时，会话汇总表为空而 sms_log 有数据，进行一次全量重建
+func (r *sqlSMSRepository) EnsureConversations() error {
+	var conversations, messages int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM conversations").Scan(&conversations); err != nil {
+		return err
+	}
+	if conversations > 0 {
+		return nil
+	}
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM sms_log WHERE deleted_at IS NULL").Scan(&messages); err != nil {
+		return err
+	}
+	if messages == 0 {
+		return nil
+	}
+	return r.RebuildConversations()
+}
+
+func (r *sqlSMSRepository) RetentionCandidates(before time.Time, trash bool, afterID, limit int) ([]SMSMessage, error) {
+	cond := " AND created_at < ?"
+	if trash {
+		cond = " AND deleted_at IS NOT NULL AND deleted_at < ?"
+	}
+	query := "SELECT id, direction, from_number, to_number, body, status, COALESCE(phone_id, ''), read_at, deleted_at, created_at FROM sms_log WHERE id > ?" +
+		cond + " ORDER BY id LIMIT ?"
+	rows, err := r.db.Query(query, afterID, r.dialect.TimeArg(before), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expired messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []SMSMessage
+	for rows.Next() {
+		var msg SMSMessage
+		if err := rows.Scan(&msg.ID, &msg.Direction, &msg.FromNumber, &msg.ToNumber, &msg.Body, &msg.Status, &msg.PhoneID, &msg.ReadAt, &msg.DeletedAt, &msg.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+func (r *sqlSMSRepository) DeleteByIDs(ids []int) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	keys, err := r.conversationKeysForIDs(ids)
+	if err != nil {
+		return 0, err
+	}
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	res, err := r.db.Exec("DELETE FROM sms_log WHERE id IN ("+inPlaceholders(len(ids))+")", args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete messages: %w", err)
+	}
+	r.refreshConversations(keys)
+	return res.RowsAffected()
+}
+
+// exportFilter 生成导出的过滤条件
+func (r *sqlSMSRepository) exportFilter(q ExportQuery) (string, []interface{}) {
+	where, args := scopeFilter(q.Scope, "phone_id", localNumberExpr)
+	where = " WHERE deleted_at IS NULL" + where
+	if q.Number != "" {
+		cond, condArgs := otherPartyCond(q.Number)
+		where += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	if q.Since != nil {
+		where += " AND created_at >= ?"
+		args = append(args, r.dialect.TimeArg(*q.Since))
+	}
+	if q.Until != nil {
+		where += " AND created_at <= ?"
+		args = append(args, r.dialect.TimeArg(*q.Until))
+	}
+	return where, args
+}
+
+func (r *sqlSMSRepository) CountExport(q ExportQuery) (int, error) {
+	where, args := r.exportFilter(q)
+	var n int
+	if err := r.db.QueryRow("SELECT COUNT(*) FROM sms_log"+where, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to count messages: %w", err)
+	}
+	return n, nil
+}
+
+func (r *sqlSMSRepository) Export(q ExportQuery, fn func(SMSMessage) error) error {
+	where, args := r.exportFilter(q)
+	query := "SELECT id, direction, from_number, to_number, body, status, COALESCE(phone_id, ''), read_at, backfilled, created_at FROM sms_log" +
+		where + " ORDER BY created_at, id"
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to export messages: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var msg SMSMessage
+		if err := rows.Scan(&msg.ID, &msg.Direction, &msg.FromNumber, &msg.ToNumber, &msg.Body, &msg.Status, &msg.PhoneID, &msg.ReadAt, &msg.Backfilled, &msg.CreatedAt); err != nil {
+			return err
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *sqlSMSRepository) Import(msgs []SMSMessage) (int, error) {
+	return r.insertHistory(msgs, false, false, func(tx *sql.Tx, msg SMSMessage) (bool, error) {
+		var n int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM sms_log WHERE direction = ? AND from_number = ? AND to_number = ? AND created_at = ? AND body = ?`,
+			msg.Direction, msg.FromNumber, msg.ToNumber, r.dialect.TimeArg(msg.CreatedAt.Truncate(time.Second)), msg.Body).Scan(&n)
+		return n > 0, err
+	})
+}
+
+func (r *sqlSMSRepository) Backfill(msgs []SMSMessage, window time.Duration, dryRun bool) (int, error) {
+	return r.insertHistory(msgs, true, dryRun, func(tx *sql.Tx, msg SMSMessage) (bool, error) {
+		var n int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM sms_log WHERE direction = ? AND from_number = ? AND body = ? AND created_at BETWEEN ? AND ?`,
+			msg.Direction, msg.FromNumber, msg.Body, r.dialect.TimeArg(msg.CreatedAt.Add(-window)), r.dialect.TimeArg(msg.CreatedAt.Add(window))).Scan(&n)
+		return n > 0, err
+	})
+}
+
+// insertHistory 在一个事务中写入带原始时间的消息，exists 判断为已存在的跳过，返回实际写入的数量。
+// dryRun 时只统计缺失的消息，不写入。
+func (r *sqlSMSRepository) insertHistory(msgs []SMSMessage, backfilled, dryRun bool, exists func(tx *sql.Tx, msg SMSMessage) (bool, error)) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	imported := 0
+	seen := map[ConversationKey]bool{}
+	var keys []ConversationKey
+	for _, msg := range msgs {
+		found, err := exists(tx, msg)
+		if err != nil {
+			return 0, fmt.Errorf("failed to check existing message: %w", err)
+		}
+		if found {
+			continue
+		}
+		imported++
+		if dryRun {
+			continue
+		}
+		var readAt interface{}
+		if msg.ReadAt != nil {
+			readAt = r.dialect.TimeArg(msg.ReadAt.Truncate(time.Second))
+		}
+		_, err = tx.Exec(`INSERT INTO sms_log (direction, from_number, to_number, body, status, phone_id, read_at, backfilled, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			msg.Direction, msg.FromNumber, msg.ToNumber, msg.Body, msg.Status, msg.PhoneID, readAt, backfilled, r.dialect.TimeArg(msg.CreatedAt.Truncate(time.Second)))
+		if err != nil {
+			return 0, fmt.Errorf("failed to import message: %w", err)
+		}
+		if key := smsConversationKey(msg.Direction, msg.FromNumber, msg.ToNumber); !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if dryRun {
+		return imported, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	r.refreshConversations(keys)
+	return imported, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// sqlTelegramRepository 是 TelegramRepository 基于 database/sql 的实现
+type sqlTelegramRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+func (r *sqlTelegramRepository) Insert(m TelegramMessage) error {
+	_, err := r.db.Exec("INSERT INTO telegram_messages (bot_id, chat_id, message_id, device, number, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		m.BotID, m.ChatID, m.MessageID, m.Device, m.Number, r.dialect.TimeArg(m.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("failed to insert telegram message %d: %w", m.MessageID, err)
+	}
+	return nil
+}
+
+func (r *sqlTelegramRepository) Lookup(botID, chatID string, messageID int64) (m TelegramMessage, ok bool, err error) {
+	err = r.db.QueryRow(`SELECT bot_id, chat_id, message_id, device, number, created_at FROM telegram_messages
+		WHERE bot_id = ? AND chat_id = ? AND message_id = ? ORDER BY id DESC LIMIT 1`, botID, chatID, messageID).
+		Scan(&m.BotID, &m.ChatID, &m.MessageID, &m.Device, &m.Number, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		return m, false, nil
+	}
+	if err != nil {
+		return m, false, fmt.Errorf("failed to query telegram_messages: %w", err)
+	}
+	return m, true, nil
+}
+
+func (r *sqlTelegramRepository) Prune(before time.Time) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM telegram_messages WHERE created_at < ?", r.dialect.TimeArg(before))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune telegram_messages: %w", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// 数据保留策略的分类
+const (
+	RetentionAll   = "all"   // 表中所有记录
+	RetentionOTP   = "otp"   // 验证码短信
+	RetentionTrash = "trash" // 回收站中的短信，按移入回收站的时间计算
+	RetentionRegex = "regex" // 正文匹配 pattern 的短信
+)
+
+const (
+	defaultRetentionBatchSize  = 500
+	defaultRetentionInterval   = 24 // 小时
+	defaultRetentionArchiveDir = "/data/archive"
+)
+
+// RetentionPolicy 是一条保留策略：table 中属于 category 且超过 days 天的记录会被删除
+type RetentionPolicy struct {
+	Name     string `json:"name"`
+	Table    string `json:"table"`
+	Category string `json:"category"`
+	Pattern  string `json:"pattern,omitempty"`
+	Days     int    `json:"days"`
+
+	re *regexp.Regexp
+}
+
+// RetentionConfig 来自 forward.yaml 的 retention 段，例如：
+//
+//	retention:
+//	  interval_hours: 24
+//	  batch_size: 500
+//	  dry_run: false                 # 只统计不删除
+//	  archive: true                  # 删除前写入 archive_dir 下的 .jsonl.gz
+//	  archive_dir: /data/archive
+//	  policies:
+//	    验证码:
+//	      table: sms_log
+//	      category: otp
+//	      days: 7
+//	    通话记录:
+//	      table: call_log
+//	      days: 365
+type RetentionConfig struct {
+	IntervalHours int               `json:"interval_hours"`
+	BatchSize     int               `json:"batch_size"`
+	DryRun        bool              `json:"dry_run"`
+	Archive       bool              `json:"archive"`
+	ArchiveDir    string            `json:"archive_dir"`
+	Policies      []RetentionPolicy `json:"policies"`
+}
+
+// RetentionResult 是一条策略一次执行的结果
+type RetentionResult struct {
+	Policy  string `json:"policy"`
+	Table   string `json:"table"`
+	Matched int64  `json:"matched"`
+	Deleted int64  `json:"deleted"`
+	Archive string `json:"archive,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RetentionReport 是一次清理的报告
+type RetentionReport struct {
+	DryRun     bool              `json:"dry_run"`
+	StartedAt  time.Time         `json:"started_at"`
+	FinishedAt time.Time         `json:"finished_at"`
+	Results    []RetentionResult `json:"results"`
+}
+
+var (
+	retentionConfig = &RetentionConfig{IntervalHours: defaultRetentionInterval, BatchSize: defaultRetentionBatchSize, ArchiveDir: defaultRetentionArchiveDir}
+	// retentionMu 保证同一时间只有一次清理在执行
+	retentionMu         sync.Mutex
+	lastRetentionReport *RetentionReport
+	lastRetentionMu     sync.RWMutex
+)
+
+// loadRetentionConfig 解析 forward.yaml 中的 retention 段，无效的策略记录警告后跳过
+func loadRetentionConfig(v interface{}) *RetentionConfig {
+	cfg := &RetentionConfig{IntervalHours: defaultRetentionInterval, BatchSize: defaultRetentionBatchSize, ArchiveDir: defaultRetentionArchiveDir}
+	section, ok := v.(map[string]interface{})
+	if !ok {
+		return cfg
+	}
+	if hours := configInt(section["interval_hours"]); hours > 0 {
+		cfg.IntervalHours = hours
+	}
+	if n := configInt(section["batch_size"]); n > 0 {
+		cfg.BatchSize = n
+	}
+	cfg.DryRun = configBool(section["dry_run"])
+	cfg.Archive = configBool(section["archive"])
+	if dir, _ := section["archive_dir"].(string); strings.TrimSpace(dir) != "" {
+		cfg.ArchiveDir = strings.TrimSpace(dir)
+	}
+
+	policies, _ := section["policies"].(map[string]interface{})
+	for name, raw := range policies {
+		settings, ok := raw.(map[string]interface{})
+		if !ok {
+			log.Warnf("保留策略配置格式错误: %s", name)
+			continue
+		}
+		p := RetentionPolicy{Name: name, Category: RetentionAll, Days: configInt(settings["days"])}
+		p.Table, _ = settings["table"].(string)
+		if category, _ := settings["category"].(string); category != "" {
+			p.Category = category
+		}
+		p.Pattern, _ = settings["pattern"].(string)
+		if err := p.validate(); err != nil {
+			log.Warnf("忽略保留策略 %s: %v", name, err)
+			continue
+		}
+		cfg.Policies = append(cfg.Policies, p)
+	}
+	// 短期策略（如验证码）先执行
+	sort.Slice(cfg.Policies, func(i, j int) bool {
+		if cfg.Policies[i].Days != cfg.Policies[j].Days {
+			return cfg.Policies[i].Days < cfg.Policies[j].Days
+		}
+		return cfg.Policies[i].Name < cfg.Policies[j].Name
+	})
+	return cfg
+}
+
+// validate 检查策略并编译正则
+func (p *RetentionPolicy) validate() error {
+	if p.Days <= 0 {
+		return fmt.Errorf("days must be positive")
+	}
+	switch p.Table {
+	case "sms_log":
+		switch p.Category {
+		case RetentionAll, RetentionOTP, RetentionTrash:
+		case RetentionRegex:
+			re, err := regexp.Compile(p.Pattern)
+			if err != nil || p.Pattern == "" {
+				return fmt.Errorf("invalid pattern %q", p.Pattern)
+			}
+			p.re = re
+		default:
+			return fmt.Errorf("unknown category %q for sms_log", p.Category)
+		}
+	case "call_log":
+		if p.Category != RetentionAll {
+			return fmt.Errorf("call_log only supports category %s", RetentionAll)
+		}
+	default:
+		return fmt.Errorf("table must be sms_log or call_log")
+	}
+	return nil
+}
+
+// matches 判断候选短信是否属于该策略的分类
+func (p *RetentionPolicy) matches(msg SMSMessage) bool {
+	switch p.Category {
+	case RetentionOTP:
+		return isVerificationMessage(msg.Body)
+	case RetentionRegex:
+		return p.re.MatchString(msg.Body)
+	}
+	return true
+}
+
+// retentionArchive 把删除的记录逐行写入 gzip 压缩的 JSONL 文件，第一次写入时才创建文件
+type retentionArchive struct {
+	path string
+	file *os.File
+	gz   *gzip.Writer
+	enc  *json.Encoder
+}
+
+func newRetentionArchive(dir string, p RetentionPolicy, now time.Time) *retentionArchive {
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ' ' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, p.Name)
+	return &retentionArchive{path: filepath.Join(dir, fmt.Sprintf("%s-%s-%s.jsonl.gz", p.Table, name, now.Format("20060102-150405")))}
+}
+
+// write 写入一批记录并刷新到文件，保证删除之前数据已落盘
+func (a *retentionArchive) write(rows []interface{}) error {
+	if a.file == nil {
+		if err := os.MkdirAll(filepath.Dir(a.path), 0o750); err != nil {
+			return fmt.Errorf("failed to create archive dir: %w", err)
+		}
+		f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return fmt.Errorf("failed to create archive %s: %w", a.path, err)
+		}
+		a.file = f
+		a.gz = gzip.NewWriter(f)
+		a.enc = json.NewEncoder(a.gz)
+	}
+	for _, row := range rows {
+		if err := a.enc.Encode(row); err != nil {
+			return fmt.Errorf("failed to write archive %s: %w", a.path, err)
+		}
+	}
+	if err := a.gz.Flush(); err != nil {
+		return fmt.Errorf("failed to write archive %s: %w", a.path, err)
+	}
+	return a.file.Sync()
+}
+
+func (a *retentionArchive) Close() error {
+	if a.file == nil {
+		return nil
+	}
+	if err := a.gz.Close(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+// retentionBatch 是一批候选记录：rows 用于归档，ids 为要删除的记录
+type retentionBatch struct {
+	rows   []interface{}
+	ids    []int
+	lastID int
+	full   bool
+}
+
+// nextRetentionBatch 读取策略的下一批候选记录
+func nextRetentionBatch(p RetentionPolicy, before time.Time, afterID, limit int) (retentionBatch, error) {
+	var b retentionBatch
+	if p.Table == "call_log" {
+		calls, err := store.Calls.OlderThan(before, afterID, limit)
+		if err != nil {
+			return b, err
+		}
+		for _, call := range calls {
+			b.rows = append(b.rows, call)
+			b.ids = append(b.ids, call.ID)
+			b.lastID = call.ID
+		}
+		b.full = len(calls) == limit
+		return b, nil
+	}
+
+	messages, err := store.SMS.RetentionCandidates(before, p.Category == RetentionTrash, afterID, limit)
+	if err != nil {
+		return b, err
+	}
+	for _, msg := range messages {
+		b.lastID = msg.ID
+		if p.matches(msg) {
+			b.rows = append(b.rows, msg)
+			b.ids = append(b.ids, msg.ID)
+		}
+	}
+	b.full = len(messages) == limit
+	return b, nil
+}
+
+// applyRetentionPolicy 分批删除策略匹配的过期记录，dryRun 时只统计数量
+func applyRetentionPolicy(cfg *RetentionConfig, p RetentionPolicy, dryRun bool, now time.Time) RetentionResult {
+	res := RetentionResult{Policy: p.Name, Table: p.Table}
+	before := now.AddDate(0, 0, -p.Days)
+
+	var archive *retentionArchive
+	if cfg.Archive && !dryRun {
+		archive = newRetentionArchive(cfg.ArchiveDir, p, now)
+		defer func() {
+			if err := archive.Close(); err != nil && res.Error == "" {
+				res.Error = err.Error()
+			}
+		}()
+	}
+
+	afterID := 0
+	for {
+		batch, err := nextRetentionBatch(p, before, afterID, cfg.BatchSize)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		res.Matched += int64(len(batch.ids))
+		if !dryRun && len(batch.ids) > 0 {
+			if archive != nil {
+				if err := archive.write(batch.rows); err != nil {
+					res.Error = err.Error()
+					return res
+				}
+				res.Archive = archive.path
+			}
+			var n int64
+			if p.Table == "call_log" {
+				n, err = store.Calls.DeleteByIDs(batch.ids)
+			} else {
+				n, err = store.SMS.DeleteByIDs(batch.ids)
+			}
+			if err != nil {
+				res.Error = err.Error()
+				return res
+			}
+			res.Deleted += n
+		}
+		if !batch.full {
+			return res
+		}
+		afterID = batch.lastID
+	}
+}
+
+// runRetention 依次执行所有策略。已有清理在执行时返回错误。
+func runRetention(cfg *RetentionConfig, dryRun bool) (*RetentionReport, error) {
+	if !retentionMu.TryLock() {
+		return nil, fmt.Errorf("a retention run is already in progress")
+	}
+	defer retentionMu.Unlock()
+
+	report := &RetentionReport{DryRun: dryRun, StartedAt: time.Now(), Results: []RetentionResult{}}
+	for _, p := range cfg.Policies {
+		res := applyRetentionPolicy(cfg, p, dryRun, report.StartedAt)
+		if res.Error != "" {
+			log.Errorf("Retention policy %s failed: %s", p.Name, res.Error)
+		} else if dryRun {
+			log.Infof("Retention policy %s (dry run): %d %s rows would be deleted", p.Name, res.Matched, p.Table)
+		} else if res.Deleted > 0 {
+			log.Infof("Retention policy %s: deleted %d %s rows", p.Name, res.Deleted, p.Table)
+		}
+		report.Results = append(report.Results, res)
+	}
+	report.FinishedAt = time.Now()
+
+	lastRetentionMu.Lock()
+	lastRetentionReport = report
+	lastRetentionMu.Unlock()
+	return report, nil
+}
+
+// retentionSummary 汇总报告中删除的记录数，用于审计日志
+func retentionSummary(report *RetentionReport) string {
+	var parts []string
+	for _, r := range report.Results {
+		part := fmt.Sprintf("%s=%d", r.Policy, r.Deleted)
+		if r.Error != "" {
+			part += "(error)"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// startRetention 按配置的间隔在后台执行保留策略，未配置策略时不启动
+func startRetention() {
+	cfg := retentionConfig
+	if len(cfg.Policies) == 0 {
+		log.Info("No retention policies configured, keeping all messages and calls")
+		return
+	}
+	log.Infof("Retention enabled: %d policies, every %d hours, dry_run=%v", len(cfg.Policies), cfg.IntervalHours, cfg.DryRun)
+	runBackground(func() {
+		for {
+			report, err := runRetention(cfg, cfg.DryRun)
+			if err != nil {
+				log.Warnf("Retention skipped: %v", err)
+			} else if !cfg.DryRun {
+				e := AuditEntry{Actor: "system", ActorType: "system", Action: AuditRetentionPurge, Target: "retention", Result: "success", Detail: retentionSummary(report)}
+				if err := insertAuditLog(e); err != nil {
+					log.Errorf("Failed to record audit log %s: %v", AuditRetentionPurge, err)
+				}
+			}
+			if !sleepContext(time.Duration(cfg.IntervalHours) * time.Hour) {
+				return
+			}
+		}
+	})
+}
+
+// getRetentionHandler 返回保留策略配置和最近一次执行的报告
+func getRetentionHandler(c *gin.Context) {
+	lastRetentionMu.RLock()
+	report := lastRetentionReport
+	lastRetentionMu.RUnlock()
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: gin.H{"config": retentionConfig, "last_run": report}})
+}
+
+// runRetentionHandler 立即执行一次保留策略，dry_run=true 时只返回将被删除的数量
+func runRetentionHandler(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+	report, err := runRetention(retentionConfig, dryRun)
+	if err != nil {
+		c.JSON(http.StatusConflict, APIResponse{Success: false, Message: err.Error()})
+		return
+	}
+	if !dryRun {
+		recordAudit(c, AuditRetentionPurge, "retention", true, retentionSummary(report))
+	}
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: report})
+}
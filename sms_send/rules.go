@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 转发事件类型，规则通过 events 字段声明自己处理哪些事件
+const (
+	EventSMS          = "sms"
+	EventIncomingCall = "incoming_call" // 来电响铃时
+	EventAnsweredCall = "answered_call" // 挂机后，已接听
+	EventMissedCall   = "missed_call"   // 挂机后，未接听
+	EventUSSD         = "ussd"
+	EventDeviceAlert  = "device_alert"
+)
+
+var allEventKinds = []string{EventSMS, EventIncomingCall, EventAnsweredCall, EventMissedCall, EventUSSD, EventDeviceAlert}
+
+// defaultEventKinds 是未声明 events 的 type: all 规则接收的事件。answered_call 与响铃时的 incoming_call
+// 是同一通电话，不默认接收，需要通话时长的规则在 events 中显式声明
+var defaultEventKinds = []string{EventSMS, EventIncomingCall, EventMissedCall, EventUSSD, EventDeviceAlert}
+
+// ForwardEvent 是一个需要匹配转发规则的事件
+type ForwardEvent struct {
+	Kind     string
+	Number   string // 短信发送人或来电号码
+	Text     string // 短信/USSD 内容、告警内容；来电事件为来电号码
+	CallType string
+	Duration int
+	PhoneID  string
+}
+
+// ForwardRule 是 forward.yaml 中的一条转发规则
+type ForwardRule struct {
+	Name        string
+	Settings    map[string]interface{}
+	RuleType    string // all / keyword / regex
+	Rule        string
+	Events      map[string]bool
+	Callers     []string // 来电号码，以 * 结尾表示前缀匹配
+	CallTypes   []string
+	MinDuration int
+	MaxDuration int // 0 表示不限制
+}
+
+var (
+	forwardRules []*ForwardRule
+	// forwardRuleErrors 是加载时被跳过的规则及原因，由 /readyz 报告
+	forwardRuleErrors []string
+)
+
+// loadForwardRules 从转发配置中解析出所有规则，按名称排序保证匹配顺序稳定，同时返回被跳过的规则
+func loadForwardRules(cfg map[string]interface{}) ([]*ForwardRule, []string) {
+	names := make([]string, 0, len(cfg))
+	for name := range cfg {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rules []*ForwardRule
+	var errs []string
+	for _, name := range names {
+		settings, ok := cfg[name].(map[string]interface{})
+		if !ok {
+			log.Warnf("配置格式错误: %s", name)
+			errs = append(errs, name+": not a mapping")
+			continue
+		}
+		rule, err := parseForwardRule(name, settings)
+		if err != nil {
+			log.Warnf("规则配置错误: %s: %v", name, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, errs
+}
+
+func parseForwardRule(name string, settings map[string]interface{}) (*ForwardRule, error) {
+	r := &ForwardRule{Name: name, Settings: settings, Events: map[string]bool{}}
+
+	var ok bool
+	if r.RuleType, ok = settings["type"].(string); !ok {
+		return nil, fmt.Errorf("missing type")
+	}
+	if r.Rule, ok = settings["rule"].(string); !ok {
+		return nil, fmt.Errorf("missing rule")
+	}
+	if r.RuleType == "regex" {
+		if _, err := regexp.Compile(r.Rule); err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", r.Rule, err)
+		}
+	}
+
+	events := configStringList(settings["events"])
+	if len(events) == 0 {
+		// 未声明 events 时：type=all 的规则接收除 answered_call 外的所有事件，关键字/正则规则只匹配短信
+		if r.RuleType == "all" {
+			events = defaultEventKinds
+		} else {
+			events = []string{EventSMS}
+		}
+	}
+	for _, ev := range events {
+		if !isEventKind(ev) {
+			return nil, fmt.Errorf("unknown event %q", ev)
+		}
+		r.Events[ev] = true
+	}
+
+	r.Callers = configStringList(settings["callers"])
+	r.CallTypes = configStringList(settings["call_types"])
+	r.MinDuration = configInt(settings["min_duration"])
+	r.MaxDuration = configInt(settings["max_duration"])
+	return r, nil
+}
+
+// Matches 判断事件是否命中该规则
+func (r *ForwardRule) Matches(ev ForwardEvent) bool {
+	if !r.Events[ev.Kind] {
+		return false
+	}
+	if isCallEvent(ev.Kind) {
+		if len(r.Callers) > 0 && !matchNumber(r.Callers, ev.Number) {
+			return false
+		}
+		if len(r.CallTypes) > 0 && !containsString(r.CallTypes, ev.CallType) {
+			return false
+		}
+		if ev.Duration < r.MinDuration || (r.MaxDuration > 0 && ev.Duration > r.MaxDuration) {
+			return false
+		}
+	}
+	return shouldSendNotification(r.RuleType, r.Rule, ev.Text)
+}
+
+// matchingRules 返回所有命中事件的规则
+func matchingRules(ev ForwardEvent) []*ForwardRule {
+	var matched []*ForwardRule
+	for _, r := range forwardRules {
+		if r.Matches(ev) {
+			matched = append(matched, r)
+		}
+	}
+	return matched
+}
+
+// callEventKind 将推送中的通话类型映射为事件类型。asterisk 响铃时推送 ringing，挂机后推送 answered 或 missed；
+// 呼出、拒接等其他类型不触发转发规则，返回空字符串
+func callEventKind(callType string) string {
+	switch strings.ToLower(callType) {
+	case "ringing", "incoming", "incoming_call":
+		return EventIncomingCall
+	case "answered", "answered_call":
+		return EventAnsweredCall
+	case "missed", "missed_call":
+		return EventMissedCall
+	}
+	return ""
+}
+
+func isCallEvent(kind string) bool {
+	return kind == EventIncomingCall || kind == EventAnsweredCall || kind == EventMissedCall
+}
+
+func isEventKind(kind string) bool {
+	return containsString(allEventKinds, kind)
+}
+
+func matchNumber(patterns []string, number string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(number, prefix) {
+				return true
+			}
+		} else if p == number {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// configStringList 读取逗号分隔的字符串或 YAML 列表
+func configStringList(v interface{}) []string {
+	var out []string
+	switch val := v.(type) {
+	case string:
+		for _, s := range strings.Split(val, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				out = append(out, s)
+			}
+		}
+	case []interface{}:
+		for _, item := range val {
+			if s := strings.TrimSpace(fmt.Sprint(item)); s != "" {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// configInt 读取整数配置，兼容 YAML 中写成字符串的数字
+func configInt(v interface{}) int {
+	switch val := v.(type) {
+	case int:
+		return val
+	case int64:
+		return int(val)
+	case float64:
+		return int(val)
+	case string:
+		n, _ := strconv.Atoi(strings.TrimSpace(val))
+		return n
+	}
+	return 0
+}
+
+// configBool 读取布尔配置，兼容 YAML 中写成字符串的值
+func configBool(v interface{}) bool {
+	switch val := v.(type) {
+	case bool:
+		return val
+	case string:
+		b, _ := strconv.ParseBool(strings.TrimSpace(val))
+		return b
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// snippetRadius 摘要中关键词前后保留的字符数
+const snippetRadius = 40
+
+// SearchResult 是一条搜索结果，Snippet 为已转义的 HTML，关键词用 <mark> 标出
+type SearchResult struct {
+	SMSMessage
+	LocalNumber string `json:"local_number"`
+	OtherParty  string `json:"other_party"`
+	Snippet     string `json:"snippet"`
+}
+
+// searchTerms 把查询字符串按空白拆分为关键词，每个关键词都需命中
+func searchTerms(q string) []string {
+	return strings.Fields(q)
+}
+
+// fullTextPhrase 去掉关键词中 MySQL 布尔模式的特殊字符，剩下的片段作为一个短语按顺序匹配，
+// 如 138-0000 匹配短语 "138 0000"。只用于全文搜索，LIKE 查询使用原始关键词。
+func fullTextPhrase(term string) []string {
+	return strings.FieldsFunc(term, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`+-<>()~*"@`, r)
+	})
+}
+
+// likeCondition 生成所有关键词都需命中的 LIKE 条件，使用 ! 作为转义符以兼容 MySQL 和 SQLite
+func likeCondition(terms []string) (string, []interface{}) {
+	var cond string
+	var args []interface{}
+	escaper := strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+	for _, t := range terms {
+		cond += " AND body LIKE ? ESCAPE '!'"
+		args = append(args, "%"+escaper.Replace(t)+"%")
+	}
+	return cond, args
+}
+
+// highlightSnippet 截取第一个命中关键词附近的文字，并用 <mark> 标出所有关键词
+func highlightSnippet(body string, terms []string) string {
+	runes := []rune(body)
+	lower := lowerRunes(body)
+	lowerTerms := make([][]rune, 0, len(terms))
+	for _, t := range terms {
+		lowerTerms = append(lowerTerms, lowerRunes(t))
+	}
+
+	start := 0
+	first := -1
+	for _, t := range lowerTerms {
+		if i := runeIndex(lower, t); i >= 0 && (first < 0 || i < first) {
+			first = i
+		}
+	}
+	if first >= 0 {
+		start = max(0, first-snippetRadius)
+	}
+	end := min(len(runes), start+2*snippetRadius+20)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	for i := start; i < end; {
+		matched := 0
+		for _, t := range lowerTerms {
+			if len(t) > matched && i+len(t) <= end && runeIndex(lower[i:i+len(t)], t) == 0 {
+				matched = len(t)
+			}
+		}
+		if matched > 0 {
+			b.WriteString("<mark>" + html.EscapeString(string(runes[i:i+matched])) + "</mark>")
+			i += matched
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[i])))
+		i++
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// lowerRunes 逐字符转换为小写，保证与原文的字符位置一一对应
+func lowerRunes(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+// runeIndex 返回 sub 在 s 中第一次出现的位置，未找到返回 -1
+func runeIndex(s, sub []rune) int {
+	if len(sub) == 0 {
+		return -1
+	}
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if string(s[i:i+len(sub)]) == string(sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseSearchTime 解析 since/until 参数，支持 RFC3339 和 2006-01-02；日期形式的 until 包含当天
+func parseSearchTime(v string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+// searchSMSHandler 全文搜索短信正文，支持方向、发送方、设备、状态和时间范围过滤
+func searchSMSHandler(c *gin.Context) {
+	terms := searchTerms(c.Query("q"))
+	if len(terms) == 0 {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Missing search query q"})
+		return
+	}
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 200 {
+		limit = 20
+	}
+
+	// 设备权限、回收站以及 device/phone_id/local 参数与会话列表一致
+	q := SearchQuery{
+		Scope:     smsScope(c),
+		Terms:     terms,
+		Direction: c.Query("direction"),
+		Status:    c.Query("status"),
+		Sender:    c.Query("sender"),
+		Limit:     limit,
+		Offset:    (page - 1) * limit,
+	}
+	for _, f := range []struct {
+		param    string
+		dst      **time.Time
+		endOfDay bool
+	}{{"since", &q.Since, false}, {"until", &q.Until, true}} {
+		if v := c.Query(f.param); v != "" {
+			t, err := parseSearchTime(v, f.endOfDay)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: f.param + " must be RFC3339 or YYYY-MM-DD"})
+				return
+			}
+			*f.dst = &t
+		}
+	}
+
+	results, total, err := store.SMS.Search(q)
+	if err != nil {
+		log.Errorf("Error searching messages: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Search failed"})
+		return
+	}
+	for i := range results {
+		results[i].Device = devices.DeviceFor(results[i].PhoneID)
+		results[i].Snippet = highlightSnippet(results[i].Body, terms)
+	}
+
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: results, Total: total})
+}
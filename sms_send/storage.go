@@ -0,0 +1,273 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 存储后端，通过 STORAGE_BACKEND 环境变量选择
+const (
+	StorageMySQL  = "mysql"
+	StorageSQLite = "sqlite"
+)
+
+// SMSScope 限定短信查询范围。
+// PhoneIDs 是当前用户可访问设备的所有 phone_id 别名，为空表示不限；LocalNumber/PhoneID 来自请求的 SIM 过滤参数。
+type SMSScope struct {
+	PhoneIDs    []string
+	LocalNumber string
+	PhoneID     string
+}
+
+// Cursor 是按 (时间, id) 倒序翻页的位置，取该位置之前（更早）的记录
+type Cursor struct {
+	Time time.Time
+	ID   int
+}
+
+// ConversationQuery 会话列表查询，View 为 inbox/archived/all；Before 为空时使用 Offset 分页
+type ConversationQuery struct {
+	Scope  SMSScope
+	View   string
+	Limit  int
+	Offset int
+	Before *Cursor
+}
+
+// MessageQuery 查询与某个号码的消息，按时间倒序返回
+type MessageQuery struct {
+	Scope      SMSScope
+	OtherParty string
+	Limit      int
+	Before     *Cursor
+}
+
+// SearchQuery 短信全文搜索
+type SearchQuery struct {
+	Scope     SMSScope
+	Terms     []string
+	Direction string
+	Status    string
+	Sender    string
+	Since     *time.Time
+	Until     *time.Time
+	Limit     int
+	Offset    int
+}
+
+// ExportQuery 导出短信或通话记录的条件，Number 为对方号码
+type ExportQuery struct {
+	Scope  SMSScope
+	Number string
+	Since  *time.Time
+	Until  *time.Time
+}
+
+// SMSRepository 是短信记录、会话汇总和已读/回收站状态的存储
+type SMSRepository interface {
+	Insert(msg SMSMessage) (int64, error)
+	Conversations(q ConversationQuery) ([]Conversation, int, error)
+	Messages(q MessageQuery) ([]SMSMessage, error)
+	Search(q SearchQuery) ([]SearchResult, int, error)
+	MarkConversationRead(scope SMSScope, otherParty string) (int64, error)
+	UpdateConversation(scope SMSScope, action string, key ConversationKey) (int64, error)
+	UpdateMessages(scope SMSScope, action string, ids []int) (int64, error)
+	Trash(scope SMSScope, limit, offset int) ([]SMSMessage, int, error)
+	EmptyTrash(scope SMSScope) (int64, error)
+	UnknownLocalPhoneIDs() ([]string, error)
+	// LastSent 返回这些 phone_id 最近一条发送成功的短信的时间，to 不为空时只看发给该号码的短信
+	LastSent(phoneIDs []string, to string) (time.Time, bool, error)
+	SetLocalNumber(phoneID, number string) (incoming, outgoing int64, err error)
+	RebuildConversations() error
+	EnsureConversations() error
+	// RetentionCandidates 按 id 顺序返回 afterID 之后、早于 before 的消息；trash 为 true 时按移入回收站的时间筛选回收站中的消息
+	RetentionCandidates(before time.Time, trash bool, afterID, limit int) ([]SMSMessage, error)
+	// DeleteByIDs 彻底删除消息并更新会话汇总
+	DeleteByIDs(ids []int) (int64, error)
+	// CountExport 和 Export 按时间顺序导出回收站以外的消息，Export 逐条回调以免一次读入全部数据
+	CountExport(q ExportQuery) (int, error)
+	Export(q ExportQuery, fn func(SMSMessage) error) error
+	// Import 写入带原始时间的历史消息，已存在的相同消息跳过，返回实际写入的数量
+	Import(msgs []SMSMessage) (int, error)
+	// Backfill 补录服务未运行期间漏记的短信并标记为 backfilled，返回补录（dryRun 时为缺失）的数量。
+	// 同一号码、相同正文且时间相差不超过 window 的消息视为已存在。
+	Backfill(msgs []SMSMessage, window time.Duration, dryRun bool) (int, error)
+}
+
+// CallRecord 是一条通话记录
+type CallRecord struct {
+	ID              int       `json:"id"`
+	CallType        string    `json:"call_type"`
+	PhoneNumber     string    `json:"phone_number"`
+	ContactName     string    `json:"contact_name"`
+	DurationSeconds int       `json:"duration_seconds"`
+	CallTime        string    `json:"call_time"`
+	PhoneID         string    `json:"phone_id"`
+	Source          string    `json:"source"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// CallRepository 是通话记录的存储
+type CallRepository interface {
+	Insert(call CallRecord) (int64, error)
+	// OlderThan 按 id 顺序返回 afterID 之后、早于 before 的通话记录
+	OlderThan(before time.Time, afterID, limit int) ([]CallRecord, error)
+	DeleteByIDs(ids []int) (int64, error)
+	CountExport(q ExportQuery) (int, error)
+	Export(q ExportQuery, fn func(CallRecord) error) error
+	Import(calls []CallRecord) (int, error)
+}
+
+// Contact 是号码对应的联系人名称
+type Contact struct {
+	Number    string    `json:"number"`
+	Name      string    `json:"name"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ContactRepository 是联系人的存储
+type ContactRepository interface {
+	List() ([]Contact, error)
+	Names(numbers []string) (map[string]string, error)
+	Upsert(c Contact) error
+	Delete(number string) (bool, error)
+}
+
+// BalanceRecord 是一次 SIM 卡余额查询的结果
+type BalanceRecord struct {
+	ID     int    `json:"id"`
+	Device string `json:"device"`
+	Method string `json:"method"`
+	// Balance 为空表示回复中没有解析出余额
+	Balance   *float64  `json:"balance"`
+	ExpiresOn string    `json:"expires_on,omitempty"`
+	Reply     string    `json:"reply"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BalanceRepository 是余额查询结果的存储
+type BalanceRepository interface {
+	Insert(rec BalanceRecord) (int64, error)
+	Latest() ([]BalanceRecord, error)
+	History(device string, limit int) ([]BalanceRecord, error)
+}
+
+// KeepAliveRecord 是一次保活操作
+type KeepAliveRecord struct {
+	ID        int       `json:"id"`
+	Device    string    `json:"device"`
+	Method    string    `json:"method"` // ussd 或 sms
+	Target    string    `json:"target"` // USSD 代码或短信接收号码
+	Status    string    `json:"status"` // sent 或 failed
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// KeepAliveRepository 是保活记录的存储
+type KeepAliveRepository interface {
+	Insert(rec KeepAliveRecord) (int64, error)
+	// Latest 返回每个设备最近一次保活记录
+	Latest() ([]KeepAliveRecord, error)
+	// LastSent 返回设备最近一次成功保活的时间
+	LastSent(device string) (time.Time, bool, error)
+}
+
+// TelegramMessage 是转发到 Telegram 的一条短信通知，用户回复该消息时发送短信给 Number
+type TelegramMessage struct {
+	BotID     string
+	ChatID    string
+	MessageID int64
+	Device    string
+	Number    string
+	CreatedAt time.Time
+}
+
+// TelegramRepository 是 Telegram 消息与短信发送人对应关系的存储
+type TelegramRepository interface {
+	Insert(m TelegramMessage) error
+	// Lookup 返回 Telegram 消息对应的短信，不存在时 ok 为 false
+	Lookup(botID, chatID string, messageID int64) (TelegramMessage, bool, error)
+	// Prune 删除 before 之前的记录
+	Prune(before time.Time) (int64, error)
+}
+
+// sqlDialect 封装不同数据库之间的差异：连接、迁移、锁、时间参数和全文搜索
+type sqlDialect interface {
+	Name() string
+	// Migrations 返回该数据库使用的迁移文件目录
+	Migrations() (fs.FS, error)
+	TableExists(table string) (bool, error)
+	// LockMigrations 在 conn 上获取迁移锁，返回释放函数
+	LockMigrations(conn *sql.Conn) (func(), error)
+	// ColumnExists 和 IndexExists 供迁移在添加列或索引前检查，使这类语句可以重复执行
+	ColumnExists(table, column string) (bool, error)
+	IndexExists(table, index string) (bool, error)
+	// TimeArg 把时间转换为与该数据库中时间列可比较的参数
+	TimeArg(t time.Time) interface{}
+	// SearchCondition 生成正文匹配全部关键词的条件
+	SearchCondition(terms []string) (string, []interface{})
+	// AfterMigrate 执行迁移之外的可选初始化，如创建全文索引
+	AfterMigrate()
+}
+
+// Store 是选定的存储后端
+type Store struct {
+	DB        *sql.DB
+	Dialect   sqlDialect
+	SMS       SMSRepository
+	Calls     CallRepository
+	Contacts  ContactRepository
+	Balances  BalanceRepository
+	KeepAlive KeepAliveRepository
+	Telegram  TelegramRepository
+}
+
+var store *Store
+
+// storageBackend 返回配置的存储后端，默认使用 FreePBX 的 MySQL
+func storageBackend() string {
+	if v := os.Getenv("STORAGE_BACKEND"); v != "" {
+		return v
+	}
+	return StorageMySQL
+}
+
+// openStore 按配置连接数据库并创建各个 repository
+func openStore(cfg *DBConfig) (*Store, error) {
+	var conn *sql.DB
+	var dialect sqlDialect
+	var err error
+	switch cfg.Backend {
+	case StorageMySQL:
+		conn, err = openMySQL(cfg)
+		if err == nil {
+			dialect = &mysqlDialect{db: conn}
+		}
+	case StorageSQLite:
+		conn, err = openSQLite(cfg.SQLitePath)
+		if err == nil {
+			dialect = &sqliteDialect{db: conn}
+		}
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q, expected %s or %s", cfg.Backend, StorageMySQL, StorageSQLite)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Using %s storage backend", dialect.Name())
+	return &Store{
+		DB:        conn,
+		Dialect:   dialect,
+		SMS:       &sqlSMSRepository{db: conn, dialect: dialect},
+		Calls:     &sqlCallRepository{db: conn, dialect: dialect},
+		Contacts:  &sqlContactRepository{db: conn, dialect: dialect},
+		Balances:  &sqlBalanceRepository{db: conn, dialect: dialect},
+		KeepAlive: &sqlKeepAliveRepository{db: conn, dialect: dialect},
+		Telegram:  &sqlTelegramRepository{db: conn, dialect: dialect},
+	}, nil
+}
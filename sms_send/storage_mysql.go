@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	_ "github.com/go-sql-driver/mysql"
+	log "github.com/sirupsen/logrus"
+)
+
+// migrationLockName 是多个实例同时启动时用于串行执行迁移的 MySQL 命名锁
+const migrationLockName = "sms_gateway_schema_migrations"
+
+// ngram 分词的最小长度，与 MySQL 默认的 ngram_token_size 一致；更短的关键词走 LIKE 查询
+const ngramTokenSize = 2
+
+// mysqlDialect 是 FreePBX 自带 MySQL/MariaDB 的实现
+type mysqlDialect struct {
+	db *sql.DB
+	// fullText 表示 sms_log.body 上的 ngram 全文索引可用
+	fullText bool
+}
+
+// openMySQL 连接 MySQL，启动时数据库可能尚未就绪，失败后重试
+func openMySQL(cfg *DBConfig) (*sql.DB, error) {
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", cfg.Username, cfg.Password, cfg.Host, cfg.Port, cfg.DBName)
+
+	var conn *sql.DB
+	var err error
+	for i := 0; i < 10; i++ {
+		conn, err = sql.Open("mysql", dsn)
+		if err == nil {
+			err = conn.Ping()
+			if err == nil {
+				log.Println("Successfully connected to the database.")
+				return conn, nil
+			}
+		}
+		log.Printf("Failed to connect to database, retrying in 5 seconds... (Attempt %d/10)", i+1)
+		time.Sleep(5 * time.Second)
+	}
+	return nil, fmt.Errorf("could not connect to the database after several retries: %w", err)
+}
+
+func (d *mysqlDialect) Name() string { return StorageMySQL }
+
+func (d *mysqlDialect) Migrations() (fs.FS, error) {
+	return fs.Sub(migrationFiles, "migrations/mysql")
+}
+
+func (d *mysqlDialect) TableExists(table string) (bool, error) {
+	var n int
+	query := `SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?`
+	if err := d.db.QueryRow(query, table).Scan(&n); err != nil {
+		return false, fmt.Errorf("error checking table %s: %w", table, err)
+	}
+	return n > 0, nil
+}
+
+func (d *mysqlDialect) LockMigrations(conn *sql.Conn) (func(), error) {
+	ctx := context.Background()
+	var locked int
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 60)", migrationLockName).Scan(&locked); err != nil {
+		return nil, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	if locked != 1 {
+		return nil, fmt.Errorf("timed out waiting for migration lock, is another instance migrating?")
+	}
+	return func() { conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", migrationLockName) }, nil
+}
+
+func (d *mysqlDialect) ColumnExists(table, column string) (bool, error) {
+	var n int
+	query := `SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`
+	if err := d.db.QueryRow(query, table, column).Scan(&n); err != nil {
+		return false, fmt.Errorf("error checking column %s.%s: %w", table, column, err)
+	}
+	return n > 0, nil
+}
+
+func (d *mysqlDialect) IndexExists(table, index string) (bool, error) {
+	var n int
+	query := `SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?`
+	if err := d.db.QueryRow(query, table, index).Scan(&n); err != nil {
+		return false, fmt.Errorf("error checking index %s.%s: %w", table, index, err)
+	}
+	return n > 0, nil
+}
+
+func (d *mysqlDialect) TimeArg(t time.Time) interface{} { return t }
+
+// SearchCondition 关键词都不短于 ngram 长度且全文索引可用时使用 FULLTEXT 布尔模式，否则使用 LIKE
+func (d *mysqlDialect) SearchCondition(terms []string) (string, []interface{}) {
+	useFullText := d.fullText
+	phrases := make([]string, 0, len(terms))
+	for _, t := range terms {
+		words := fullTextPhrase(t)
+		if len(words) == 0 {
+			useFullText = false
+		}
+		for _, w := range words {
+			if utf8.RuneCountInString(w) < ngramTokenSize {
+				useFullText = false
+			}
+		}
+		phrases = append(phrases, strings.Join(words, " "))
+	}
+	if !useFullText {
+		return likeCondition(terms)
+	}
+	var b strings.Builder
+	for _, p := range phrases {
+		fmt.Fprintf(&b, `+"%s" `, p)
+	}
+	return " AND MATCH(body) AGAINST (? IN BOOLEAN MODE)", []interface{}{strings.TrimSpace(b.String())}
+}
+
+// AfterMigrate 为 sms_log.body 创建使用 ngram 分词的全文索引，便于搜索中文。
+// 索引不放在迁移中：MariaDB 不支持 ngram，失败时只记录警告，搜索退化为 LIKE 查询。
+func (d *mysqlDialect) AfterMigrate() {
+	log.Info("Verifying full-text index on sms_log.body, creating it may take a while on large tables...")
+	if _, err := d.ensureIndex("sms_log", "ft_sms_body", "FULLTEXT INDEX ft_sms_body (body) WITH PARSER ngram"); err != nil {
+		log.Warnf("Full-text index unavailable, falling back to LIKE search: %v", err)
+		return
+	}
+	d.fullText = true
+	log.Println("sms_log full-text index verified/created successfully.")
+}
+
+// ensureIndex 为已存在的表补充索引，definition 为 ALTER TABLE ... ADD 之后的部分，返回是否实际执行了添加
+func (d *mysqlDialect) ensureIndex(table, name, definition string) (bool, error) {
+	if exists, err := d.IndexExists(table, name); err != nil || exists {
+		return false, err
+	}
+	if _, err := d.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD %s", table, definition)); err != nil {
+		return false, fmt.Errorf("error adding index %s.%s: %w", table, name, err)
+	}
+	log.Printf("Added index %s.%s", table, name)
+	return true, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultSQLitePath 是未设置 SQLITE_PATH 时的数据库文件位置
+const defaultSQLitePath = "/data/sms_gateway.db"
+
+// sqliteTimeFormat 与 CURRENT_TIMESTAMP 写入的格式一致，保证时间列按字符串比较时结果正确
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// sqliteDialect 用于没有 FreePBX MySQL 的独立部署和测试，只支持单实例访问
+type sqliteDialect struct {
+	db *sql.DB
+}
+
+// openSQLite 打开（必要时创建）SQLite 数据库文件
+func openSQLite(path string) (*sql.DB, error) {
+	if path == "" {
+		path = defaultSQLitePath
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+	conn, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on")
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %s: %w", path, err)
+	}
+	log.Printf("Successfully opened sqlite database %s.", path)
+	return conn, nil
+}
+
+func (d *sqliteDialect) Name() string { return StorageSQLite }
+
+func (d *sqliteDialect) Migrations() (fs.FS, error) {
+	return fs.Sub(migrationFiles, "migrations/sqlite")
+}
+
+func (d *sqliteDialect) TableExists(table string) (bool, error) {
+	var n int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n); err != nil {
+		return false, fmt.Errorf("error checking table %s: %w", table, err)
+	}
+	return n > 0, nil
+}
+
+// LockMigrations SQLite 只由本进程访问，无需加锁
+func (d *sqliteDialect) LockMigrations(conn *sql.Conn) (func(), error) {
+	return func() {}, nil
+}
+
+func (d *sqliteDialect) ColumnExists(table, column string) (bool, error) {
+	var n int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&n); err != nil {
+		return false, fmt.Errorf("error checking column %s.%s: %w", table, column, err)
+	}
+	return n > 0, nil
+}
+
+func (d *sqliteDialect) IndexExists(table, index string) (bool, error) {
+	var n int
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND tbl_name = ? AND name = ?", table, index).Scan(&n); err != nil {
+		return false, fmt.Errorf("error checking index %s.%s: %w", table, index, err)
+	}
+	return n > 0, nil
+}
+
+func (d *sqliteDialect) TimeArg(t time.Time) interface{} {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+func (d *sqliteDialect) SearchCondition(terms []string) (string, []interface{}) {
+	return likeCondition(terms)
+}
+
+func (d *sqliteDialect) AfterMigrate() {}
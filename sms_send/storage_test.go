@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestStore 在临时目录中创建 SQLite 数据库并执行迁移，替换全局的 store 和 db
+func newTestStore(t *testing.T) {
+	t.Helper()
+	s, err := openStore(&DBConfig{Backend: StorageSQLite, SQLitePath: filepath.Join(t.TempDir(), "gw.db")})
+	if err != nil {
+		t.Fatalf("openStore: %v", err)
+	}
+	store, db = s, s.DB
+	t.Cleanup(func() { s.DB.Close() })
+	if err := runMigrations(); err != nil {
+		t.Fatalf("runMigrations: %v", err)
+	}
+}
+
+func TestMigrationsAreIdempotent(t *testing.T) {
+	newTestStore(t)
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runMigrations(); err != nil {
+		t.Fatalf("second runMigrations: %v", err)
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("applied %d migrations, want %d", len(applied), len(migrations))
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(migrations) {
+		t.Errorf("schema_migrations has %d rows after running twice, want %d", n, len(migrations))
+	}
+}
+
+func TestMigrationsResumeAfterPartialFailure(t *testing.T) {
+	newTestStore(t)
+	// 模拟 0005 之后的迁移已添加列和索引但未记录版本，重新执行时应跳过已存在的列和索引
+	if _, err := db.Exec("DELETE FROM schema_migrations WHERE version >= 5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := runMigrations(); err != nil {
+		t.Fatalf("rerun after partial migration: %v", err)
+	}
+	migrations, _ := loadMigrations()
+	if applied, _ := appliedMigrations(); len(applied) != len(migrations) {
+		t.Errorf("applied %d migrations after rerun, want %d", len(applied), len(migrations))
+	}
+}
+
+func TestMigrationBackfillOnlyForAddedColumn(t *testing.T) {
+	newTestStore(t)
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, stmt := range []string{
+		"CREATE TABLE fresh (id INTEGER, created_at TEXT)",
+		"CREATE TABLE legacy (id INTEGER, created_at TEXT, read_at TEXT)",
+		"INSERT INTO fresh VALUES (1, '2026-01-01 00:00:00')",
+		"INSERT INTO legacy VALUES (1, '2026-01-01 00:00:00', NULL)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, table := range []string{"fresh", "legacy"} {
+		added := map[string]bool{}
+		for _, stmt := range []string{
+			"ALTER TABLE " + table + " ADD COLUMN read_at TEXT",
+			"-- migrate:if-added " + table + ".read_at\nUPDATE " + table + " SET read_at = created_at",
+		} {
+			if _, err := applyStatement(ctx, conn, stmt, added); err != nil {
+				t.Fatalf("%s: %v", table, err)
+			}
+		}
+	}
+	var fresh, legacy *string
+	db.QueryRow("SELECT read_at FROM fresh").Scan(&fresh)
+	db.QueryRow("SELECT read_at FROM legacy").Scan(&legacy)
+	if fresh == nil {
+		t.Error("newly added column was not backfilled")
+	}
+	if legacy != nil {
+		t.Errorf("existing column was backfilled to %q, want it left unread", *legacy)
+	}
+
+	content, err := fs.ReadFile(migrationFiles, "migrations/mysql/0005_sms_read_state_and_trash.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range splitSQLStatements(string(content)) {
+		if strings.Contains(stmt, "SET read_at = created_at") && !ifAddedPattern.MatchString(stmt) {
+			t.Errorf("read_at backfill %q is not guarded by migrate:if-added", stmt)
+		}
+	}
+}
+
+func TestSMSConversationsAndSearch(t *testing.T) {
+	newTestStore(t)
+	msgs := []SMSMessage{
+		{Direction: "incoming", FromNumber: "13800000001", ToNumber: "+8618600000000", Body: "您的验证码是 123456", Status: "received", PhoneID: "quectel0"},
+		{Direction: "outgoing", FromNumber: "+8618600000000", ToNumber: "13800000001", Body: "收到，谢谢", Status: "sent", PhoneID: "quectel0"},
+		{Direction: "incoming", FromNumber: "10086", ToNumber: "+8618600000000", Body: "话费余额 12.50 元", Status: "received", PhoneID: "quectel0"},
+	}
+	for _, m := range msgs {
+		if _, err := store.SMS.Insert(m); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+	}
+
+	convs, total, err := store.SMS.Conversations(ConversationQuery{Limit: 10})
+	if err != nil {
+		t.Fatalf("Conversations: %v", err)
+	}
+	if total != 2 || len(convs) != 2 {
+		t.Fatalf("got %d conversations (total %d), want 2", len(convs), total)
+	}
+	byParty := map[string]Conversation{}
+	for _, c := range convs {
+		byParty[c.OtherParty] = c
+	}
+	c := byParty["13800000001"]
+	if c.TotalMessages != 2 || c.UnreadCount != 1 || c.LastMessage != "收到，谢谢" {
+		t.Errorf("conversation with 13800000001 = %+v, want 2 messages, 1 unread, last 收到，谢谢", c)
+	}
+	if _, err := store.SMS.MarkConversationRead(SMSScope{}, "13800000001"); err != nil {
+		t.Fatalf("MarkConversationRead: %v", err)
+	}
+	convs, _, _ = store.SMS.Conversations(ConversationQuery{Limit: 10})
+	for _, c := range convs {
+		if c.OtherParty == "13800000001" && c.UnreadCount != 0 {
+			t.Errorf("unread after MarkConversationRead = %d, want 0", c.UnreadCount)
+		}
+	}
+
+	results, total, err := store.SMS.Search(SearchQuery{Terms: searchTerms("验证码"), Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if total != 1 || len(results) != 1 || results[0].FromNumber != "13800000001" {
+		t.Fatalf("search 验证码 = %+v (total %d), want the message from 13800000001", results, total)
+	}
+	results, total, err = store.SMS.Search(SearchQuery{Terms: searchTerms("余额"), Direction: "outgoing", Limit: 10})
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if total != 0 || len(results) != 0 {
+		t.Errorf("search 余额 in outgoing = %d results, want 0", total)
+	}
+}
+
+func TestSearchKeepsRawTermsForLike(t *testing.T) {
+	newTestStore(t)
+	for _, body := range []string{"请回拨 138-0000", "138 号楼 0000 室", "邮件 a@b.com 已发送"} {
+		if _, err := store.SMS.Insert(SMSMessage{Direction: "incoming", FromNumber: "10086", ToNumber: "unknown", Body: body, Status: "received"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for q, want := range map[string]string{"138-0000": "请回拨 138-0000", "a@b.com": "邮件 a@b.com 已发送"} {
+		results, total, err := store.SMS.Search(SearchQuery{Terms: searchTerms(q), Limit: 10})
+		if err != nil {
+			t.Fatalf("Search(%q): %v", q, err)
+		}
+		if total != 1 || len(results) != 1 || results[0].Body != want {
+			t.Errorf("Search(%q) = %+v (total %d), want only %q", q, results, total, want)
+		}
+	}
+}
+
+func TestFullTextConditionDropsBooleanOperators(t *testing.T) {
+	d := &mysqlDialect{fullText: true}
+	tests := map[string]string{
+		`验证码 138-0000`: `+"验证码" +"138 0000"`,
+		`ab"cd +foo`:   `+"ab cd" +"foo"`,
+		`(xx) ~yy*`:    `+"xx" +"yy"`,
+	}
+	for q, want := range tests {
+		cond, args := d.SearchCondition(searchTerms(q))
+		if !strings.Contains(cond, "MATCH") || len(args) != 1 || args[0] != want {
+			t.Errorf("SearchCondition(%q) = %s %v, want MATCH against %s", q, cond, args, want)
+		}
+	}
+	// 只有运算符或片段短于 ngram 长度时改用 LIKE，使用原始关键词
+	cond, args := d.SearchCondition(searchTerms(`a@b.com @`))
+	if strings.Contains(cond, "MATCH") || len(args) != 2 || args[0] != "%a@b.com%" {
+		t.Errorf("SearchCondition(a@b.com @) = %s %v, want LIKE on the raw terms", cond, args)
+	}
+}
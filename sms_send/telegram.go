@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTelegramAPIURL = "https://api.telegram.org"
+	// telegramPollTimeout 是 getUpdates 长轮询的等待时间，单位为秒
+	telegramPollTimeout = 30
+	telegramRetryDelay  = 10 * time.Second
+	// telegramMessageDays 是转发消息与短信对应关系的保留天数，超过后不能再回复
+	telegramMessageDays = 30
+)
+
+// TelegramRequest Telegram 发送消息请求结构
+type TelegramRequest struct {
+	ChatID           string `json:"chat_id"`
+	Text             string `json:"text"`
+	ReplyToMessageID int64  `json:"reply_to_message_id,omitempty"`
+}
+
+// telegramResponse 是 Bot API 的通用响应
+type telegramResponse struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+type telegramUser struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+}
+
+type telegramMessage struct {
+	MessageID int64         `json:"message_id"`
+	From      *telegramUser `json:"from"`
+	Chat      struct {
+		ID int64 `json:"id"`
+	} `json:"chat"`
+	Text           string           `json:"text"`
+	ReplyToMessage *telegramMessage `json:"reply_to_message"`
+}
+
+type telegramUpdate struct {
+	UpdateID int64            `json:"update_id"`
+	Message  *telegramMessage `json:"message"`
+}
+
+// telegramBotID 返回 bot token 中冒号前的 bot ID，记录和日志中不保存完整 token
+func telegramBotID(botToken string) string {
+	id, _, _ := strings.Cut(botToken, ":")
+	return id
+}
+
+func telegramHTTPClient(proxyURL string, timeout time.Duration) (*http.Client, error) {
+	client := &http.Client{Timeout: timeout}
+	if proxyURL != "" {
+		proxy, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %w", proxyURL, err)
+		}
+		client.Transport = &http.Transport{Proxy: http.ProxyURL(proxy)}
+	}
+	return client, nil
+}
+
+// telegramCall 调用 Bot API 的 method，result 不为 nil 时解析返回结果。
+// 请求地址中包含 bot token，返回的错误不包含地址。
+func telegramCall(ctx context.Context, client *http.Client, apiURL, botToken, method string, payload, result interface{}) error {
+	if apiURL == "" {
+		apiURL = defaultTelegramAPIURL
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/bot%s/%s", strings.TrimRight(apiURL, "/"), botToken, method)
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("telegram %s: invalid api_url", method)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	var tr telegramResponse
+	if err := json.Unmarshal(data, &tr); err != nil || resp.StatusCode != http.StatusOK || !tr.OK {
+		return fmt.Errorf("telegram %s returned status %d: %s", method, resp.StatusCode, tr.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(tr.Result, result)
+	}
+	return nil
+}
+
+// sendTelegram 发送Telegram消息，支持代理，返回消息 ID
+func sendTelegram(apiURL, botToken, chatID, message, proxyURL string) (int64, error) {
+	client, err := telegramHTTPClient(proxyURL, 30*time.Second)
+	if err != nil {
+		log.Errorf("解析代理URL失败: %v", err)
+		return 0, err
+	}
+	if proxyURL != "" {
+		log.Infof("使用代理发送Telegram消息: %s", proxyURL)
+	}
+	var sent telegramMessage
+	if err := telegramCall(context.Background(), client, apiURL, botToken, "sendMessage", TelegramRequest{ChatID: chatID, Text: message}, &sent); err != nil {
+		log.Errorf("发送Telegram通知失败: %v", err)
+		return 0, err
+	}
+	log.Info("Telegram通知发送成功")
+	return sent.MessageID, nil
+}
+
+// recordTelegramMessage 记录转发到 Telegram 的短信通知，用户回复该消息时发回原发送人
+func recordTelegramMessage(botToken, chatID string, messageID int64, origin ForwardOrigin) {
+	m := TelegramMessage{
+		BotID:     telegramBotID(botToken),
+		ChatID:    chatID,
+		MessageID: messageID,
+		Device:    deviceName(origin.Device),
+		Number:    origin.Number,
+		CreatedAt: time.Now(),
+	}
+	if err := store.Telegram.Insert(m); err != nil {
+		log.Errorf("Failed to record telegram message: %v", err)
+	}
+}
+
+// TelegramBot 是开启了 reply 的 telegram 规则使用的 bot，多条规则使用同一个 bot 时合并
+type TelegramBot struct {
+	Token        string
+	APIURL       string
+	Proxy        string
+	AllowedChats []string // 允许回复的 chat ID：各规则的 chat_id 和 allowed_chats
+}
+
+// telegramReplyBots 从转发规则中收集开启了 reply 的 bot
+func telegramReplyBots(rules []*ForwardRule) []*TelegramBot {
+	bots := map[string]*TelegramBot{}
+	for _, r := range rules {
+		if notify, _ := r.Settings["notify"].(string); notify != "telegram" || !configBool(r.Settings["reply"]) {
+			continue
+		}
+		token, _ := r.Settings["bot_token"].(string)
+		chatID, _ := r.Settings["chat_id"].(string)
+		if token == "" || chatID == "" {
+			continue
+		}
+		bot := bots[token]
+		if bot == nil {
+			bot = &TelegramBot{Token: token}
+			bot.APIURL, _ = r.Settings["api_url"].(string)
+			bot.Proxy, _ = r.Settings["proxy"].(string)
+			bots[token] = bot
+		}
+		for _, id := range append([]string{chatID}, configStringList(r.Settings["allowed_chats"])...) {
+			if !containsString(bot.AllowedChats, id) {
+				bot.AllowedChats = append(bot.AllowedChats, id)
+			}
+		}
+	}
+	list := make([]*TelegramBot, 0, len(bots))
+	for _, bot := range bots {
+		list = append(list, bot)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Token < list[j].Token })
+	return list
+}
+
+// startTelegramReplies 为开启了 reply 的 bot 轮询 getUpdates，并定期清理过期的消息记录
+func startTelegramReplies() {
+	bots := telegramReplyBots(forwardRules)
+	if len(bots) == 0 {
+		return
+	}
+	for _, bot := range bots {
+		bot := bot
+		log.Infof("Telegram replies enabled for bot %s, allowed chats: %s", telegramBotID(bot.Token), strings.Join(bot.AllowedChats, ","))
+		runBackground(func() { pollTelegram(bot) })
+	}
+	runBackground(func() {
+		for {
+			n, err := store.Telegram.Prune(time.Now().AddDate(0, 0, -telegramMessageDays))
+			if err != nil {
+				log.Errorf("Failed to prune telegram messages: %v", err)
+			} else if n > 0 {
+				log.Infof("Pruned %d telegram messages older than %d days", n, telegramMessageDays)
+			}
+			if !sleepContext(24 * time.Hour) {
+				return
+			}
+		}
+	})
+}
+
+// pollTelegram 长轮询 getUpdates 直到停机。bot 设置了 webhook 时 getUpdates 会失败，需要先删除 webhook。
+func pollTelegram(bot *TelegramBot) {
+	client, err := telegramHTTPClient(bot.Proxy, (telegramPollTimeout+30)*time.Second)
+	if err != nil {
+		log.Errorf("Telegram bot %s: %v", telegramBotID(bot.Token), err)
+		return
+	}
+	var offset int64
+	for {
+		var updates []telegramUpdate
+		params := map[string]interface{}{"offset": offset, "timeout": telegramPollTimeout, "allowed_updates": []string{"message"}}
+		if err := telegramCall(appCtx, client, bot.APIURL, bot.Token, "getUpdates", params, &updates); err != nil {
+			if appCtx.Err() != nil {
+				return
+			}
+			log.Warnf("Telegram bot %s getUpdates failed: %v", telegramBotID(bot.Token), err)
+			if !sleepContext(telegramRetryDelay) {
+				return
+			}
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			if u.Message != nil {
+				handleTelegramMessage(client, bot, u.Message)
+			}
+		}
+		if appCtx.Err() != nil {
+			// 确认已处理的消息，避免重启后重复发送短信
+			if len(updates) > 0 {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				_ = telegramCall(ctx, client, bot.APIURL, bot.Token, "getUpdates", map[string]interface{}{"offset": offset, "timeout": 0}, nil)
+				cancel()
+			}
+			return
+		}
+	}
+}
+
+// handleTelegramMessage 把对短信通知的回复通过收到短信的设备发回原发送人，并在 Telegram 中回复发送结果
+func handleTelegramMessage(client *http.Client, bot *TelegramBot, msg *telegramMessage) {
+	chatID := strconv.FormatInt(msg.Chat.ID, 10)
+	if !containsString(bot.AllowedChats, chatID) {
+		log.Warnf("忽略来自未授权 Telegram 会话 %s 的消息", chatID)
+		return
+	}
+	respond := func(text string) {
+		req := TelegramRequest{ChatID: chatID, Text: text, ReplyToMessageID: msg.MessageID}
+		if err := telegramCall(context.Background(), client, bot.APIURL, bot.Token, "sendMessage", req, nil); err != nil {
+			log.Warnf("Telegram 回复失败: %v", err)
+		}
+	}
+	if msg.ReplyToMessage == nil {
+		respond("请回复（Reply）一条短信通知，回复内容会以短信发给原发送人")
+		return
+	}
+	text := strings.TrimSpace(msg.Text)
+	if text == "" {
+		respond("只支持回复文本消息")
+		return
+	}
+	orig, ok, err := store.Telegram.Lookup(telegramBotID(bot.Token), chatID, msg.ReplyToMessage.MessageID)
+	if err != nil {
+		log.Errorf("Telegram reply lookup failed: %v", err)
+		respond("查询原短信失败，请稍后重试")
+		return
+	}
+	if !ok {
+		respond(fmt.Sprintf("找不到该消息对应的短信，只能回复 %d 天内的短信通知", telegramMessageDays))
+		return
+	}
+
+	actor := chatID
+	if msg.From != nil {
+		actor = strconv.FormatInt(msg.From.ID, 10)
+		if msg.From.Username != "" {
+			actor = msg.From.Username
+		}
+	}
+	err = deliverSMS(orig.Device, orig.Number, text)
+	entry := AuditEntry{Actor: actor, ActorType: "telegram", Action: AuditTelegramReply, Target: orig.Number, Result: "success",
+		Detail: fmt.Sprintf("device=%s chat=%s length=%d", orig.Device, chatID, len([]rune(text)))}
+	if err != nil {
+		entry.Result, entry.Detail = "failure", entry.Detail+" error="+err.Error()
+	}
+	if auditErr := insertAuditLog(entry); auditErr != nil {
+		log.Errorf("Failed to record audit log %s: %v", AuditTelegramReply, auditErr)
+	}
+	if err != nil {
+		log.Errorf("Telegram reply to %s via %s failed: %v", orig.Number, orig.Device, err)
+		respond("短信发送失败: " + err.Error())
+		return
+	}
+	log.Infof("Telegram 回复已通过 %s 发送给 %s", orig.Device, orig.Number)
+	respond(fmt.Sprintf("已通过 %s 发送给 %s", orig.Device, orig.Number))
+}
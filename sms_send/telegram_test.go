@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeBotAPI 模拟 Bot API：getUpdates 先返回 updates，取完后取消 appCtx 结束轮询；sendMessage 记录发出的消息
+type fakeBotAPI struct {
+	mu      sync.Mutex
+	updates []telegramUpdate
+	sent    []TelegramRequest
+	offsets []int64
+}
+
+func newFakeBotAPI(t *testing.T, token string) (*fakeBotAPI, *httptest.Server) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	oldCtx := appCtx
+	appCtx = ctx
+	t.Cleanup(func() { cancel(); appCtx = oldCtx })
+
+	api := &fakeBotAPI{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		var result interface{}
+		switch r.URL.Path {
+		case "/bot" + token + "/getUpdates":
+			var params struct {
+				Offset int64 `json:"offset"`
+			}
+			json.NewDecoder(r.Body).Decode(&params)
+			api.offsets = append(api.offsets, params.Offset)
+			if len(api.updates) == 0 {
+				cancel()
+			}
+			result, api.updates = api.updates, nil
+		case "/bot" + token + "/sendMessage":
+			var req TelegramRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			api.sent = append(api.sent, req)
+			result = map[string]interface{}{"message_id": 100 + len(api.sent), "chat": map[string]int64{"id": -100}}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "description": "Not Found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+	}))
+	t.Cleanup(srv.Close)
+	return api, srv
+}
+
+// messages 返回 sendMessage 收到的消息
+func (api *fakeBotAPI) messages() []TelegramRequest {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return append([]TelegramRequest(nil), api.sent...)
+}
+
+func telegramReply(updateID, chatID, replyTo int64, text string) telegramUpdate {
+	msg := &telegramMessage{MessageID: 200 + updateID, From: &telegramUser{ID: 7, Username: "oncall"}, Text: text}
+	msg.Chat.ID = chatID
+	if replyTo != 0 {
+		msg.ReplyToMessage = &telegramMessage{MessageID: replyTo}
+		msg.ReplyToMessage.Chat.ID = chatID
+	}
+	return telegramUpdate{UpdateID: updateID, Message: msg}
+}
+
+func TestTelegramReplies(t *testing.T) {
+	newTestStore(t)
+	calls := fakeAsterisk(t, 0)
+	const token = "123:abc"
+	api, srv := newFakeBotAPI(t, token)
+
+	// 开启 reply 的规则转发短信后记录 Telegram 消息 ID 与原短信的对应关系
+	config := map[string]interface{}{"notify": "telegram", "bot_token": token, "chat_id": "-100", "api_url": srv.URL, "reply": true}
+	sms := SMSReciveRequest{Number: "13800000001", Time: "2026-10-19T10:00:00+0800", Text: "在吗", PhoneID: "quectel0"}
+	if err := sendNotification(config, sms.Number, "2026-10-19 10:00:00", sms.Text, "all", sms); err != nil {
+		t.Fatalf("sendNotification: %v", err)
+	}
+	if sent := api.messages(); len(sent) != 1 || sent[0].ChatID != "-100" {
+		t.Fatalf("forwarded messages = %+v, want one to chat -100", sent)
+	}
+	forwarded := int64(101)
+	if orig, ok, err := store.Telegram.Lookup("123", "-100", forwarded); err != nil || !ok || orig.Number != "13800000001" || orig.Device != "quectel0" {
+		t.Fatalf("Lookup(%d) = %+v %v %v, want 13800000001 on quectel0", forwarded, orig, ok, err)
+	}
+
+	api.mu.Lock()
+	api.updates = []telegramUpdate{
+		telegramReply(1, -200, forwarded, "不在白名单"),
+		telegramReply(2, -100, 0, "没有回复任何消息"),
+		telegramReply(3, -100, 999, "回复未知消息"),
+		telegramReply(4, -100, forwarded, "马上到"),
+	}
+	api.mu.Unlock()
+	bot := telegramReplyBots([]*ForwardRule{{Name: "tg", Settings: config}})[0]
+	pollTelegram(bot)
+
+	api.mu.Lock()
+	offsets := api.offsets
+	api.mu.Unlock()
+	if len(offsets) < 2 || offsets[1] != 5 {
+		t.Errorf("getUpdates offsets = %v, want the second poll to confirm offset 5", offsets)
+	}
+	replies := api.messages()[1:]
+	want := []struct {
+		replyTo int64
+		text    string
+	}{
+		{202, "请回复（Reply）一条短信通知"},
+		{203, "找不到该消息对应的短信"},
+		{204, "已通过 quectel0 发送给 13800000001"},
+	}
+	if len(replies) != len(want) {
+		t.Fatalf("bot replies = %+v, want %d (none to the disallowed chat)", replies, len(want))
+	}
+	for i, w := range want {
+		r := replies[i]
+		if r.ChatID != "-100" || r.ReplyToMessageID != w.replyTo || !strings.Contains(r.Text, w.text) {
+			t.Errorf("reply %d = %+v, want %q in reply to %d", i, r, w.text, w.replyTo)
+		}
+	}
+
+	out, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("asterisk was not called: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `quectel sms "quectel0" "13800000001" "马上到"`) {
+		t.Errorf("asterisk calls = %q, want only the reply 马上到 to 13800000001", out)
+	}
+	msgs, err := store.SMS.Messages(MessageQuery{OtherParty: "13800000001", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Direction != "outgoing" || msgs[0].Body != "马上到" || msgs[0].Status != "sent" {
+		t.Errorf("messages after reply = %+v, want one sent 马上到", msgs)
+	}
+	var actor, result string
+	if err := db.QueryRow("SELECT actor, result FROM audit_log WHERE action = ?", AuditTelegramReply).Scan(&actor, &result); err != nil {
+		t.Fatalf("telegram reply audit entry: %v", err)
+	}
+	if actor != "oncall" || result != "success" {
+		t.Errorf("audit entry = %s %s, want oncall success", actor, result)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+)
+
+// API token 权限范围
+const (
+	ScopeSMSSend = "sms:send"
+	ScopeSMSRead = "sms:read"
+	ScopeIngest  = "ingest"
+	ScopeAdmin   = "admin"
+)
+
+var allScopes = []string{ScopeSMSSend, ScopeSMSRead, ScopeIngest, ScopeAdmin}
+
+const apiTokenPrefix = "smsgw_"
+
+// APIToken 是一个给脚本、Asterisk 等机器客户端使用的访问令牌
+type APIToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // 令牌明文的前几位，便于辨认
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"created_by"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// APITokenRequest 创建令牌的请求结构
+type APITokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 表示永不过期
+}
+
+// roleScopes 登录用户按角色拥有的权限范围
+func roleScopes(role string) []string {
+	switch role {
+	case RoleAdmin:
+		return allScopes
+	case RoleOperator:
+		return []string{ScopeSMSSend, ScopeSMSRead}
+	case RoleReadOnly:
+		return []string{ScopeSMSRead}
+	}
+	return nil
+}
+
+// scopeRole 为令牌推导出一个等价角色，用于前端展示
+func scopeRole(scopes []string) string {
+	switch {
+	case containsString(scopes, ScopeAdmin):
+		return RoleAdmin
+	case containsString(scopes, ScopeSMSSend):
+		return RoleOperator
+	}
+	return RoleReadOnly
+}
+
+// HasScope 判断主体是否拥有指定权限，admin 拥有全部权限
+func (p *Principal) HasScope(scope string) bool {
+	return scope == "" || containsString(p.Scopes, scope) || containsString(p.Scopes, ScopeAdmin)
+}
+
+// legacySecretAuthEnabled 是否仍接受 FORWARD_SECRET 作为认证方式（X-Auth-Secret 请求头及推送 JSON 中的 secret 字段）
+func legacySecretAuthEnabled() bool {
+	return os.Getenv("LEGACY_SECRET_AUTH") != "false" && os.Getenv("FORWARD_SECRET") != ""
+}
+
+// createAPIToken 生成令牌并只保存其哈希，明文只在创建时返回一次
+func createAPIToken(req APITokenRequest, createdBy string) (string, *APIToken, error) {
+	raw, err := randomToken(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := apiTokenPrefix + raw
+
+	var expiresAt interface{} // 不过期时为 NULL
+	if req.ExpiresInDays > 0 {
+		expiresAt = store.Dialect.TimeArg(time.Now().AddDate(0, 0, req.ExpiresInDays))
+	}
+	res, err := db.Exec("INSERT INTO api_tokens (name, token_hash, token_prefix, scopes, created_by, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		req.Name, hashToken(token), token[:len(apiTokenPrefix)+6], strings.Join(req.Scopes, ","), createdBy, expiresAt)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to insert api token: %w", err)
+	}
+	id, _ := res.LastInsertId()
+	t, err := getAPIToken(int(id))
+	return token, t, err
+}
+
+const apiTokenColumns = "id, name, token_prefix, scopes, created_by, expires_at, last_used_at, revoked_at, created_at"
+
+func scanAPIToken(scanner interface{ Scan(...interface{}) error }) (*APIToken, error) {
+	var t APIToken
+	var scopes string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	if err := scanner.Scan(&t.ID, &t.Name, &t.Prefix, &scopes, &t.CreatedBy, &expiresAt, &lastUsedAt, &revokedAt, &t.CreatedAt); err != nil {
+		return nil, err
+	}
+	t.Scopes = configStringList(scopes)
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		t.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+func getAPIToken(id int) (*APIToken, error) {
+	return scanAPIToken(db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE id = ?", id))
+}
+
+func listAPITokens() ([]APIToken, error) {
+	rows, err := db.Query("SELECT " + apiTokenColumns + " FROM api_tokens ORDER BY id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api token: %w", err)
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+func revokeAPIToken(id int) error {
+	res, err := db.Exec("UPDATE api_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", store.Dialect.TimeArg(time.Now()), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api token: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// principalFromAPIToken 校验令牌并更新最后使用时间
+func principalFromAPIToken(token string) (*Principal, error) {
+	t, err := scanAPIToken(db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = ?", hashToken(token)))
+	if err != nil {
+		return nil, err
+	}
+	if t.RevokedAt != nil {
+		return nil, fmt.Errorf("token revoked")
+	}
+	if t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt) {
+		return nil, fmt.Errorf("token expired")
+	}
+	// 最后使用时间精确到分钟即可，避免每个请求都写库
+	if t.LastUsedAt == nil || time.Since(*t.LastUsedAt) > time.Minute {
+		if _, err := db.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", store.Dialect.TimeArg(time.Now()), t.ID); err != nil {
+			log.Warnf("Failed to update token last_used_at: %v", err)
+		}
+	}
+	return &Principal{
+		Username: "token:" + t.Name,
+		Role:     scopeRole(t.Scopes),
+		Scopes:   t.Scopes,
+		TokenID:  t.ID,
+	}, nil
+}
+
+// bearerToken 从 Authorization: Bearer 或 X-API-Token 请求头中读取令牌
+func bearerToken(c *gin.Context) string {
+	if v := c.GetHeader("Authorization"); strings.HasPrefix(v, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(v, "Bearer "))
+	}
+	return c.GetHeader("X-API-Token")
+}
+
+// resolvePrincipal 依次尝试 API 令牌、会话 cookie 和旧版 X-Auth-Secret
+func resolvePrincipal(c *gin.Context) (*Principal, bool) {
+	if token := bearerToken(c); token != "" {
+		p, err := principalFromAPIToken(token)
+		if err != nil {
+			log.Warnf("API token rejected from %s: %v", c.ClientIP(), err)
+			return nil, false
+		}
+		return p, true
+	}
+
+	if token, err := c.Cookie(sessionCookieName); err == nil && token != "" {
+		if p, err := principalFromSession(token); err == nil {
+			p.Scopes = roleScopes(p.Role)
+			return p, true
+		}
+	}
+
+	if secret := c.GetHeader("X-Auth-Secret"); secret != "" && legacySecretAuthEnabled() && validateSecret(secret) == nil {
+		return &Principal{Username: "secret", Role: RoleAdmin, Scopes: allScopes}, true
+	}
+	return nil, false
+}
+
+// authMiddleware 认证请求并要求主体拥有指定权限范围，scope 为空时只要求已登录。
+func authMiddleware(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, ok := resolvePrincipal(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, APIResponse{Success: false, Message: "Authentication failed"})
+			return
+		}
+		if !p.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, APIResponse{Success: false, Message: "Missing scope: " + scope})
+			return
+		}
+		c.Set(principalKey, p)
+		c.Next()
+	}
+}
+
+// ingestAuthMiddleware 用于推送接口：携带令牌时必须具备 ingest 权限；
+// 未携带令牌时交给处理函数按旧方式校验 JSON 中的 secret 字段，设置 LEGACY_SECRET_AUTH=false 后必须使用令牌。
+func ingestAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(principalKey); ok {
+			// 已通过签名校验
+			c.Next()
+			return
+		}
+		if bearerToken(c) != "" {
+			authMiddleware(ScopeIngest)(c)
+			return
+		}
+		if os.Getenv("LEGACY_SECRET_AUTH") == "false" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, APIResponse{Success: false, Message: "API token required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkIngestSecret 校验推送 JSON 中的 secret，已通过令牌认证的请求直接放行
+func checkIngestSecret(c *gin.Context, secret string) error {
+	if _, ok := c.Get(principalKey); ok {
+		return nil
+	}
+	return validateSecret(secret)
+}
+
+func listAPITokensHandler(c *gin.Context) {
+	tokens, err := listAPITokens()
+	if err != nil {
+		log.Errorf("Error listing api tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to list tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, APIResponse{Success: true, Data: tokens, Total: len(tokens)})
+}
+
+func createAPITokenHandler(c *gin.Context) {
+	var req APITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid request: " + err.Error()})
+		return
+	}
+	if req.Name == "" || len(req.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "name and scopes are required"})
+		return
+	}
+	for _, s := range req.Scopes {
+		if !containsString(allScopes, s) {
+			c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Unknown scope: " + s})
+			return
+		}
+	}
+
+	token, t, err := createAPIToken(req, currentPrincipal(c).Username)
+	if err != nil {
+		log.Errorf("Error creating api token: %v", err)
+		recordAudit(c, AuditTokenCreate, req.Name, false, err.Error())
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to create token"})
+		return
+	}
+	recordAudit(c, AuditTokenCreate, req.Name, true, "scopes="+strings.Join(req.Scopes, ","))
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "Token created, copy it now. It will not be shown again.", Data: gin.H{
+		"token": token,
+		"info":  t,
+	}})
+}
+
+func revokeAPITokenHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, APIResponse{Success: false, Message: "Invalid token id"})
+		return
+	}
+	if err := revokeAPIToken(id); err != nil {
+		recordAudit(c, AuditTokenRevoke, strconv.Itoa(id), false, err.Error())
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, APIResponse{Success: false, Message: "Token not found or already revoked"})
+			return
+		}
+		log.Errorf("Error revoking api token %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, APIResponse{Success: false, Message: "Failed to revoke token"})
+		return
+	}
+	recordAudit(c, AuditTokenRevoke, strconv.Itoa(id), true, "")
+	c.JSON(http.StatusOK, APIResponse{Success: true, Message: "Token revoked"})
+}
@@ -10,97 +10,146 @@ document.addEventListener('DOMContentLoaded', () => {
     }
 });
 
-async function checkAuthAndInit() {
-    const secret = localStorage.getItem('secret');
-    if (!secret) {
-        window.location.href = '/login';
-        return;
-    }
+let currentUser = null;
 
+async function checkAuthAndInit() {
     try {
-        const response = await fetch(`${apiBaseUrl}/auth/validate`, {
-            method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
-            body: JSON.stringify({ secret: secret })
-        });
-
-        if (response.status === 401) throw new Error('Server validation failed');
+        const response = await fetch(`${apiBaseUrl}/auth/me`);
+        if (response.status === 401) throw new Error('Not logged in');
 
         const result = await response.json();
-        if (result.success) {
-            const path = window.location.pathname;
-            if (path === '/' || path.endsWith('/index.html')) {
-                initConversationsPage();
-            } else if (path.startsWith('/conversation/')) {
-                initConversationDetailPage();
-            }
-        } else {
-            throw new Error('Invalid secret');
+        if (!result.success) throw new Error(result.message);
+
+        currentUser = result.data;
+        const path = window.location.pathname;
+        if (path === '/' || path.endsWith('/index.html')) {
+            initConversationsPage();
+        } else if (path.startsWith('/conversation/')) {
+            initConversationDetailPage();
+        } else if (path === '/tokens') {
+            initTokensPage();
+        } else if (path === '/devices') {
+            initDevicesPage();
         }
     } catch (error) {
         console.error('Authentication check failed:', error.message);
-        localStorage.removeItem('secret');
         window.location.href = '/login';
     }
 }
 
-function initLoginPage() {
-    const loginBtn = document.getElementById('login-btn');
-    const secretInput = document.getElementById('secret-input');
+function isAdmin() {
+    return currentUser && currentUser.role === 'admin';
+}
+
+const htmlEscapes = { '&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;' };
 
-    localStorage.removeItem('secret');
+// escapeHtml escapes text for element content and for quoted attribute values such as title="…".
+function escapeHtml(text) {
+    return (text == null ? '' : String(text)).replace(/[&<>"']/g, c => htmlEscapes[c]);
+}
+
+function formatTime(value) {
+    return value ? new Date(value).toLocaleString() : '-';
+}
+
+// loadDevices fills a <select> with the SIMs the current user can access.
+async function loadDevices(select, allLabel) {
+    const response = await makeAuthenticatedRequest(`${apiBaseUrl}/devices`);
+    const result = await response.json();
+    if (!result.success) throw new Error(result.message);
+
+    select.innerHTML = allLabel ? `<option value="">${escapeHtml(allLabel)}</option>` : '';
+    (result.data || []).forEach(d => {
+        const option = document.createElement('option');
+        option.value = d.device;
+        option.dataset.number = d.number || '';
+        option.textContent = d.number ? `${d.device} (${d.number})` : d.device;
+        select.appendChild(option);
+    });
+    return result.data || [];
+}
+
+// subscribeEvents opens the live event stream (/api/v1/events) and calls onEvent(type, event) for each event.
+// After the stream reconnects onEvent('reconnect') is called so the page can pick up anything it missed.
+// Returns a function telling whether the stream is connected, so pages can fall back to polling.
+function subscribeEvents(onEvent) {
+    if (!window.EventSource) return () => false;
+    let connected = false;
+    let opened = false;
+    const source = new EventSource(`${apiBaseUrl}/events`);
+    source.onopen = () => {
+        if (opened) onEvent('reconnect', null);
+        opened = true;
+        connected = true;
+    };
+    source.onerror = () => { connected = false; };
+    ['sms', 'sms_status', 'call', 'device', 'device_alert'].forEach(type => {
+        source.addEventListener(type, e => {
+            try {
+                onEvent(type, JSON.parse(e.data));
+            } catch (error) {
+                console.error('Invalid event:', error);
+            }
+        });
+    });
+    return () => connected;
+}
+
+function canSend() {
+    return currentUser && (currentUser.role === 'admin' || currentUser.role === 'operator');
+}
 
-    loginBtn.addEventListener('click', async () => {
-        const secret = secretInput.value;
-        if (!secret) {
-            alert('Please enter the secret key.');
+function initLoginPage() {
+    const loginBtn = document.getElementById('login-btn');
+    const usernameInput = document.getElementById('username-input');
+    const passwordInput = document.getElementById('password-input');
+
+    const login = async () => {
+        const username = usernameInput.value;
+        const password = passwordInput.value;
+        if (!username || !password) {
+            alert('Please enter username and password.');
             return;
         }
 
         try {
-            const response = await fetch(`${apiBaseUrl}/auth/validate`, {
+            const response = await fetch(`${apiBaseUrl}/auth/login`, {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ secret: secret })
+                body: JSON.stringify({ username, password })
             });
             const result = await response.json();
             if (result.success) {
-                localStorage.setItem('secret', secret);
                 window.location.href = '/';
             } else {
-                alert('Invalid secret key.');
+                alert('Invalid username or password.');
             }
         } catch (error) {
             console.error('Login error:', error);
             alert('An error occurred during login.');
         }
-    });
+    };
+
+    loginBtn.addEventListener('click', login);
+    passwordInput.addEventListener('keydown', (event) => { if (event.key === 'Enter') login(); });
 }
 
 async function makeAuthenticatedRequest(url, options = {}) {
-    const secret = localStorage.getItem('secret');
-    if (!secret) {
-        window.location.href = '/login';
-        return Promise.reject('No secret found.');
-    }
-
-    const headers = new Headers(options.headers || {});
-    headers.append('X-Auth-Secret', secret);
-    options.headers = headers;
-
     const response = await fetch(url, options);
 
     if (response.status === 401) {
-        localStorage.removeItem('secret');
         window.location.href = '/login';
-        return Promise.reject('Authentication failed.');
+        return Promise.reject(new Error('Authentication failed.'));
     }
     return response;
 }
 
-function logout() {
-    localStorage.removeItem('secret');
-    window.location.href = '/login';
+async function logout() {
+    try {
+        await fetch(`${apiBaseUrl}/auth/logout`, { method: 'POST' });
+    } finally {
+        window.location.href = '/login';
+    }
 }
 
 let currentPage = 1;
@@ -116,39 +165,199 @@ function initConversationsPage() {
     const logoutBtn = document.getElementById('logout-btn');
 
     if(logoutBtn) logoutBtn.addEventListener('click', logout);
+    if (!canSend()) newSmsBtn.style.display = 'none';
+    if (isAdmin()) document.getElementById('tokens-link').style.display = 'inline-block';
+
+    const deviceSelect = document.getElementById('device-select');
+    const sendDeviceSelect = document.getElementById('send-device-select');
+
+    const viewTabs = document.getElementById('view-tabs');
+    const bulkToolbar = document.getElementById('bulk-toolbar');
+    const selectAll = document.getElementById('select-all');
+    const searchInput = document.getElementById('search-input');
+    let currentView = 'inbox';
 
-    async function fetchConversations(page) {
+    // Conversation views page with a cursor ("Load more"); trash and search results use page numbers.
+    async function fetchConversations(page, cursor) {
         currentPage = page;
+        if (!cursor) selectAll.checked = false;
         try {
-            const response = await makeAuthenticatedRequest(`${apiBaseUrl}/sms/conversations?page=${page}&limit=${limit}`);
+            const device = deviceSelect.value;
+            const deviceParam = device ? `&device=${encodeURIComponent(device)}` : '';
+            const cursorParam = cursor ? `&cursor=${encodeURIComponent(cursor)}` : '';
+            let url = `${apiBaseUrl}/sms/conversations?limit=${limit}&view=${currentView}${deviceParam}${cursorParam}`;
+            if (currentView === 'trash') {
+                url = `${apiBaseUrl}/sms/trash?page=${page}&limit=${limit}`;
+            } else if (currentView === 'search') {
+                url = `${apiBaseUrl}/sms/search?page=${page}&limit=${limit}${deviceParam}&${searchParams()}`;
+            }
+            const response = await makeAuthenticatedRequest(url);
             const result = await response.json();
             if (!result.success) throw new Error(result.message);
 
-            conversationsList.innerHTML = '';
+            if (!cursor) conversationsList.innerHTML = '';
             if (result.data && result.data.length > 0) {
-                result.data.forEach(conv => {
-                    const div = document.createElement('div');
-                    div.className = 'conversation';
-                    div.innerHTML = `
-                        <span class="time">${new Date(conv.last_message_at).toLocaleString()}</span>
-                        <h3>${conv.other_party}</h3>
-                        <p>${conv.last_message}</p>
-                    `;
-                    div.onclick = () => { window.location.href = `/conversation/${conv.other_party}`; };
-                    conversationsList.appendChild(div);
-                });
-            } else {
+                const render = { trash: renderTrashItem, search: renderSearchResult }[currentView] || renderConversation;
+                result.data.forEach(item => conversationsList.appendChild(render(item)));
+            } else if (currentView === 'trash') {
+                conversationsList.innerHTML = '<p>Trash is empty.</p>';
+            } else if (currentView === 'search') {
+                conversationsList.innerHTML = '<p>No messages match your search.</p>';
+            } else if (!cursor) {
                 conversationsList.innerHTML = '<p>No conversations found.</p>';
             }
 
-            renderPagination(result.total, page, limit);
+            if (currentView === 'trash' || currentView === 'search') {
+                renderPagination(result.total, page, limit);
+            } else {
+                paginationContainer.innerHTML = result.next_cursor
+                    ? `<button data-cursor="${escapeHtml(result.next_cursor)}">Load more</button>`
+                    : '';
+            }
         } catch (error) {
-            if (error.message !== 'Authentication failed.' && error.message !== 'No secret found.') {
+            if (error.message !== 'Authentication failed.') {
                  conversationsList.innerHTML = `<p>Error loading conversations: ${error.message}</p>`;
             }
         }
     }
 
+    function renderConversation(conv) {
+        const div = document.createElement('div');
+        div.className = conv.unread_count > 0 ? 'conversation unread' : 'conversation';
+        const badge = conv.unread_count > 0 ? `<span class="unread-badge">${conv.unread_count}</span>` : '';
+        div.innerHTML = `
+            <input type="checkbox" class="select-box">
+            <span class="time">${new Date(conv.last_message_at).toLocaleString()}</span>
+            <h3>${escapeHtml(conv.other_party)}${badge} <span class="sim-tag">${escapeHtml(conv.device || conv.local_number)}</span></h3>
+            <p>${escapeHtml(conv.last_message)}</p>
+        `;
+        const checkbox = div.querySelector('.select-box');
+        checkbox.dataset.local = conv.local_number;
+        checkbox.dataset.other = conv.other_party;
+        checkbox.addEventListener('click', event => event.stopPropagation());
+        div.onclick = () => { window.location.href = conversationUrl(conv.other_party, conv.local_number); };
+        return div;
+    }
+
+    function renderTrashItem(msg) {
+        const div = document.createElement('div');
+        div.className = 'conversation';
+        const other = msg.direction === 'incoming' ? msg.from_number : msg.to_number;
+        div.innerHTML = `
+            <input type="checkbox" class="select-box">
+            <span class="time">Deleted ${formatTime(msg.deleted_at)}</span>
+            <h3>${escapeHtml(other)} <span class="sim-tag">${escapeHtml(msg.device || msg.phone_id)}</span></h3>
+            <p>${escapeHtml(msg.body)}</p>
+        `;
+        div.querySelector('.select-box').dataset.id = msg.id;
+        return div;
+    }
+
+    function searchParams() {
+        const params = new URLSearchParams({ q: searchInput.value.trim() });
+        const direction = document.getElementById('search-direction').value;
+        const since = document.getElementById('search-since').value;
+        const until = document.getElementById('search-until').value;
+        if (direction) params.set('direction', direction);
+        if (since) params.set('since', since);
+        if (until) params.set('until', until);
+        return params.toString();
+    }
+
+    // The snippet is escaped on the server, only <mark> tags are added around matches.
+    function renderSearchResult(msg) {
+        const div = document.createElement('div');
+        div.className = 'conversation';
+        const arrow = msg.direction === 'incoming' ? '&larr;' : '&rarr;';
+        div.innerHTML = `
+            <span class="time">${new Date(msg.created_at).toLocaleString()}</span>
+            <h3>${arrow} ${escapeHtml(msg.other_party)} <span class="sim-tag">${escapeHtml(msg.device || msg.local_number)}</span></h3>
+            <p class="snippet">${msg.snippet}</p>
+        `;
+        div.onclick = () => { window.location.href = conversationUrl(msg.other_party, msg.local_number); };
+        return div;
+    }
+
+    function setView(view) {
+        currentView = view;
+        viewTabs.querySelectorAll('button').forEach(btn => btn.classList.toggle('active', btn.dataset.view === view));
+        deviceSelect.style.display = view === 'trash' ? 'none' : '';
+        updateToolbar();
+        fetchConversations(1);
+    }
+
+    function startSearch() {
+        if (searchInput.value.trim()) {
+            setView('search');
+        } else if (currentView === 'search') {
+            setView('inbox');
+        }
+    }
+
+    function updateToolbar() {
+        bulkToolbar.style.display = currentView === 'search' ? 'none' : '';
+        bulkToolbar.querySelectorAll('button').forEach(btn => {
+            let visible = btn.dataset.views.split(' ').includes(currentView);
+            if (btn.classList.contains('needs-send') && !canSend()) visible = false;
+            if (btn.classList.contains('needs-admin') && !isAdmin()) visible = false;
+            btn.style.display = visible ? '' : 'none';
+        });
+    }
+
+    async function runBulkAction(action) {
+        const selected = [...conversationsList.querySelectorAll('.select-box:checked')];
+        if (selected.length === 0) return alert('Select at least one item first.');
+        if (action === 'purge' && !confirm('Permanently delete the selected messages?')) return;
+
+        const request = currentView === 'trash'
+            ? { url: `${apiBaseUrl}/sms/messages/bulk`, body: { action, ids: selected.map(el => parseInt(el.dataset.id, 10)) } }
+            : { url: `${apiBaseUrl}/sms/conversations/bulk`, body: { action, conversations: selected.map(el => ({ local_number: el.dataset.local, other_party: el.dataset.other })) } };
+        try {
+            const response = await makeAuthenticatedRequest(request.url, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(request.body)
+            });
+            const result = await response.json();
+            if (!result.success) throw new Error(result.message);
+            fetchConversations(currentPage);
+        } catch (error) {
+            if (error.message !== 'Authentication failed.') alert(`Failed to ${action}: ${error.message}`);
+        }
+    }
+
+    viewTabs.addEventListener('click', (event) => {
+        if (!event.target.dataset.view) return;
+        searchInput.value = '';
+        setView(event.target.dataset.view);
+    });
+
+    document.getElementById('search-btn').addEventListener('click', startSearch);
+    searchInput.addEventListener('keydown', (event) => { if (event.key === 'Enter') startSearch(); });
+    searchInput.addEventListener('search', () => { if (!searchInput.value) startSearch(); });
+
+    bulkToolbar.addEventListener('click', async (event) => {
+        if (event.target.dataset.action) {
+            runBulkAction(event.target.dataset.action);
+        } else if (event.target.id === 'empty-trash-btn') {
+            if (!confirm('Permanently delete everything in the trash?')) return;
+            try {
+                const response = await makeAuthenticatedRequest(`${apiBaseUrl}/sms/trash`, { method: 'DELETE' });
+                const result = await response.json();
+                if (!result.success) throw new Error(result.message);
+                fetchConversations(1);
+            } catch (error) {
+                if (error.message !== 'Authentication failed.') alert(`Failed to empty trash: ${error.message}`);
+            }
+        }
+    });
+
+    selectAll.addEventListener('change', () => {
+        conversationsList.querySelectorAll('.select-box').forEach(el => { el.checked = selectAll.checked; });
+    });
+
+    updateToolbar();
+
     function renderPagination(total, page, limit) {
         const totalPages = Math.ceil(total / limit);
         paginationContainer.innerHTML = '';
@@ -185,7 +394,9 @@ function initConversationsPage() {
     }
 
     paginationContainer.addEventListener('click', (event) => {
-        if (event.target.tagName === 'BUTTON' && event.target.dataset.page) {
+        if (event.target.tagName === 'BUTTON' && event.target.dataset.cursor) {
+            fetchConversations(1, event.target.dataset.cursor);
+        } else if (event.target.tagName === 'BUTTON' && event.target.dataset.page) {
             const page = parseInt(event.target.dataset.page, 10);
             if (page !== currentPage) {
                 fetchConversations(page);
@@ -193,6 +404,11 @@ function initConversationsPage() {
         }
     });
 
+    deviceSelect.addEventListener('change', () => {
+        if (deviceSelect.value) sendDeviceSelect.value = deviceSelect.value;
+        fetchConversations(1);
+    });
+
     newSmsBtn.addEventListener('click', () => modal.style.display = 'block');
     closeBtn.addEventListener('click', () => modal.style.display = 'none');
     window.addEventListener('click', (event) => { if (event.target == modal) modal.style.display = 'none'; });
@@ -200,28 +416,46 @@ function initConversationsPage() {
     sendNewSmsBtn.addEventListener('click', async () => {
         const recipient = document.getElementById('recipient-input').value;
         const message = document.getElementById('message-input').value;
+        const device = sendDeviceSelect.value;
         if (!recipient || !message) return alert('Recipient and message cannot be empty.');
 
         try {
             const response = await makeAuthenticatedRequest(`${apiBaseUrl}/sms/send`, {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ recipient, message })
+                body: JSON.stringify({ recipient, message, device })
             });
             const result = await response.json();
             if (result.success) {
-                window.location.href = `/conversation/${recipient}`;
+                const option = sendDeviceSelect.selectedOptions[0];
+                window.location.href = conversationUrl(recipient, option ? option.dataset.number : '');
             } else {
                 throw new Error(result.message);
             }
         } catch (error) {
-             if (error.message !== 'Authentication failed.' && error.message !== 'No secret found.') {
+             if (error.message !== 'Authentication failed.') {
                 alert(`Failed to send SMS: ${error.message}`);
             }
         }
     });
 
-    fetchConversations(currentPage);
+    // Refresh the list when messages arrive or change, unless the user is searching or has items selected
+    let refreshTimer = null;
+    subscribeEvents(type => {
+        if (!['sms', 'sms_status', 'reconnect'].includes(type) || currentView === 'search') return;
+        if (conversationsList.querySelector('.select-box:checked')) return;
+        clearTimeout(refreshTimer);
+        refreshTimer = setTimeout(() => fetchConversations(currentPage), 300);
+    });
+
+    Promise.all([loadDevices(deviceSelect, 'All SIMs'), loadDevices(sendDeviceSelect)])
+        .catch(error => console.error('Failed to load devices:', error))
+        .finally(() => fetchConversations(currentPage));
+}
+
+function conversationUrl(otherParty, localNumber) {
+    const url = `/conversation/${encodeURIComponent(otherParty)}`;
+    return localNumber && localNumber !== 'unknown' ? `${url}?local=${encodeURIComponent(localNumber)}` : url;
 }
 
 function initConversationDetailPage() {
@@ -232,11 +466,20 @@ function initConversationDetailPage() {
     const newMessagesIndicator = document.getElementById('new-messages-indicator');
     const number = numberSpan.textContent;
     const logoutBtn = document.getElementById('logout-btn');
+    const replyDeviceSelect = document.getElementById('reply-device-select');
+    const localNumber = new URLSearchParams(window.location.search).get('local') || '';
+    const localParam = localNumber ? `?local=${encodeURIComponent(localNumber)}` : '';
+    const conversationActions = document.getElementById('conversation-actions');
+    let deviceChosen = false;
+    let resolvedLocal = localNumber;
 
     let isScrolledUp = false;
-    let messageCount = 0;
 
     if(logoutBtn) logoutBtn.addEventListener('click', logout);
+    if (!canSend()) {
+        document.querySelector('.reply-area').style.display = 'none';
+        conversationActions.querySelectorAll('.needs-send').forEach(btn => { btn.style.display = 'none'; });
+    }
 
     messagesContainer.addEventListener('scroll', () => {
         const atBottom = messagesContainer.scrollHeight - messagesContainer.scrollTop === messagesContainer.clientHeight;
@@ -251,35 +494,143 @@ function initConversationDetailPage() {
         newMessagesIndicator.style.display = 'none';
     });
 
+    // Messages loaded so far by id; polling merges the latest page, "Load older" prepends earlier pages.
+    const loadedMessages = new Map();
+    let olderCursor = null;
+    let renderedKey = '';
+
+    function renderMessages(keepScrollFromBottom) {
+        const sorted = [...loadedMessages.values()].sort((a, b) =>
+            new Date(a.created_at) - new Date(b.created_at) || a.id - b.id);
+        const key = sorted.map(msg => `${msg.id}:${msg.read_at ? 1 : 0}`).join(',') + `|${olderCursor}`;
+        if (key === renderedKey) return false;
+        renderedKey = key;
+
+        const fromBottom = messagesContainer.scrollHeight - messagesContainer.scrollTop;
+        messagesContainer.innerHTML = olderCursor ? '<button class="load-older-btn">Load older messages</button>' : '';
+        sorted.forEach(msg => {
+            const div = document.createElement('div');
+            div.className = `message ${msg.direction}`;
+            const del = canSend() ? `<span class="delete-message" data-id="${msg.id}" title="Move to trash">&times;</span>` : '';
+            div.innerHTML = `${del}<p>${escapeHtml(msg.body).replace(/\n/g, '<br>')}</p><span class="timestamp">${new Date(msg.created_at).toLocaleString()}${msg.backfilled ? ' · 补录' : ''}</span>`;
+            messagesContainer.appendChild(div);
+        });
+        if (keepScrollFromBottom) {
+            messagesContainer.scrollTop = messagesContainer.scrollHeight - fromBottom;
+        }
+        return true;
+    }
+
+    async function fetchPage(cursor) {
+        const params = new URLSearchParams(localParam.slice(1));
+        if (cursor) params.set('cursor', cursor);
+        const query = params.toString() ? `?${params}` : '';
+        const response = await makeAuthenticatedRequest(`${apiBaseUrl}/sms/conversation/${encodeURIComponent(number)}${query}`);
+        const result = await response.json();
+        if (!result.success) throw new Error(result.message);
+        return result;
+    }
+
     async function fetchMessages() {
         try {
-            const response = await makeAuthenticatedRequest(`${apiBaseUrl}/sms/conversation/${number}`);
-            const result = await response.json();
-            if (!result.success) throw new Error(result.message);
+            const result = await fetchPage();
+            const firstLoad = loadedMessages.size === 0;
+            const newCount = (result.data || []).filter(msg => !loadedMessages.has(msg.id)).length;
+            (result.data || []).forEach(msg => loadedMessages.set(msg.id, msg));
+            if (firstLoad) olderCursor = result.next_cursor || null;
+
+            if (renderMessages(isScrolledUp && newCount === 0) && result.data && result.data.length > 0) {
+                const lastMsg = result.data[result.data.length - 1];
+                if (!resolvedLocal && lastMsg) {
+                    resolvedLocal = lastMsg.direction === 'incoming' ? lastMsg.to_number : lastMsg.from_number;
+                }
+                if (result.data.some(msg => msg.direction === 'incoming' && !msg.read_at)) {
+                    markRead();
+                }
 
-            if (result.data && result.data.length > messageCount) {
-                messagesContainer.innerHTML = '';
-                result.data.forEach(msg => {
-                    const div = document.createElement('div');
-                    div.className = `message ${msg.direction}`;
-                    div.innerHTML = `<p>${msg.body.replace(/\n/g, '<br>')}</p><span class="timestamp">${new Date(msg.created_at).toLocaleString()}</span>`;
-                    messagesContainer.appendChild(div);
-                });
+                // Reply from the SIM this conversation belongs to unless the user picked another one
+                const last = [...result.data].reverse().find(msg => msg.device);
+                if (!deviceChosen && last && [...replyDeviceSelect.options].some(o => o.value === last.device)) {
+                    replyDeviceSelect.value = last.device;
+                }
 
-                if (isScrolledUp) {
+                if (newCount > 0 && isScrolledUp) {
                     newMessagesIndicator.style.display = 'block';
-                } else {
+                } else if (newCount > 0) {
                     messagesContainer.scrollTop = messagesContainer.scrollHeight;
                 }
-                messageCount = result.data.length;
             }
         } catch (error) {
-            if (error.message !== 'Authentication failed.' && error.message !== 'No secret found.') {
+            if (error.message !== 'Authentication failed.') {
                 messagesContainer.innerHTML = `<p>Error loading messages: ${error.message}</p>`;
+                renderedKey = '';
             }
         }
     }
 
+    async function loadOlderMessages() {
+        if (!olderCursor) return;
+        try {
+            const result = await fetchPage(olderCursor);
+            (result.data || []).forEach(msg => loadedMessages.set(msg.id, msg));
+            olderCursor = result.next_cursor || null;
+            renderMessages(true);
+        } catch (error) {
+            if (error.message !== 'Authentication failed.') alert(`Failed to load older messages: ${error.message}`);
+        }
+    }
+
+    async function markRead() {
+        try {
+            await makeAuthenticatedRequest(`${apiBaseUrl}/sms/conversation/${encodeURIComponent(number)}/read${localParam}`, { method: 'POST' });
+        } catch (error) {
+            console.error('Failed to mark conversation as read:', error);
+        }
+    }
+
+    async function postBulk(url, body) {
+        const response = await makeAuthenticatedRequest(url, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+        const result = await response.json();
+        if (!result.success) throw new Error(result.message);
+        return result;
+    }
+
+    messagesContainer.addEventListener('click', async (event) => {
+        if (event.target.classList.contains('load-older-btn')) {
+            loadOlderMessages();
+            return;
+        }
+        if (!event.target.classList.contains('delete-message')) return;
+        try {
+            const id = parseInt(event.target.dataset.id, 10);
+            await postBulk(`${apiBaseUrl}/sms/messages/bulk`, { action: 'delete', ids: [id] });
+            loadedMessages.delete(id);
+            renderMessages(true);
+        } catch (error) {
+            if (error.message !== 'Authentication failed.') alert(`Failed to delete message: ${error.message}`);
+        }
+    });
+
+    conversationActions.addEventListener('click', async (event) => {
+        const action = event.target.dataset.action;
+        if (!action) return;
+        if (action === 'delete' && !confirm('Move this conversation to the trash?')) return;
+        try {
+            await postBulk(`${apiBaseUrl}/sms/conversations/bulk`, {
+                action,
+                conversations: [{ local_number: resolvedLocal, other_party: number }]
+            });
+            // Go back to the list so the thread is not immediately marked read again
+            window.location.href = '/';
+        } catch (error) {
+            if (error.message !== 'Authentication failed.') alert(`Failed to ${action} conversation: ${error.message}`);
+        }
+    });
+
     replyBtn.addEventListener('click', async () => {
         const message = replyInput.value;
         if (!message) return;
@@ -288,7 +639,7 @@ function initConversationDetailPage() {
             const response = await makeAuthenticatedRequest(`${apiBaseUrl}/sms/send`, {
                 method: 'POST',
                 headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ recipient: number, message })
+                body: JSON.stringify({ recipient: number, message, device: replyDeviceSelect.value })
             });
             const result = await response.json();
             if (result.success) {
@@ -298,12 +649,219 @@ function initConversationDetailPage() {
                 throw new Error(result.message);
             }
         } catch (error) {
-            if (error.message !== 'Authentication failed.' && error.message !== 'No secret found.') {
+            if (error.message !== 'Authentication failed.') {
                 alert(`Failed to send reply: ${error.message}`);
             }
         }
     });
 
-    fetchMessages();
-    setInterval(fetchMessages, 5000);
+    replyDeviceSelect.addEventListener('change', () => { deviceChosen = true; });
+
+    const live = subscribeEvents((type, event) => {
+        if (type === 'sms') {
+            const msg = event.data;
+            const other = msg.direction === 'incoming' ? msg.from_number : msg.to_number;
+            if (other !== number) return;
+        } else if (type !== 'sms_status' && type !== 'reconnect') {
+            return;
+        }
+        fetchMessages();
+    });
+
+    loadDevices(replyDeviceSelect)
+        .then(list => {
+            const own = list.find(d => d.number && d.number === localNumber);
+            if (own) replyDeviceSelect.value = own.device;
+        })
+        .catch(error => console.error('Failed to load devices:', error))
+        .finally(() => {
+            fetchMessages();
+            // Poll only while the live event stream is unavailable
+            setInterval(() => { if (!live()) fetchMessages(); }, 5000);
+        });
+}
+
+function initTokensPage() {
+    const tokensList = document.getElementById('tokens-list');
+    const createBtn = document.getElementById('create-token-btn');
+    const nameInput = document.getElementById('token-name-input');
+    const expiresInput = document.getElementById('token-expires-input');
+    const newTokenDiv = document.getElementById('new-token');
+    const logoutBtn = document.getElementById('logout-btn');
+
+    if(logoutBtn) logoutBtn.addEventListener('click', logout);
+
+    async function fetchTokens() {
+        try {
+            const response = await makeAuthenticatedRequest(`${apiBaseUrl}/tokens`);
+            const result = await response.json();
+            if (!result.success) throw new Error(result.message);
+
+            tokensList.innerHTML = '';
+            (result.data || []).forEach(token => {
+                const tr = document.createElement('tr');
+                const status = token.revoked_at ? 'revoked'
+                    : (token.expires_at && new Date(token.expires_at) < new Date() ? 'expired' : 'active');
+                tr.innerHTML = `
+                    <td>${escapeHtml(token.name)}</td>
+                    <td><code>${escapeHtml(token.prefix)}…</code></td>
+                    <td>${escapeHtml(token.scopes.join(', '))}</td>
+                    <td>${formatTime(token.expires_at)}</td>
+                    <td>${formatTime(token.last_used_at)}</td>
+                    <td>${status}</td>
+                    <td>${status === 'active' ? `<button data-id="${token.id}" class="revoke-btn">Revoke</button>` : ''}</td>
+                `;
+                tokensList.appendChild(tr);
+            });
+        } catch (error) {
+            if (error.message !== 'Authentication failed.') {
+                tokensList.innerHTML = `<tr><td colspan="7">Error loading tokens: ${escapeHtml(error.message)}</td></tr>`;
+            }
+        }
+    }
+
+    tokensList.addEventListener('click', async (event) => {
+        if (!event.target.classList.contains('revoke-btn')) return;
+        if (!confirm('Revoke this token? Clients using it will stop working.')) return;
+        try {
+            const response = await makeAuthenticatedRequest(`${apiBaseUrl}/tokens/${event.target.dataset.id}`, { method: 'DELETE' });
+            const result = await response.json();
+            if (!result.success) throw new Error(result.message);
+            fetchTokens();
+        } catch (error) {
+            if (error.message !== 'Authentication failed.') alert(`Failed to revoke token: ${error.message}`);
+        }
+    });
+
+    createBtn.addEventListener('click', async () => {
+        const name = nameInput.value.trim();
+        const scopes = Array.from(document.querySelectorAll('.token-scopes input:checked')).map(el => el.value);
+        const expiresInDays = parseInt(expiresInput.value, 10) || 0;
+        if (!name || scopes.length === 0) return alert('Name and at least one scope are required.');
+
+        try {
+            const response = await makeAuthenticatedRequest(`${apiBaseUrl}/tokens`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ name, scopes, expires_in_days: expiresInDays })
+            });
+            const result = await response.json();
+            if (!result.success) throw new Error(result.message);
+
+            newTokenDiv.style.display = 'block';
+            newTokenDiv.innerHTML = `<p>${escapeHtml(result.message)}</p><code>${escapeHtml(result.data.token)}</code>`;
+            nameInput.value = '';
+            expiresInput.value = '';
+            fetchTokens();
+        } catch (error) {
+            if (error.message !== 'Authentication failed.') alert(`Failed to create token: ${error.message}`);
+        }
+    });
+
+    fetchTokens();
+}
+
+function initDevicesPage() {
+    const devicesList = document.getElementById('devices-list');
+    const keepAliveList = document.getElementById('keepalive-list');
+    const logoutBtn = document.getElementById('logout-btn');
+
+    if(logoutBtn) logoutBtn.addEventListener('click', logout);
+
+    async function fetchJSON(url) {
+        const response = await makeAuthenticatedRequest(url);
+        const result = await response.json();
+        if (!result.success) throw new Error(result.message);
+        return result.data || [];
+    }
+
+    async function fetchDevices() {
+        try {
+            const [deviceData, balances] = await Promise.all([
+                fetchJSON(`${apiBaseUrl}/devices`),
+                fetchJSON(`${apiBaseUrl}/balance`)
+            ]);
+            const balanceByDevice = {};
+            balances.forEach(b => { balanceByDevice[b.device] = b; });
+
+            devicesList.innerHTML = '';
+            if (deviceData.length === 0) {
+                devicesList.innerHTML = '<tr><td colspan="7">No devices found.</td></tr>';
+            }
+            deviceData.forEach(d => {
+                const info = d.info || {};
+                const balance = balanceByDevice[d.device];
+                const tr = document.createElement('tr');
+                tr.innerHTML = `
+                    <td>${escapeHtml(d.device)}</td>
+                    <td>${escapeHtml(d.number || '-')}</td>
+                    <td>${escapeHtml(info.state || 'unknown')}</td>
+                    <td>${escapeHtml(info.rssi || '-')}</td>
+                    <td>${escapeHtml([info.provider, info.registration].filter(Boolean).join(' / ') || '-')}</td>
+                    <td title="${balance ? escapeHtml(balance.reply) : ''}">${balance && balance.balance != null ? escapeHtml(balance.balance.toFixed(2)) + ` <small>(${formatTime(balance.created_at)})</small>` : '-'}</td>
+                    <td>${balance && balance.expires_on ? escapeHtml(balance.expires_on) : '-'}</td>
+                `;
+                devicesList.appendChild(tr);
+            });
+        } catch (error) {
+            if (error.message !== 'Authentication failed.') {
+                devicesList.innerHTML = `<tr><td colspan="7">Error loading devices: ${escapeHtml(error.message)}</td></tr>`;
+            }
+        }
+    }
+
+    async function fetchKeepAlive() {
+        try {
+            const statuses = await fetchJSON(`${apiBaseUrl}/keepalive`);
+            keepAliveList.innerHTML = '';
+            if (statuses.length === 0) {
+                keepAliveList.innerHTML = '<tr><td colspan="6">No keep-alive policies configured in forward.yaml.</td></tr>';
+            }
+            statuses.forEach(s => {
+                const policy = s.method === 'ussd' ? `USSD ${s.ussd}` : `SMS "${s.sms_text}" to ${s.sms_to}`;
+                const last = s.last ? `${s.last.status} at ${formatTime(s.last.created_at)}` : '-';
+                const tr = document.createElement('tr');
+                tr.innerHTML = `
+                    <td>${escapeHtml(s.device)}</td>
+                    <td>${escapeHtml(policy)}, every ${s.every_days} days</td>
+                    <td>${formatTime(s.last_activity)}</td>
+                    <td>${formatTime(s.next_due)}</td>
+                    <td title="${s.last ? escapeHtml(s.last.detail) : ''}">${escapeHtml(last)}</td>
+                    <td>${isAdmin() ? `<button data-device="${escapeHtml(s.device)}" class="keepalive-btn">Send Now</button>` : ''}</td>
+                `;
+                keepAliveList.appendChild(tr);
+            });
+        } catch (error) {
+            if (error.message !== 'Authentication failed.') {
+                keepAliveList.innerHTML = `<tr><td colspan="6">Error loading keep-alive: ${escapeHtml(error.message)}</td></tr>`;
+            }
+        }
+    }
+
+    keepAliveList.addEventListener('click', async (event) => {
+        if (!event.target.classList.contains('keepalive-btn')) return;
+        const device = event.target.dataset.device;
+        if (!confirm(`Send a keep-alive on ${device} now?`)) return;
+        try {
+            const response = await makeAuthenticatedRequest(`${apiBaseUrl}/keepalive/${encodeURIComponent(device)}/run`, { method: 'POST' });
+            const result = await response.json();
+            if (!result.success) throw new Error(result.message);
+            fetchKeepAlive();
+        } catch (error) {
+            if (error.message !== 'Authentication failed.') alert(`Keep-alive failed: ${error.message}`);
+        }
+    });
+
+    let refreshTimer = null;
+    const isLive = subscribeEvents(type => {
+        if (type !== 'device' && type !== 'device_alert' && type !== 'reconnect') return;
+        clearTimeout(refreshTimer);
+        refreshTimer = setTimeout(fetchDevices, 500);
+    });
+    setInterval(() => {
+        if (!isLive()) fetchDevices();
+    }, 60000);
+
+    fetchDevices();
+    fetchKeepAlive();
 }